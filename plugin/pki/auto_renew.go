@@ -0,0 +1,139 @@
+package pki
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	defaultAutoRenewWindow = 30 * 24 * time.Hour
+	//the stored certificates are scanned less often than the periodic function is called
+	autoRenewInterval  = time.Hour
+	autoRenewStatusKey = "auto-renew/status"
+)
+
+// autoRenewStatus is the result of the last auto-renewal run
+type autoRenewStatus struct {
+	LastRun time.Time `json:"last_run"`
+	//serial numbers of the renewed certificates by the serial numbers of the previous ones
+	Renewed map[string]string `json:"renewed"`
+	//renewal errors by the serial numbers of the certificates
+	Failed map[string]string `json:"failed"`
+}
+
+func pathAutoRenewStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "auto-renew/status",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathAutoRenewStatusRead,
+		},
+
+		HelpSynopsis:    pathAutoRenewStatusHelpSyn,
+		HelpDescription: pathAutoRenewStatusHelpDesc,
+	}
+}
+
+func (b *backend) pathAutoRenewStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := req.Storage.Get(ctx, autoRenewStatusKey)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var status autoRenewStatus
+	if err := entry.DecodeJSON(&status); err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"last_run": status.LastRun.UTC().Format(time.RFC3339),
+			"renewed":  status.Renewed,
+			"failed":   status.Failed,
+		},
+	}, nil
+}
+
+// autoRenewCertificates renews the stored certificates of the auto_renew roles which expire within the role window
+func (b *backend) autoRenewCertificates(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	b.autoRenewLock.Lock()
+	defer b.autoRenewLock.Unlock()
+	if time.Since(b.lastAutoRenew) < autoRenewInterval {
+		return nil
+	}
+	b.lastAutoRenew = time.Now()
+
+	paths, err := listStoredCertificates(ctx, s, "")
+	if err != nil {
+		return err
+	}
+	status := autoRenewStatus{LastRun: time.Now(), Renewed: map[string]string{}, Failed: map[string]string{}}
+	roles := make(map[string]*roleEntry)
+	req := &logical.Request{Storage: s}
+	for _, path := range paths {
+		entry, err := s.Get(ctx, "certs/"+path)
+		if err != nil || entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil || cert.RevocationTime != 0 || cert.RenewedTo != "" || cert.Role == "" {
+			continue
+		}
+		role, ok := roles[cert.Role]
+		if !ok {
+			if role, err = b.getRole(ctx, s, cert.Role); err != nil {
+				return err
+			}
+			roles[cert.Role] = role
+		}
+		if role == nil || !role.AutoRenew {
+			continue
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil || time.Until(parsed.NotAfter) > role.autoRenewWindow() {
+			continue
+		}
+
+		b.Logger().Debug("Renewing certificate", "serial_number", cert.SerialNumber, "role", cert.Role)
+		resp, err := b.renewStoredCertificate(ctx, req, "certs/"+path, cert.Role, false)
+		switch {
+		case err != nil:
+			status.Failed[cert.SerialNumber] = err.Error()
+		case resp.IsError():
+			status.Failed[cert.SerialNumber] = resp.Data["error"].(string)
+		default:
+			status.Renewed[cert.SerialNumber] = resp.Data["serial_number"].(string)
+		}
+	}
+	if len(status.Failed) > 0 {
+		b.Logger().Warn("Failed to renew certificates, see auto-renew/status", "failed", len(status.Failed))
+	}
+
+	entry, err := logical.StorageEntryJSON(autoRenewStatusKey, status)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func (r *roleEntry) autoRenewWindow() time.Duration {
+	if r.AutoRenewWindow == 0 {
+		return defaultAutoRenewWindow
+	}
+	return r.AutoRenewWindow
+}
+
+const (
+	pathAutoRenewStatusHelpSyn = `
+Read the result of the last certificate auto-renewal
+`
+	pathAutoRenewStatusHelpDesc = `
+Certificates stored by the roles with auto_renew are renewed when they expire within
+auto_renew_window. This path returns the certificates renewed and the renewals failed
+by the last run.
+`
+)
@@ -0,0 +1,58 @@
+package pki
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestAutoRenew(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	serials := make(map[string]string)
+	for name, data := range map[string]map[string]interface{}{
+		"auto-renew": {"fakemode": true, "auto_renew": true, "auto_renew_window": "876000h"},
+		"manual":     {"fakemode": true},
+		"not-yet":    {"fakemode": true, "auto_renew": true, "auto_renew_window": "1h"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + name,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": name + ".venafi.example.com"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		serials[name] = resp.Data["serial_number"].(string)
+	}
+
+	if err := b.autoRenewCertificates(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "auto-renew/status",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	//renewal isn't supported by the fake connector, so the renewal of the expiring certificate fails
+	failed := resp.Data["failed"].(map[string]string)
+	if len(failed) != 1 || !strings.Contains(failed[serials["auto-renew"]], "not supported in -test-mode") {
+		t.Fatalf("Expecting only the renewal of %s to be attempted, got %v", serials["auto-renew"], failed)
+	}
+}
@@ -2,9 +2,12 @@ package pki
 
 import (
 	"context"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Factory creates a new backend implementing the logical.Backend interface
@@ -24,25 +27,70 @@ func Backend(conf *logical.BackendConfig) *backend {
 
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
-				"roles/",
+				"role/",
+				credentialsPrefix,
+				"keys/",
+				webhooksPrefix,
+			},
+			Unauthenticated: []string{
+				"ca/*",
+				"ca_chain/*",
+				"crl/*",
+				"ocsp/*",
 			},
 		},
 
 		Paths: []*framework.Path{
+			pathConfig(&b),
+			pathConfigAutoTidy(&b),
 			pathListRoles(&b),
 			pathRoles(&b),
+			pathRoleFull(&b),
+			pathRoleCerts(&b),
+			pathRoleRotateCredentials(&b),
+			pathRoleRotateAPIKey(&b),
+			pathRoleTestConnection(&b),
+			pathRolePolicyDrift(&b),
+			pathRoleInvalidateCAChain(&b),
+			pathListVenafiPolicies(&b),
+			pathVenafiPolicy(&b),
+			pathVenafiPolicyRefresh(&b),
 			pathVenafiCertEnroll(&b),
 			pathVenafiCertSign(&b),
+			pathVenafiCertSignVerbatim(&b),
+			pathVenafiCertCA(&b),
+			pathVenafiCertCAChain(&b),
 			pathVenafiCertRead(&b),
+			pathVenafiKeyRead(&b),
 			pathVenafiCertRevoke(&b),
+			pathVenafiCertRenew(&b),
+			pathVenafiCertImport(&b),
+			pathVenafiCertImportBulk(&b),
+			pathVenafiCertExport(&b),
+			pathAutoRenewStatus(&b),
 			pathVenafiFetchListCerts(&b),
+			pathVenafiFetchExpiringCerts(&b),
+			pathVenafiFetchSearchCerts(&b),
+			pathVenafiCA(&b),
+			pathVenafiCAChain(&b),
+			pathVenafiCRL(&b),
+			pathVenafiOCSP(&b),
+			pathVenafiOCSPGet(&b),
+			pathTidy(&b),
+			pathTidyStatus(&b),
+			pathStats(&b),
+			pathStatus(&b),
+			pathListWebhooks(&b),
+			pathWebhooks(&b),
 		},
 
 		Secrets: []*framework.Secret{
 			secretCerts(&b),
 		},
 
-		BackendType: logical.TypeLogical,
+		BackendType:  logical.TypeLogical,
+		PeriodicFunc: b.periodicFunc,
+		Invalidate:   b.invalidate,
 	}
 	b.storage = conf.StorageView
 	return &b
@@ -50,7 +98,91 @@ func Backend(conf *logical.BackendConfig) *backend {
 
 type backend struct {
 	*framework.Backend
-	storage logical.Storage
+	storage          logical.Storage
+	tokenRefreshLock sync.Mutex
+	credentialsLock  sync.Mutex
+	keysLock         sync.Mutex
+	tppHealth        tppEndpointHealth
+	clientCache      venafiClientCache
+	transports       transportCache
+	crls             crlCache
+	warmedUp         int32
+	//credentials of the roles written by the older versions were moved to credentials/
+	credentialsMigrated int32
+	autoRenewLock       sync.Mutex
+	lastAutoRenew       time.Time
+	webhooks            sync.WaitGroup
+	tidyRunning         int32
+	tidyStatus          tidyStatus
+	autoTidyLock        sync.Mutex
+	lastAutoTidy        time.Time
+	//certificates stored by the older versions were added to the search index
+	certIndexBuilt int32
+	requestLogging requestLoggingState
+	authStatus     endpointAuthStatus
+	//credentials of the roles are validated against Venafi periodically
+	credentialValidationLock sync.Mutex
+	lastCredentialValidation time.Time
+	credentialChecks         credentialCheckStatus
+	policyRefreshLock        sync.Mutex
+	issuanceLimits           issuanceLimiter
+	//CA chains are retrieved from Venafi one at a time
+	caChainLock     sync.Mutex
+	caChainFailures caChainFailures
+	//CRL downloads and OCSP responders are retried after caChainRetryInterval too
+	crlFailures  caChainFailures
+	ocspFailures caChainFailures
+}
+
+// periodicFunc is called by Vault's rollback manager on every tick
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var result error
+	if err := b.migrateRoleCredentials(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.buildCertIndex(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.refreshExpiringTPPTokens(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.validateCredentials(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.refreshVenafiPolicies(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.refreshExpiringCAChains(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.autoRenewCertificates(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := b.autoTidy(ctx, req.Storage); err != nil {
+		result = multierror.Append(result, err)
+	}
+	b.warmUpClients(ctx, req.Storage)
+	return result
+}
+
+// invalidate is called when a storage key is changed on another node, e.g. on the performance standby
+func (b *backend) invalidate(ctx context.Context, key string) {
+	if strings.HasPrefix(key, "role/") {
+		b.clientCache.purge(strings.TrimPrefix(key, "role/"))
+		b.crls.purge(strings.TrimPrefix(key, "role/"))
+		b.credentialChecks.purge(strings.TrimPrefix(key, "role/"))
+		b.issuanceLimits.purge(strings.TrimPrefix(key, "role/"))
+		b.caChainFailures.purge(strings.TrimPrefix(key, "role/"))
+		b.crlFailures.purge(strings.TrimPrefix(key, "role/"))
+		b.ocspFailures.purge(strings.TrimPrefix(key, "role/"))
+	}
+	if key == "config" {
+		b.requestLogging.reset()
+	}
+	if strings.HasPrefix(key, credentialsPrefix) {
+		b.clientCache.purge(strings.TrimPrefix(key, credentialsPrefix))
+		b.credentialChecks.purge(strings.TrimPrefix(key, credentialsPrefix))
+	}
 }
 
 const (
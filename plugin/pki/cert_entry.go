@@ -0,0 +1,31 @@
+package pki
+
+import (
+	"compress/gzip"
+	"context"
+
+	"github.com/hashicorp/vault/helper/compressutil"
+	"github.com/hashicorp/vault/helper/jsonutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+// certificateEntry returns the storage entry of the certificate, compressed with gzip if compress_certificates is
+// configured. The entries are decompressed transparently by DecodeJSON, so the compressed and the plain entries
+// written before the setting was changed are read the same way.
+func (b *backend) certificateEntry(ctx context.Context, s logical.Storage, path string, cert VenafiCert) (*logical.StorageEntry, error) {
+	config, err := b.getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if !config.CompressCertificates {
+		return logical.StorageEntryJSON(path, cert)
+	}
+	value, err := jsonutil.EncodeJSONAndCompress(cert, &compressutil.CompressionConfig{
+		Type:                 compressutil.CompressionTypeGzip,
+		GzipCompressionLevel: gzip.BestCompression,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &logical.StorageEntry{Key: path, Value: value}, nil
+}
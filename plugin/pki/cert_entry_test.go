@@ -0,0 +1,68 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/compressutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCompressCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/compress",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "store_by": "cn"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	sizes := make(map[bool]int)
+	for _, compress := range []bool{false, true} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      map[string]interface{}{"compress_certificates": compress},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		cn := fmt.Sprintf("compress-%t.venafi.example.com", compress)
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/compress",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": cn},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		issued := resp.Data["certificate"]
+
+		entry, err := storage.Get(ctx, "certs/"+cn)
+		if err != nil || entry == nil {
+			t.Fatalf("Expecting certificate stored, err: %v", err)
+		}
+		sizes[compress] = len(entry.Value)
+		if compressed := entry.Value[0] == compressutil.CompressionCanaryGzip; compressed != compress {
+			t.Fatalf("Expecting compressed entry %t, got %t", compress, compressed)
+		}
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "cert/" + cn,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() || resp.Data["certificate"] != issued {
+			t.Fatalf("Expecting certificate read from the entry, err: %v resp: %#v", err, resp)
+		}
+	}
+	if sizes[true] >= sizes[false] {
+		t.Fatalf("Expecting compressed entry smaller than %d bytes, got %d", sizes[false], sizes[true])
+	}
+}
@@ -0,0 +1,372 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	// the index entries are cert-index/<kind>/<escaped name>/<certificate uid>, so the certificates are found
+	// by listing the names without reading every stored certificate
+	certIndexPrefix      = "cert-index/"
+	certIndexCN          = "cn"
+	certIndexSAN         = "san"
+	certIndexRole        = "role"
+	certIndexSerial      = "serial"
+	certIndexFingerprint = "sha256"
+	certIndexBuiltKey    = "cert-index-built"
+	//the stored certificates are indexed again when the index gets new kinds of entries
+	certIndexVersion = "4"
+)
+
+func pathRoleCerts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/certs/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathRoleCertsList,
+		},
+
+		HelpSynopsis:    pathRoleCertsHelpSyn,
+		HelpDescription: pathRoleCertsHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleCertsList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	entries, err := req.Storage.List(ctx, certIndexPrefix+certIndexRole+"/"+url.PathEscape(roleName)+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	keyInfo := make(map[string]interface{})
+	for _, uid := range entries {
+		if uid, err = url.PathUnescape(uid); err != nil {
+			continue
+		}
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		//the certificates stored by CN are overwritten by the ones issued by other roles
+		if cert.Role != roleName {
+			continue
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			parsed = nil
+		}
+		keys = append(keys, uid)
+		keyInfo[uid] = storedCertificateInfo(cert, parsed)
+	}
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func pathVenafiFetchSearchCerts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/search",
+		Fields: map[string]*framework.FieldSchema{
+			"cn": {
+				Type:        framework.TypeString,
+				Description: `Common name of the certificates, may contain glob patterns, e.g. "*.example.com"`,
+			},
+			"san": {
+				Type:        framework.TypeString,
+				Description: "DNS name, IP address, email address or URI in the subject alternative names of the certificates, may contain glob patterns",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiFetchSearchCerts,
+		},
+
+		HelpSynopsis:    pathVenafiFetchSearchHelpSyn,
+		HelpDescription: pathVenafiFetchSearchHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiFetchSearchCerts(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	cn := strings.ToLower(data.Get("cn").(string))
+	san := strings.ToLower(data.Get("san").(string))
+	if cn == "" && san == "" {
+		return logical.ErrorResponse("cn or san must be specified"), nil
+	}
+	for _, pattern := range []string{cn, san} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid pattern %q: %s", pattern, err)), nil
+		}
+	}
+
+	var uids map[string]bool
+	for kind, pattern := range map[string]string{certIndexCN: cn, certIndexSAN: san} {
+		if pattern == "" {
+			continue
+		}
+		found, err := searchCertIndex(ctx, req.Storage, kind, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if uids == nil {
+			uids = found
+			continue
+		}
+		for uid := range uids {
+			if !found[uid] {
+				delete(uids, uid)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(uids))
+	keyInfo := make(map[string]interface{})
+	for uid := range uids {
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			continue
+		}
+		//the certificates stored by CN are overwritten by the new ones, which can have other names
+		if (cn != "" && !matchesAny(cn, certIndexNames(parsed)[certIndexCN])) ||
+			(san != "" && !matchesAny(san, certIndexNames(parsed)[certIndexSAN])) {
+			continue
+		}
+		keys = append(keys, uid)
+		keyInfo[uid] = storedCertificateInfo(cert, parsed)
+	}
+	sort.Strings(keys)
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// searchCertIndex returns the uids of the certificates with the names of the kind matching the pattern
+func searchCertIndex(ctx context.Context, s logical.Storage, kind string, pattern string) (map[string]bool, error) {
+	prefix := certIndexPrefix + kind + "/"
+	names, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	uids := make(map[string]bool)
+	for _, escaped := range names {
+		name, err := url.PathUnescape(strings.TrimSuffix(escaped, "/"))
+		if err != nil {
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); !matched {
+			continue
+		}
+		entries, err := s.List(ctx, prefix+escaped)
+		if err != nil {
+			return nil, err
+		}
+		for _, uid := range entries {
+			if uid, err := url.PathUnescape(uid); err == nil {
+				uids[uid] = true
+			}
+		}
+	}
+	return uids, nil
+}
+
+func matchesAny(pattern string, names []string) bool {
+	for _, name := range names {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// certIndexNames returns the lower case common name and subject alternative names of the certificate
+func certIndexNames(cert *x509.Certificate) map[string][]string {
+	names := map[string][]string{}
+	if cert.Subject.CommonName != "" {
+		names[certIndexCN] = []string{strings.ToLower(cert.Subject.CommonName)}
+	}
+	for _, name := range cert.DNSNames {
+		names[certIndexSAN] = append(names[certIndexSAN], strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		names[certIndexSAN] = append(names[certIndexSAN], ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		names[certIndexSAN] = append(names[certIndexSAN], strings.ToLower(email))
+	}
+	for _, uri := range cert.URIs {
+		names[certIndexSAN] = append(names[certIndexSAN], strings.ToLower(uri.String()))
+	}
+	return names
+}
+
+func certIndexKeys(storagePath string, cert *x509.Certificate, role string) []string {
+	//the uids of the certificates stored under storage_prefix contain slashes
+	uid := url.PathEscape(strings.TrimPrefix(storagePath, "certs/"))
+	keys := []string{certIndexPrefix + certIndexFingerprint + "/" + certFingerprint(cert.Raw) + "/" + uid}
+	if serial, err := getHexFormatted(cert.SerialNumber.Bytes(), ":"); err == nil {
+		keys = append(keys, certIndexPrefix+certIndexSerial+"/"+normalizeSerial(serial)+"/"+uid)
+	}
+	if role != "" {
+		keys = append(keys, certIndexPrefix+certIndexRole+"/"+url.PathEscape(role)+"/"+uid)
+	}
+	for kind, names := range certIndexNames(cert) {
+		for _, name := range names {
+			keys = append(keys, certIndexPrefix+kind+"/"+url.PathEscape(name)+"/"+uid)
+		}
+	}
+	return keys
+}
+
+// resolveCertificatePath returns the storage path of the certificate with the uid, which is the common name,
+// serial number or SHA-256 fingerprint of the certificate depending on the store_by setting of the role. The serial
+// numbers and fingerprints are looked up in the index, so they find the certificates stored by any attribute.
+func resolveCertificatePath(ctx context.Context, s logical.Storage, uid string) (string, error) {
+	path := "certs/" + uid
+	entry, err := s.Get(ctx, path)
+	if err != nil || entry != nil {
+		return path, err
+	}
+	for _, kind := range []string{certIndexSerial, certIndexFingerprint} {
+		uids, err := s.List(ctx, certIndexPrefix+kind+"/"+strings.ToLower(uid)+"/")
+		if err != nil {
+			return "", err
+		}
+		for _, indexed := range uids {
+			if indexed, err = url.PathUnescape(indexed); err != nil {
+				continue
+			}
+			if entry, err := s.Get(ctx, "certs/"+indexed); err != nil || entry != nil {
+				return "certs/" + indexed, err
+			}
+		}
+	}
+	return path, nil
+}
+
+// indexCertificate adds the certificate of the role stored in the path to the index
+func indexCertificate(ctx context.Context, s logical.Storage, storagePath string, certPEM string, role string) error {
+	parsed, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	for _, key := range certIndexKeys(storagePath, parsed, role) {
+		if err := s.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte(storagePath)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexCertificate removes the certificate of the role deleted from the path from the index
+func unindexCertificate(ctx context.Context, s logical.Storage, storagePath string, cert *x509.Certificate, role string) error {
+	for _, key := range certIndexKeys(storagePath, cert, role) {
+		if err := s.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildCertIndex indexes the certificates stored by the older versions. Like the credentials migration
+// it is done once on the first periodic tick after the plugin is upgraded.
+func (b *backend) buildCertIndex(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&b.certIndexBuilt, 0, 1) {
+		return nil
+	}
+	entry, err := s.Get(ctx, certIndexBuiltKey)
+	if err != nil {
+		atomic.StoreInt32(&b.certIndexBuilt, 0)
+		return err
+	}
+	if entry != nil && string(entry.Value) == certIndexVersion {
+		return nil
+	}
+
+	paths, err := listStoredCertificates(ctx, s, "")
+	if err != nil {
+		atomic.StoreInt32(&b.certIndexBuilt, 0)
+		return err
+	}
+	var result error
+	for _, uid := range paths {
+		entry, err := s.Get(ctx, "certs/"+uid)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			continue
+		}
+		if err := indexCertificate(ctx, s, "certs/"+uid, cert.Certificate, cert.Role); err != nil {
+			b.Logger().Warn("Failed to index certificate", "path", "certs/"+uid, "error", err)
+		}
+	}
+	if result == nil {
+		result = s.Put(ctx, &logical.StorageEntry{Key: certIndexBuiltKey, Value: []byte(certIndexVersion)})
+	}
+	if result != nil {
+		//the index is built again on the next tick
+		atomic.StoreInt32(&b.certIndexBuilt, 0)
+		return result
+	}
+	b.Logger().Info("Indexed stored certificates", "count", len(paths))
+	return nil
+}
+
+const pathVenafiFetchSearchHelpSyn = `
+Search the stored certificates by common name or subject alternative name.
+`
+
+const pathVenafiFetchSearchHelpDesc = `
+Returns the stored certificates whose common name matches cn and one of the subject alternative
+names matches san. The names are matched case insensitively and may contain glob patterns,
+e.g. "*.example.com". The uids of the certificates are returned with their key_info.
+`
+
+const pathRoleCertsHelpSyn = `
+List the stored certificates issued by the role.
+`
+
+const pathRoleCertsHelpDesc = `
+Returns the uids of the stored certificates issued by the role with their key_info, e.g. to
+revoke them when the role is decommissioned. Certificates stored by the versions which didn't
+record the issuing role are not listed.
+`
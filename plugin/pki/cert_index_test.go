@@ -0,0 +1,163 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestSearchCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/search",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	uids := make(map[string]string)
+	for cn, altNames := range map[string]string{
+		"web.venafi.example.com": "www.venafi.example.com",
+		"api.venafi.example.com": "api.example.org",
+	} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/search",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": cn, "alt_names": altNames},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		uids[cn] = normalizeSerial(resp.Data["serial_number"].(string))
+	}
+
+	//the certificate stored by an older version is indexed by the periodic function
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "old.venafi.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{}, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := logical.StorageEntryJSON("certs/old", VenafiCert{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.buildCertIndex(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		query    map[string]interface{}
+		expected []string
+	}{
+		{map[string]interface{}{"cn": "*.VENAFI.example.com"}, []string{uids["web.venafi.example.com"], uids["api.venafi.example.com"], "old"}},
+		{map[string]interface{}{"cn": "web.venafi.example.com"}, []string{uids["web.venafi.example.com"]}},
+		{map[string]interface{}{"san": "*.example.org"}, []string{uids["api.venafi.example.com"]}},
+		{map[string]interface{}{"cn": "web.*", "san": "*.example.org"}, []string{}},
+		{map[string]interface{}{"cn": "unknown.example.com"}, []string{}},
+	} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "certs/search",
+			Storage:   storage,
+			Data:      c.query,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		keys, _ := resp.Data["keys"].([]string)
+		if keys == nil {
+			keys = []string{}
+		}
+		sort.Strings(c.expected)
+		if !reflect.DeepEqual(keys, c.expected) {
+			t.Fatalf("Expecting %v found by %v, got %v", c.expected, c.query, keys)
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "certs/search",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error without cn and san, got err: %v resp: %#v", err, resp)
+	}
+}
+
+func TestRoleCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	uids := make(map[string][]string)
+	for _, role := range []string{"team-a", "team-b"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		for _, host := range []string{"web", "api"} {
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "issue/" + role,
+				Storage:   storage,
+				Data:      map[string]interface{}{"common_name": host + "." + role + ".venafi.example.com"},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("bad: err: %v resp: %#v", err, resp)
+			}
+			uids[role] = append(uids[role], normalizeSerial(resp.Data["serial_number"].(string)))
+		}
+	}
+
+	for role, expected := range uids {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "roles/" + role + "/certs/",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		keys := resp.Data["keys"].([]string)
+		sort.Strings(keys)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("Expecting certificates %v of role %s, got %v", expected, role, keys)
+		}
+		if info := resp.Data["key_info"].(map[string]interface{})[keys[0]].(map[string]interface{}); info["role"] != role {
+			t.Fatalf("Unexpected certificate info %v", info)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package pki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	// Platform API keys expire after a period of inactivity, so clients are not kept longer than this
+	clientCacheTTL = 2 * time.Minute
+	//the clients of the roles used within this are warmed up again on every periodic tick
+	clientWarmUpWindow = 30 * time.Minute
+)
+
+// venafiClientCache keeps authenticated Venafi clients of the roles
+type venafiClientCache struct {
+	sync.Mutex
+	//role name -> config hash -> client
+	roles map[string]map[string]*cachedClient
+	//role name -> last time a client of the role was requested
+	used map[string]time.Time
+}
+
+type cachedClient struct {
+	connector endpoint.Connector
+	created   time.Time
+}
+
+// clientCacheKey returns the hash of the connection settings, so the client is rebuilt when any of them changes
+func clientCacheKey(role *roleEntry, tppURL string, zone string) (string, error) {
+	settings, err := json.Marshal(struct {
+		Role   *roleEntry
+		TPPURL string
+		Zone   string
+	}{role, tppURL, zone})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(settings)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *venafiClientCache) get(roleName string, key string) endpoint.Connector {
+	c.Lock()
+	defer c.Unlock()
+	cached, ok := c.roles[roleName][key]
+	if !ok {
+		return nil
+	}
+	if time.Since(cached.created) > clientCacheTTL {
+		delete(c.roles[roleName], key)
+		return nil
+	}
+	return cached.connector
+}
+
+func (c *venafiClientCache) put(roleName string, key string, connector endpoint.Connector) {
+	c.Lock()
+	defer c.Unlock()
+	if c.roles == nil {
+		c.roles = make(map[string]map[string]*cachedClient)
+	}
+	if c.roles[roleName] == nil {
+		c.roles[roleName] = make(map[string]*cachedClient)
+	}
+	c.roles[roleName][key] = &cachedClient{connector: connector, created: time.Now()}
+}
+
+// purge removes all cached clients of the role
+func (c *venafiClientCache) purge(roleName string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.roles, roleName)
+}
+
+func (c *venafiClientCache) markUsed(roleName string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.used == nil {
+		c.used = make(map[string]time.Time)
+	}
+	c.used[roleName] = time.Now()
+}
+
+func (c *venafiClientCache) recentlyUsed(roleName string) bool {
+	c.Lock()
+	defer c.Unlock()
+	used, ok := c.used[roleName]
+	return ok && time.Since(used) < clientWarmUpWindow
+}
+
+// fresh returns true if the role has a client which doesn't expire before the next periodic tick
+func (c *venafiClientCache) fresh(roleName string) bool {
+	c.Lock()
+	defer c.Unlock()
+	for _, cached := range c.roles[roleName] {
+		if time.Since(cached.created) < clientCacheTTL/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// warmUpClients authenticates the clients of all roles and reads their zones once after the plugin is started,
+// so the first certificate request doesn't have to wait for it. This version of the Vault SDK doesn't have
+// an initialize callback, so it is done on the first periodic tick. The cached clients expire after clientCacheTTL,
+// so on the next ticks the clients of the roles used within clientWarmUpWindow are replaced before they expire.
+func (b *backend) warmUpClients(ctx context.Context, s logical.Storage) {
+	first := atomic.CompareAndSwapInt32(&b.warmedUp, 0, 1)
+
+	roles, err := s.List(ctx, "role/")
+	if err != nil {
+		b.Logger().Warn("Failed to list roles for clients warm up", "error", err)
+		return
+	}
+	for _, roleName := range roles {
+		if !first && (!b.clientCache.recentlyUsed(roleName) || b.clientCache.fresh(roleName)) {
+			continue
+		}
+		b.clientCache.purge(roleName)
+		cl, _, err := b.venafiClient(ctx, &logical.Request{Storage: s}, roleName, "", "")
+		if err != nil {
+			b.Logger().Warn("Failed to warm up client", "role", roleName, "error", err)
+			continue
+		}
+		if _, err := cl.ReadZoneConfiguration(); err != nil {
+			b.Logger().Warn("Failed to read zone", "role", roleName, "error", err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package pki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultCloudURL = "https://api.venafi.cloud/v1"
+
+// cloudAPIClient is a minimal Venafi Cloud API client for the calls which are not covered by vcert
+type cloudAPIClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+type cloudAPIKey struct {
+	Key               string `json:"key,omitempty"`
+	Username          string `json:"username,omitempty"`
+	APIKeyStatus      string `json:"apiKeyStatus,omitempty"`
+	ValidityStartDate string `json:"validityStartDate,omitempty"`
+	ValidityEndDate   string `json:"validityEndDate,omitempty"`
+}
+
+type cloudUserDetails struct {
+	APIKey *cloudAPIKey `json:"apiKey,omitempty"`
+}
+
+type cloudErrorResponse struct {
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (b *backend) newCloudAPIClient(role *roleEntry) (*cloudAPIClient, error) {
+	client, err := b.getHTTPClient(role)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := strings.TrimSuffix(role.CloudURL, "/")
+	if baseURL == "" {
+		baseURL = defaultCloudURL
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "https://" + baseURL
+	}
+	if !strings.HasSuffix(baseURL, "/v1") {
+		baseURL = baseURL + "/v1"
+	}
+	return &cloudAPIClient{
+		baseURL: baseURL,
+		apiKey:  role.Apikey,
+		client:  client,
+	}, nil
+}
+
+func (c *cloudAPIClient) request(method, resource string, in interface{}, out interface{}) error {
+	var payload []byte
+	var err error
+	if in != nil {
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/"+resource, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("tppl-api-key", c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var cloudErr cloudErrorResponse
+		if json.Unmarshal(body, &cloudErr) == nil && len(cloudErr.Errors) > 0 {
+			return fmt.Errorf("%s %s failed: %s: %s", method, resource, resp.Status, cloudErr.Errors[0].Message)
+		}
+		return fmt.Errorf("%s %s failed: %s", method, resource, resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+// userDetails returns information about the user and the API key used for the request
+func (c *cloudAPIClient) userDetails() (*cloudUserDetails, error) {
+	var resp cloudUserDetails
+	if err := c.request(http.MethodGet, "useraccounts", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// rotateAPIKey issues a new API key and invalidates the one used for the request
+func (c *cloudAPIClient) rotateAPIKey() (*cloudAPIKey, error) {
+	var resp cloudUserDetails
+	if err := c.request(http.MethodPost, "useraccounts/rotateapikey", nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.APIKey == nil || resp.APIKey.Key == "" {
+		return nil, fmt.Errorf("Venafi Cloud didn't return a new API key")
+	}
+	return resp.APIKey, nil
+}
+
+type cloudCertificateRequest struct {
+	CSR            string `json:"certificateSigningRequest"`
+	ZoneID         string `json:"zoneId"`
+	ValidityPeriod string `json:"validityPeriod,omitempty"`
+}
+
+type cloudCertificateRequestResponse struct {
+	CertificateRequests []struct {
+		ID string `json:"id"`
+	} `json:"certificateRequests"`
+}
+
+// requestCertificate requests the certificate for the CSR in the zone with the validity period, which vcert can't set
+func (c *cloudAPIClient) requestCertificate(zoneTag string, csr string, validity time.Duration) (string, error) {
+	var zone struct {
+		ID string `json:"id"`
+	}
+	if err := c.request(http.MethodGet, "zones/tag/"+url.PathEscape(zoneTag), nil, &zone); err != nil {
+		return "", err
+	}
+	var resp cloudCertificateRequestResponse
+	err := c.request(http.MethodPost, "certificaterequests", cloudCertificateRequest{
+		CSR:            csr,
+		ZoneID:         zone.ID,
+		ValidityPeriod: cloudValidityPeriod(validity),
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.CertificateRequests) == 0 || resp.CertificateRequests[0].ID == "" {
+		return "", fmt.Errorf("Venafi Cloud didn't return the ID of the certificate request")
+	}
+	return resp.CertificateRequests[0].ID, nil
+}
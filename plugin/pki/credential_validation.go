@@ -0,0 +1,112 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// credentialValidationInterval is how often the credentials of the roles are checked against Venafi
+const credentialValidationInterval = time.Hour
+
+// credentialCheckStatus keeps the result of the last credential validation of the roles
+type credentialCheckStatus struct {
+	sync.Mutex
+	roles map[string]credentialCheck
+}
+
+type credentialCheck struct {
+	checked time.Time
+	err     string
+}
+
+func (s *credentialCheckStatus) put(roleName string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.roles == nil {
+		s.roles = make(map[string]credentialCheck)
+	}
+	check := credentialCheck{checked: time.Now()}
+	if err != nil {
+		check.err = scrub(err.Error())
+	}
+	s.roles[roleName] = check
+}
+
+func (s *credentialCheckStatus) get(roleName string) (credentialCheck, bool) {
+	s.Lock()
+	defer s.Unlock()
+	check, ok := s.roles[roleName]
+	return check, ok
+}
+
+// purge removes the result of the role, e.g. when its credentials are changed
+func (s *credentialCheckStatus) purge(roleName string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.roles, roleName)
+}
+
+// validateCredentials authenticates with the credentials of every role and reads its zone, so expired or revoked
+// credentials are reported by the status path and the role reads before the issuance fails
+func (b *backend) validateCredentials(ctx context.Context, s logical.Storage) error {
+	b.credentialValidationLock.Lock()
+	defer b.credentialValidationLock.Unlock()
+	if time.Since(b.lastCredentialValidation) < credentialValidationInterval {
+		return nil
+	}
+	b.lastCredentialValidation = time.Now()
+
+	roleNames, err := s.List(ctx, "role/")
+	if err != nil {
+		return err
+	}
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, s, roleName)
+		if err != nil {
+			return err
+		}
+		if role == nil || role.Fakemode {
+			continue
+		}
+		err = b.validateRoleCredentials(role)
+		if err != nil {
+			b.Logger().Warn("Credential validation failed", "role", roleName, "error", err)
+		}
+		b.credentialChecks.put(roleName, err)
+	}
+	return nil
+}
+
+func (b *backend) validateRoleCredentials(role *roleEntry) error {
+	if role.accessTokenExpired() {
+		return fmt.Errorf("access token expired at %s", role.AccessTokenExpiry.UTC().Format(time.RFC3339))
+	}
+	//a new client authenticates again, the cached one can be authenticated before the credentials were revoked
+	cl, err := b.newVenafiClient(role, "", role.Zone)
+	if err != nil {
+		return err
+	}
+	//an access token is not checked until it is used
+	if _, err := cl.ReadZoneConfiguration(); err != nil {
+		return fmt.Errorf("failed to read zone %s: %s", role.Zone, err)
+	}
+	return nil
+}
+
+// accessTokenExpired returns true if the access token of the role is expired and it can't be refreshed
+func (r *roleEntry) accessTokenExpired() bool {
+	return r.AccessToken != "" && r.RefreshToken == "" && !r.AccessTokenExpiry.IsZero() && time.Now().After(r.AccessTokenExpiry)
+}
+
+// addCredentialWarnings warns about the failed credential validation of the role
+func (b *backend) addCredentialWarnings(resp *logical.Response, roleName string, role *roleEntry) {
+	if check, ok := b.credentialChecks.get(roleName); ok && check.err != "" {
+		resp.AddWarning(fmt.Sprintf("Credential validation failed at %s: %s", formatStatusTime(check.checked), check.err))
+	} else if role.accessTokenExpired() {
+		resp.AddWarning(fmt.Sprintf("Access token expired at %s", formatStatusTime(role.AccessTokenExpiry)))
+	}
+}
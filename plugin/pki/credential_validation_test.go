@@ -0,0 +1,97 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"Error":"Username or password is incorrect"}`)
+	}))
+	defer server.Close()
+
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	for role, data := range map[string]map[string]interface{}{
+		"validate-fake": {"fakemode": true},
+		"validate-tpp":  {"tpp_url": server.URL + "/vedsdk", "tpp_user": "admin", "tpp_password": "expired", "zone": "devops"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	if err := b.validateCredentials(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.credentialChecks.get("validate-fake"); ok {
+		t.Fatalf("Expected the credentials of the fakemode role not to be validated")
+	}
+
+	readRole := func(path string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp
+	}
+	for _, path := range []string{"roles/validate-tpp", "roles/validate-tpp/full"} {
+		if resp := readRole(path); len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "Credential validation failed") {
+			t.Fatalf("Expected a credential validation warning on %s, got %v", path, resp.Warnings)
+		}
+	}
+	if resp := readRole("roles/validate-fake"); len(resp.Warnings) != 0 {
+		t.Fatalf("Unexpected warnings %v", resp.Warnings)
+	}
+
+	resp := readRole("status")
+	credentials := resp.Data["credentials"].(map[string]interface{})
+	if check, ok := credentials["validate-tpp"].(map[string]interface{}); !ok || check["error"] == "" {
+		t.Fatalf("Expected the failed validation in the status, got %v", credentials)
+	}
+	if resp.Data["healthy"] != false {
+		t.Fatalf("Expected the status to be degraded, got %v", resp.Data)
+	}
+
+	//the validation is not repeated before the interval passes
+	b.credentialChecks.purge("validate-tpp")
+	if err := b.validateCredentials(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.credentialChecks.get("validate-tpp"); ok {
+		t.Fatalf("Expected the credentials not to be validated again before the interval passes")
+	}
+
+	//the result is dropped when the credentials are changed
+	b.credentialChecks.put("validate-tpp", fmt.Errorf("unauthorized"))
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/validate-tpp",
+		Storage:   storage,
+		Data:      map[string]interface{}{"tpp_url": server.URL + "/vedsdk", "tpp_user": "admin", "tpp_password": "new", "zone": "devops"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if resp := readRole("roles/validate-tpp"); len(resp.Warnings) != 0 {
+		t.Fatalf("Expected the warning to be dropped after the credentials were changed, got %v", resp.Warnings)
+	}
+}
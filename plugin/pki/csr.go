@@ -0,0 +1,241 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+)
+
+// CSRs with smaller RSA keys are rejected by Venafi
+const minCSRRSAKeyBits = 2048
+
+var (
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+	//DNS name with an optional leading wildcard label
+	dnsNameRegex = regexp.MustCompile(`^(\*\.)?([a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?\.)*[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?\.?$`)
+)
+
+// names of the extensions which are usually requested in CSRs
+var extensionNames = map[string]string{
+	"2.5.29.14":          "subject key identifier",
+	"2.5.29.15":          "key usage",
+	"2.5.29.17":          "subject alternative name",
+	"2.5.29.19":          "basic constraints",
+	"2.5.29.32":          "certificate policies",
+	"2.5.29.37":          "extended key usage",
+	"1.3.6.1.5.5.7.1.1":  "authority information access",
+	"1.3.6.1.5.5.7.1.24": "TLS feature",
+}
+
+func parseCSRPEM(csrPEM string) (*x509.CertificateRequest, error) {
+	pemBlock, _ := pem.Decode([]byte(csrPEM))
+	if pemBlock == nil {
+		return nil, fmt.Errorf("csr contains no data")
+	}
+	return x509.ParseCertificateRequest(pemBlock.Bytes)
+}
+
+// validateCSR checks the CSR locally, so the request isn't sent to Venafi if it would be rejected anyway.
+// The role key settings are not applied to verbatim requests.
+func validateCSR(csr *x509.CertificateRequest, role *roleEntry, verbatim bool) error {
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("CSR signature is invalid: %s", err)
+	}
+
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits := key.N.BitLen()
+		if bits < minCSRRSAKeyBits {
+			return fmt.Errorf("CSR RSA key has %d bits but at least %d are required", bits, minCSRRSAKeyBits)
+		}
+		if !verbatim && role.KeyType == "rsa" && bits < role.KeyBits {
+			return fmt.Errorf("CSR RSA key has %d bits but role requires at least %d", bits, role.KeyBits)
+		}
+		if !verbatim && role.KeyType == "ec" {
+			return fmt.Errorf("role requires EC key but CSR contains RSA key")
+		}
+	case *ecdsa.PublicKey:
+		if !verbatim && role.KeyType == "rsa" {
+			return fmt.Errorf("role requires RSA key but CSR contains EC key")
+		}
+	default:
+		return fmt.Errorf("CSR key type is not supported, only RSA and EC keys can be used")
+	}
+
+	if csr.Subject.CommonName == "" && len(csr.DNSNames) == 0 && len(csr.IPAddresses) == 0 && len(csr.EmailAddresses) == 0 {
+		return fmt.Errorf("CSR contains neither common name nor SANs")
+	}
+	//the common name is usually a DNS name, but it doesn't have to be one
+	if cn := csr.Subject.CommonName; strings.Contains(cn, "*") && !dnsNameRegex.MatchString(cn) {
+		return fmt.Errorf("CSR common name %q is not a valid wildcard DNS name", cn)
+	}
+	for _, name := range csr.DNSNames {
+		if !dnsNameRegex.MatchString(name) {
+			return fmt.Errorf("CSR DNS SAN %q is not a valid DNS name", name)
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if !isEmailAddress(email) {
+			return fmt.Errorf("CSR email SAN %q is not a valid email address", email)
+		}
+	}
+
+	for _, ext := range csr.Extensions {
+		if role.ClientAuthOnly && ext.Id.Equal(oidExtensionExtKeyUsage) {
+			var oids []asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(ext.Value, &oids); err != nil {
+				return fmt.Errorf("CSR extended key usage is invalid: %s", err)
+			}
+			for _, oid := range oids {
+				if oid.Equal(oidExtKeyUsageServerAuth) || oid.Equal(oidExtKeyUsageAny) {
+					return fmt.Errorf(errorTextServerAuthWithClientAuthOnly)
+				}
+			}
+		}
+		if !ext.Id.Equal(oidExtensionBasicConstraints) {
+			continue
+		}
+		var constraints struct {
+			IsCA bool `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &constraints); err == nil && constraints.IsCA {
+			return fmt.Errorf("CSR requests a CA certificate, only end entity certificates can be issued")
+		}
+	}
+	return nil
+}
+
+func isEmailAddress(email string) bool {
+	i := strings.LastIndex(email, "@")
+	return i > 0 && i < len(email)-1 && !strings.ContainsAny(email, " \t")
+}
+
+// rejectedCSRExtensions returns the extensions requested in the CSR which the CA didn't include in the certificate
+func rejectedCSRExtensions(csr *x509.CertificateRequest, cert *x509.Certificate) []string {
+	issued := make(map[string]bool, len(cert.Extensions))
+	for _, ext := range cert.Extensions {
+		issued[ext.Id.String()] = true
+	}
+	var rejected []string
+	for _, ext := range csr.Extensions {
+		if !issued[ext.Id.String()] {
+			rejected = append(rejected, extensionName(ext.Id))
+		}
+	}
+	return rejected
+}
+
+func extensionName(oid asn1.ObjectIdentifier) string {
+	if name, ok := extensionNames[oid.String()]; ok {
+		return fmt.Sprintf("%s (%s)", name, oid)
+	}
+	return oid.String()
+}
+
+// otherSAN is an otherName SAN, e.g. Microsoft UPN 1.3.6.1.4.1.311.20.2.3
+type otherSAN struct {
+	oid   asn1.ObjectIdentifier
+	value string
+}
+
+func (o otherSAN) String() string {
+	return o.oid.String() + ";UTF8:" + o.value
+}
+
+// parseOtherSANs parses other_sans in the oid;type:value format. Only UTF8 values are supported.
+func parseOtherSANs(values []string) ([]otherSAN, error) {
+	var result []otherSAN
+	for _, v := range values {
+		split := strings.SplitN(v, ";", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("other_sans value %q is not in oid;type:value format", v)
+		}
+		var oid asn1.ObjectIdentifier
+		for _, part := range strings.Split(split[0], ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("other_sans value %q has invalid OID %s", v, split[0])
+			}
+			oid = append(oid, n)
+		}
+		if len(oid) < 2 {
+			return nil, fmt.Errorf("other_sans value %q has invalid OID %s", v, split[0])
+		}
+		typeValue := strings.SplitN(split[1], ":", 2)
+		if len(typeValue) != 2 {
+			return nil, fmt.Errorf("other_sans value %q is not in oid;type:value format", v)
+		}
+		if t := strings.ToUpper(typeValue[0]); t != "UTF8" && t != "UTF-8" {
+			return nil, fmt.Errorf("other_sans value %q has unsupported type %s, only UTF8 is supported", v, typeValue[0])
+		}
+		result = append(result, otherSAN{oid: oid, value: typeValue[1]})
+	}
+	return result, nil
+}
+
+// rebuildCSR replaces the CSR of the request with the CSR signed by the request private key which SAN extension
+// contains other names in addition to the request SANs and which has the extra extensions, because vcert can't
+// create such CSRs
+func rebuildCSR(req *certificate.Request, others []otherSAN, extensions []pkix.Extension) error {
+	var names []asn1.RawValue
+	for _, name := range req.DNSNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, Bytes: []byte(name)})
+	}
+	for _, email := range req.EmailAddresses {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, Bytes: []byte(email)})
+	}
+	for _, uri := range req.URIs {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri.String())})
+	}
+	for _, ip := range req.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: ip})
+	}
+	for _, o := range others {
+		oid, err := asn1.Marshal(o.oid)
+		if err != nil {
+			return err
+		}
+		value, err := asn1.MarshalWithParams(o.value, "utf8")
+		if err != nil {
+			return err
+		}
+		explicitValue, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value})
+		if err != nil {
+			return err
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+			Bytes: append(oid, explicitValue...)})
+	}
+	if len(names) > 0 {
+		sans, err := asn1.Marshal(names)
+		if err != nil {
+			return err
+		}
+		extensions = append([]pkix.Extension{{Id: oidExtensionSubjectAltName, Value: sans}}, extensions...)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         req.Subject,
+		Attributes:      req.Attributes,
+		ExtraExtensions: extensions,
+	}, req.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return req.SetCSR(csr)
+}
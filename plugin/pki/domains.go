@@ -0,0 +1,218 @@
+package pki
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/identity"
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	errorTextDomainNotAllowed      = `%s is not in allowed_domains of the role`
+	errorTextDomainDenied          = `%s is in denied_domains of the role`
+	errorTextWildcardNotAllowed    = `wildcard %s is not allowed by the role, set allow_wildcard_certificates to enable it`
+	errorTextInvalidDomainTemplate = `Invalid identity template in allowed_domains %s: %s`
+	errorTextInvalidHostname       = `%s is not a valid hostname, it's rejected because enforce_hostnames is set`
+)
+
+const maxHostnameLength = 253
+
+// requestedDomainNames returns the common name and the DNS names of alt_names which the domain restrictions of the
+// role apply to, IP addresses and email addresses are restricted by allow_ip_sans and allow_email_sans instead
+func requestedDomainNames(commonName string, altNames []string) []string {
+	var names []string
+	if commonName != "" && net.ParseIP(commonName) == nil {
+		names = append(names, commonName)
+	}
+	for _, name := range altNames {
+		if net.ParseIP(name) != nil || strings.Contains(name, "@") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// validateRequestedNames rejects the requested names which are not allowed wildcards, not valid hostnames, denied or not allowed domains,
+// denied_domains are checked before allowed_domains
+func validateRequestedNames(role *roleEntry, allowedDomains []string, names []string) error {
+	if err := validateNameConstraints(role, names); err != nil {
+		return err
+	}
+	return validateDomainNames(role, allowedDomains, names)
+}
+
+// validateNameConstraints rejects the names which are not allowed wildcards, not valid hostnames or denied. Unlike
+// allowed_domains these constraints apply to verbatim CSRs too
+func validateNameConstraints(role *roleEntry, names []string) error {
+	if !role.AllowWildcards {
+		for _, name := range names {
+			if strings.Contains(name, "*") {
+				return fmt.Errorf(errorTextWildcardNotAllowed, name)
+			}
+		}
+	}
+	if role.EnforceHostnames {
+		for _, name := range names {
+			if !validHostname(name, role.AllowUnderscores) {
+				return fmt.Errorf(errorTextInvalidHostname, name)
+			}
+		}
+	}
+	for _, name := range names {
+		if role.domainDenied(name) {
+			return fmt.Errorf(errorTextDomainDenied, name)
+		}
+	}
+	return nil
+}
+
+// validHostname checks name is a RFC 1123 hostname, the leftmost label can be a wildcard. The domain part is checked
+// for email addresses in common names
+func validHostname(name string, allowUnderscores bool) bool {
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > maxHostnameLength {
+		return false
+	}
+	name = strings.TrimPrefix(name, "*.")
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			case c == '_' && allowUnderscores:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateDomainNames rejects the names which are not in allowedDomains, the allowed_domains of the role with the
+// identity templates rendered. Any name is allowed if allowed_domains is empty
+func validateDomainNames(role *roleEntry, allowedDomains []string, names []string) error {
+	if len(role.AllowedDomains) == 0 {
+		return nil
+	}
+	for _, name := range names {
+		if !role.domainAllowed(allowedDomains, name) {
+			return fmt.Errorf(errorTextDomainNotAllowed, name)
+		}
+	}
+	return nil
+}
+
+// domainDenied checks name against denied_domains, which are matched as globs
+func (r *roleEntry) domainDenied(name string) bool {
+	name = normalizeDomainName(name)
+	for _, domain := range r.DeniedDomains {
+		if strutil.StrListContainsGlob([]string{strings.ToLower(strings.TrimSuffix(domain, "."))}, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomainName returns name in lower case without the trailing dot, or the domain part of an email address
+func normalizeDomainName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// domainAllowed checks name against allowed_domains, the domain part is checked for email addresses in common names.
+// Domains containing "*" are matched as globs if allow_glob_domains is set
+func (r *roleEntry) domainAllowed(allowedDomains []string, name string) bool {
+	name = normalizeDomainName(name)
+	for _, domain := range allowedDomains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if r.AllowGlobDomains && strings.Contains(domain, "*") {
+			if strutil.StrListContainsGlob([]string{domain}, name) {
+				return true
+			}
+			continue
+		}
+		if r.AllowBareDomains && name == domain {
+			return true
+		}
+		if r.AllowSubdomains && strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDomainTemplates rejects malformed identity templates in allowed_domains when allowed_domains_template is set
+func validateDomainTemplates(role *roleEntry) error {
+	if !role.AllowedDomainsTemplate {
+		return nil
+	}
+	for _, domain := range role.AllowedDomains {
+		if _, _, err := identity.PopulateString(&identity.PopulateStringInput{ValidityCheckOnly: true, String: domain}); err != nil {
+			return fmt.Errorf(errorTextInvalidDomainTemplate, domain, err)
+		}
+	}
+	return nil
+}
+
+// allowedDomains returns allowed_domains of the role with the identity templates rendered for the entity of the request.
+// The templated domains which can't be rendered, e.g. for requests without an entity, don't allow any name
+func (b *backend) allowedDomains(req *logical.Request, role *roleEntry) []string {
+	if !role.AllowedDomainsTemplate {
+		return role.AllowedDomains
+	}
+	var entity *identity.Entity
+	if req.EntityID != "" {
+		info, err := b.System().EntityInfo(req.EntityID)
+		if err != nil {
+			b.Logger().Warn("Failed to read the entity of the request", "entity_id", req.EntityID, "error", err)
+		} else if info != nil {
+			entity = identityEntity(info)
+		}
+	}
+	domains := make([]string, 0, len(role.AllowedDomains))
+	for _, domain := range role.AllowedDomains {
+		if !strings.Contains(domain, "{{") {
+			domains = append(domains, domain)
+			continue
+		}
+		if entity == nil {
+			continue
+		}
+		_, rendered, err := identity.PopulateString(&identity.PopulateStringInput{String: domain, Entity: entity})
+		if err != nil {
+			b.Logger().Debug("Skipping allowed domain template", "domain", domain, "entity_id", req.EntityID, "error", err)
+			continue
+		}
+		domains = append(domains, rendered)
+	}
+	return domains
+}
+
+// identityEntity converts the entity returned by the system view to the type used by the identity templates
+func identityEntity(info *logical.Entity) *identity.Entity {
+	entity := &identity.Entity{
+		ID:       info.ID,
+		Name:     info.Name,
+		Metadata: info.Metadata,
+	}
+	for _, alias := range info.Aliases {
+		entity.Aliases = append(entity.Aliases, &identity.Alias{
+			MountType:     alias.MountType,
+			MountAccessor: alias.MountAccessor,
+			Name:          alias.Name,
+		})
+	}
+	return entity
+}
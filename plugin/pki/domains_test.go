@@ -0,0 +1,626 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRoleIPSANs(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, allow := range map[string]bool{"ip-denied": false, "ip-allowed": true} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "allow_ip_sans": allow},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/ip-allowed",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "ip.venafi.example.com", "ip_sans": "10.0.0.1,::1"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.IPAddresses) != 2 {
+		t.Fatalf("Expecting 2 IP SANs in certificate, got %v", cert.IPAddresses)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/ip-allowed",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "ip.venafi.example.com", "ip_sans": "10.0.0.300"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting invalid IP address to be rejected")
+	}
+
+	for _, data := range []map[string]interface{}{
+		{"common_name": "ip.venafi.example.com", "ip_sans": "10.0.0.1"},
+		{"common_name": "ip.venafi.example.com", "alt_names": "10.0.0.1"},
+		{"common_name": "10.0.0.1", "exclude_cn_from_sans": true},
+	} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/ip-denied",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.Data["error"] != errorTextIPSANsNotAllowed {
+			t.Fatalf("Expecting error %s but got %#v", errorTextIPSANsNotAllowed, resp)
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "10.0.0.1"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/ip-denied",
+		Storage:   storage,
+		Data:      map[string]interface{}{"csr": csrPEM},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextIPSANsNotAllowed {
+		t.Fatalf("Expecting error %s for the IP address common name of the CSR but got %#v", errorTextIPSANsNotAllowed, resp)
+	}
+}
+
+func TestRoleURISANs(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/uri",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "allowed_uri_sans": "spiffe://example.com/*"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/uri",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "uri.venafi.example.com", "uri_sans": "spiffe://example.com/web"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/uri",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "uri.venafi.example.com", "uri_sans": "spiffe://other.com/web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(errorTextURISANNotAllowed, "spiffe://other.com/web")
+	if resp == nil || resp.Data["error"] != expected {
+		t.Fatalf("Expecting error %s but got %#v", expected, resp)
+	}
+
+	role, err := b.getRole(context.Background(), storage, "uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certReq, err := formRequest(requestData{commonName: "uri.venafi.example.com", uriSANs: []string{"spiffe://example.com/web"}},
+		role, false, b.Logger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certReq.URIs) != 1 || certReq.URIs[0].String() != "spiffe://example.com/web" {
+		t.Fatalf("Expecting URI SAN in certificate request, got %v", certReq.URIs)
+	}
+}
+
+func TestRoleEmailSANs(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, allow := range map[string]bool{"email-denied": false, "email-allowed": true} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "allow_email_sans": allow},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/email-allowed",
+		Storage:   storage,
+		Data:      map[string]interface{}{"email_sans": "user@venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "user@venafi.example.com" {
+		t.Fatalf("Expecting email SAN in certificate, got %v", cert.EmailAddresses)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/email-allowed",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "email.venafi.example.com", "email_sans": "user"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting invalid email address to be rejected")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/email-denied",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "email.venafi.example.com", "email_sans": "user@venafi.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextEmailSANsNotAllowed {
+		t.Fatalf("Expecting error %s but got %#v", errorTextEmailSANsNotAllowed, resp)
+	}
+}
+
+func TestExcludeCNFromSANs(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/exclude-cn",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	for _, exclude := range []bool{false, true} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/exclude-cn",
+			Storage:   storage,
+			Data: map[string]interface{}{"common_name": "cn.venafi.example.com", "alt_names": "san.venafi.example.com",
+				"exclude_cn_from_sans": exclude},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sliceContains(cert.DNSNames, "cn.venafi.example.com") == exclude {
+			t.Fatalf("exclude_cn_from_sans %v: unexpected DNS SANs %v", exclude, cert.DNSNames)
+		}
+	}
+}
+
+func TestRoleAllowedDomains(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"domains":    {"allowed_domains": "venafi.example.com"},
+		"subdomains": {"allowed_domains": "venafi.example.com", "allow_subdomains": true},
+		"no-bare":    {"allowed_domains": "venafi.example.com", "allow_subdomains": true, "allow_bare_domains": false},
+		"globs":      {"allowed_domains": "*.eng.venafi.example.com,web-*.venafi.example.com", "allow_glob_domains": true},
+		"no-globs":   {"allowed_domains": "*.eng.venafi.example.com"},
+	} {
+		data["fakemode"] = true
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	for _, c := range []struct {
+		role    string
+		data    map[string]interface{}
+		allowed string
+	}{
+		{"domains", map[string]interface{}{"common_name": "venafi.example.com", "alt_names": "Venafi.Example.com,10.0.0.1"}, ""},
+		{"domains", map[string]interface{}{"common_name": "www.venafi.example.com"}, "www.venafi.example.com"},
+		{"domains", map[string]interface{}{"common_name": "venafi.example.com", "alt_names": "other.example.com"}, "other.example.com"},
+		{"subdomains", map[string]interface{}{"common_name": "www.venafi.example.com", "alt_names": "*.venafi.example.com"}, ""},
+		{"subdomains", map[string]interface{}{"common_name": "wwwvenafi.example.com"}, "wwwvenafi.example.com"},
+		{"subdomains", map[string]interface{}{"common_name": "user@venafi.example.com"}, ""},
+		{"no-bare", map[string]interface{}{"common_name": "www.venafi.example.com"}, ""},
+		{"no-bare", map[string]interface{}{"common_name": "venafi.example.com"}, "venafi.example.com"},
+		{"globs", map[string]interface{}{"common_name": "ci.eng.venafi.example.com", "alt_names": "web-01.venafi.example.com"}, ""},
+		{"globs", map[string]interface{}{"common_name": "eng.venafi.example.com"}, "eng.venafi.example.com"},
+		{"globs", map[string]interface{}{"common_name": "api-01.venafi.example.com"}, "api-01.venafi.example.com"},
+		{"no-globs", map[string]interface{}{"common_name": "ci.eng.venafi.example.com"}, "ci.eng.venafi.example.com"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + c.role,
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.allowed == "" && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %v to be allowed by role %s, got %#v", c.data, c.role, resp)
+		}
+		expected := fmt.Sprintf(errorTextDomainNotAllowed, c.allowed)
+		if c.allowed != "" && (resp == nil || resp.Data["error"] != expected) {
+			t.Fatalf("Expecting error %s but got %#v", expected, resp)
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "www.venafi.example.com"},
+		DNSNames: []string{"other.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/subdomains",
+		Storage:   storage,
+		Data:      map[string]interface{}{"csr": csrPEM},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(errorTextDomainNotAllowed, "other.example.com")
+	if resp == nil || resp.Data["error"] != expected {
+		t.Fatalf("Expecting error %s but got %#v", expected, resp)
+	}
+}
+
+func TestRoleEnforceHostnames(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"hostnames":   {"enforce_hostnames": true},
+		"underscores": {"enforce_hostnames": true, "allow_underscores": true},
+		"any":         {},
+	} {
+		data["fakemode"] = true
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	for _, c := range []struct {
+		role    string
+		data    map[string]interface{}
+		invalid string
+	}{
+		{"hostnames", map[string]interface{}{"common_name": "www.venafi.example.com.", "alt_names": "*.venafi.example.com,10.0.0.1"}, ""},
+		{"hostnames", map[string]interface{}{"common_name": "user@venafi.example.com"}, ""},
+		{"hostnames", map[string]interface{}{"common_name": "www venafi.example.com"}, "www venafi.example.com"},
+		{"hostnames", map[string]interface{}{"common_name": "www.venafi.example.com", "alt_names": "www..venafi.example.com"}, "www..venafi.example.com"},
+		{"hostnames", map[string]interface{}{"common_name": "-www.venafi.example.com"}, "-www.venafi.example.com"},
+		{"hostnames", map[string]interface{}{"common_name": "www.*.example.com"}, "www.*.example.com"},
+		{"hostnames", map[string]interface{}{"common_name": strings.Repeat("a", 64) + ".example.com"}, strings.Repeat("a", 64) + ".example.com"},
+		{"hostnames", map[string]interface{}{"common_name": "_srv.venafi.example.com"}, "_srv.venafi.example.com"},
+		{"underscores", map[string]interface{}{"common_name": "_srv.venafi.example.com"}, ""},
+		{"any", map[string]interface{}{"common_name": "www venafi.example.com"}, ""},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + c.role,
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.invalid == "" && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %v to be allowed by role %s, got %#v", c.data, c.role, resp)
+		}
+		expected := fmt.Sprintf(errorTextInvalidHostname, c.invalid)
+		if c.invalid != "" && (resp == nil || resp.Data["error"] != expected) {
+			t.Fatalf("Expecting error %s but got %#v", expected, resp)
+		}
+	}
+}
+
+func TestRoleDeniedDomains(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, data := range map[string]map[string]interface{}{
+		"denied":         {"denied_domains": "*.prod-admin.venafi.example.com,prod-admin.venafi.example.com"},
+		"denied-allowed": {"denied_domains": "*.prod-admin.venafi.example.com", "allowed_domains": "venafi.example.com", "allow_subdomains": true},
+	} {
+		data["fakemode"] = true
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	for _, c := range []struct {
+		role   string
+		data   map[string]interface{}
+		denied string
+	}{
+		{"denied", map[string]interface{}{"common_name": "www.venafi.example.com", "alt_names": "admin.venafi.example.com"}, ""},
+		{"denied", map[string]interface{}{"common_name": "Prod-Admin.venafi.example.com"}, "Prod-Admin.venafi.example.com"},
+		{"denied", map[string]interface{}{"common_name": "www.venafi.example.com", "alt_names": "db.prod-admin.venafi.example.com"}, "db.prod-admin.venafi.example.com"},
+		{"denied", map[string]interface{}{"common_name": "admin@db.prod-admin.venafi.example.com"}, "admin@db.prod-admin.venafi.example.com"},
+		{"denied-allowed", map[string]interface{}{"common_name": "www.venafi.example.com"}, ""},
+		{"denied-allowed", map[string]interface{}{"common_name": "db.prod-admin.venafi.example.com"}, "db.prod-admin.venafi.example.com"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + c.role,
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.denied == "" && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %v to be allowed by role %s, got %#v", c.data, c.role, resp)
+		}
+		expected := fmt.Sprintf(errorTextDomainDenied, c.denied)
+		if c.denied != "" && (resp == nil || resp.Data["error"] != expected) {
+			t.Fatalf("Expecting error %s but got %#v", expected, resp)
+		}
+	}
+}
+
+func TestSignVerbatimNameConstraints(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/verbatim-denied",
+		Storage:   storage,
+		Data: map[string]interface{}{"fakemode": true, "denied_domains": "*.prod-admin.venafi.example.com",
+			"allow_wildcard_certificates": false, "allowed_domains": "other.example.com"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//allowed_domains isn't applied to verbatim CSRs, but denied_domains and allow_wildcard_certificates are
+	for _, c := range []struct {
+		template x509.CertificateRequest
+		expected string
+	}{
+		{x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.venafi.example.com"}}, ""},
+		{x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.venafi.example.com"}, DNSNames: []string{"db.prod-admin.venafi.example.com"}},
+			fmt.Sprintf(errorTextDomainDenied, "db.prod-admin.venafi.example.com")},
+		{x509.CertificateRequest{Subject: pkix.Name{CommonName: "*.venafi.example.com"}},
+			fmt.Sprintf(errorTextWildcardNotAllowed, "*.venafi.example.com")},
+	} {
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &c.template, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "sign-verbatim/verbatim-denied",
+			Storage:   storage,
+			Data:      map[string]interface{}{"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.expected == "" && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %s to be signed verbatim, got %#v", c.template.Subject.CommonName, resp)
+		}
+		if c.expected != "" && (resp == nil || resp.Data["error"] != c.expected) {
+			t.Fatalf("Expecting error %s but got %#v", c.expected, resp)
+		}
+	}
+}
+
+func TestRoleWildcardCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, allow := range map[string]interface{}{"wildcards": nil, "no-wildcards": false} {
+		data := map[string]interface{}{"fakemode": true}
+		if allow != nil {
+			data["allow_wildcard_certificates"] = allow
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	for _, c := range []struct {
+		role     string
+		data     map[string]interface{}
+		wildcard string
+	}{
+		{"wildcards", map[string]interface{}{"common_name": "*.venafi.example.com"}, ""},
+		{"no-wildcards", map[string]interface{}{"common_name": "www.venafi.example.com"}, ""},
+		{"no-wildcards", map[string]interface{}{"common_name": "*.venafi.example.com"}, "*.venafi.example.com"},
+		{"no-wildcards", map[string]interface{}{"common_name": "www.venafi.example.com", "alt_names": "*.venafi.example.com"}, "*.venafi.example.com"},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + c.role,
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.wildcard == "" && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %v to be allowed by role %s, got %#v", c.data, c.role, resp)
+		}
+		expected := fmt.Sprintf(errorTextWildcardNotAllowed, c.wildcard)
+		if c.wildcard != "" && (resp == nil || resp.Data["error"] != expected) {
+			t.Fatalf("Expecting error %s but got %#v", expected, resp)
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "www.venafi.example.com"},
+		DNSNames: []string{"*.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/no-wildcards",
+		Storage:   storage,
+		Data:      map[string]interface{}{"csr": csrPEM},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(errorTextWildcardNotAllowed, "*.venafi.example.com")
+	if resp == nil || resp.Data["error"] != expected {
+		t.Fatalf("Expecting error %s but got %#v", expected, resp)
+	}
+}
+
+func TestRoleAllowedDomainsTemplate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	b.System().(*logical.StaticSystemView).EntityVal = &logical.Entity{
+		ID:       "entity-id",
+		Name:     "web01",
+		Metadata: map[string]string{"team": "payments"},
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/template",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "allowed_domains_template": true, "allowed_domains": "{{identity.entity.name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Data["error"].(string), "Invalid identity template") {
+		t.Fatalf("Expecting malformed template to be rejected, got %#v", resp)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/template",
+		Storage:   storage,
+		Data: map[string]interface{}{"fakemode": true, "allowed_domains_template": true, "allow_subdomains": true,
+			"allowed_domains": "{{identity.entity.name}}.venafi.example.com,{{identity.entity.metadata.team}}.venafi.example.com,shared.venafi.example.com"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	for _, c := range []struct {
+		entityID string
+		name     string
+		allowed  bool
+	}{
+		{"entity-id", "web01.venafi.example.com", true},
+		{"entity-id", "api.payments.venafi.example.com", true},
+		{"entity-id", "shared.venafi.example.com", true},
+		{"entity-id", "web02.venafi.example.com", false},
+		{"", "web01.venafi.example.com", false},
+		{"", "shared.venafi.example.com", true},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/template",
+			Storage:   storage,
+			EntityID:  c.entityID,
+			Data:      map[string]interface{}{"common_name": c.name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.allowed && (resp == nil || resp.IsError()) {
+			t.Fatalf("Expecting %s to be allowed for entity %q, got %#v", c.name, c.entityID, resp)
+		}
+		expected := fmt.Sprintf(errorTextDomainNotAllowed, c.name)
+		if !c.allowed && (resp == nil || resp.Data["error"] != expected) {
+			t.Fatalf("Expecting error %s but got %#v", expected, resp)
+		}
+	}
+}
@@ -250,7 +250,7 @@ func (e *testEnv) readRolesInBackend(t *testing.T, config map[string]interface{}
 		t.Fatalf("failed to read role %s, %#v", e.RoleName, resp)
 	}
 
-	sensitiveData := []string{"tpp_password", "apikey"}
+	sensitiveData := []string{"tpp_password", "apikey", "access_token", "refresh_token"}
 
 	for k, v := range config {
 		if sliceContains(sensitiveData, k) {
@@ -320,11 +320,8 @@ func (e *testEnv) IssueCertificateAndSaveSerial(t *testing.T, data testData, con
 	if data.keyPassword != "" {
 		encryptedKey := resp.Data["private_key"].(string)
 		b, _ := pem.Decode([]byte(encryptedKey))
-		b.Bytes, err = x509.DecryptPEMBlock(b, []byte(data.keyPassword))
-		if err != nil {
-			t.Fatal(err)
-		}
-		data.privateKey = string(pem.EncodeToMemory(b))
+		der := decryptPKCS8PrivateKey(t, b, []byte(data.keyPassword))
+		data.privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
 	} else {
 		data.privateKey = resp.Data["private_key"].(string)
 	}
@@ -0,0 +1,54 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+const minFIPSRSAKeyBits = 2048
+
+// fipsCurves are the curves approved by FIPS 186-4 which Venafi can issue certificates for
+var fipsCurves = []string{"P256", "P384", "P521"}
+
+// validateFIPSRole rejects role key settings which are not approved for FIPS deployments
+func validateFIPSRole(role *roleEntry) error {
+	switch role.KeyType {
+	case "rsa":
+		if role.KeyBits < minFIPSRSAKeyBits {
+			return fmt.Errorf("fips_mode requires key_bits of at least %d", minFIPSRSAKeyBits)
+		}
+	case "ec":
+		if !sliceContains(fipsCurves, role.KeyCurve) {
+			return fmt.Errorf("fips_mode doesn't allow key_curve %s, it must be one of %v", role.KeyCurve, fipsCurves)
+		}
+	case "any":
+		//the signed CSRs are checked instead
+	default:
+		return fmt.Errorf("fips_mode doesn't allow key_type %s", role.KeyType)
+	}
+	return nil
+}
+
+// validateFIPSCSR rejects CSRs with keys or signatures which are not approved for FIPS deployments
+func validateFIPSCSR(csr *x509.CertificateRequest) error {
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		//smaller keys are rejected by validateCSR in any mode
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("fips_mode doesn't allow CSR EC key on curve %s", key.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("fips_mode allows only RSA and EC keys in CSR")
+	}
+	switch csr.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.MD5WithRSA, x509.MD2WithRSA, x509.DSAWithSHA1:
+		return fmt.Errorf("fips_mode doesn't allow CSR signature algorithm %s", csr.SignatureAlgorithm)
+	}
+	return nil
+}
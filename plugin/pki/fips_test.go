@@ -0,0 +1,74 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestFIPSMode(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	write := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	//roles written before fips_mode was enabled are checked at issuance
+	if resp := write("roles/p224", map[string]interface{}{"fakemode": true, "key_type": "ec", "key_curve": "P224"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := write("config", map[string]interface{}{"fips_mode": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := write("issue/p224", map[string]interface{}{"common_name": "fips.venafi.example.com"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error for P224 role, got %#v", resp)
+	}
+	if resp := write("roles/p224-new", map[string]interface{}{"fakemode": true, "key_type": "ec", "key_curve": "P224"}); resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error for P224 role write, got %#v", resp)
+	}
+
+	if resp := write("roles/fips", map[string]interface{}{"fakemode": true, "key_type": "ec", "key_curve": "P256"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	//the key encrypted with key_password is PKCS#8, the legacy PEM encryption isn't FIPS approved
+	resp := write("issue/fips", map[string]interface{}{"common_name": "fips.venafi.example.com", "key_password": "password"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["private_key"].(string)))
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("Expecting PKCS#8 private key encrypted by key_password")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "fips.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = write("sign/fips", map[string]interface{}{"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error for P224 CSR, got %#v", resp)
+	}
+}
@@ -0,0 +1,177 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns    = 100
+	defaultIdleConnTimeout = 90 * time.Second
+	defaultTCPKeepAlive    = 30 * time.Second
+)
+
+//roles stored before the connection settings were added have zero values, so defaults are used for them
+
+func (r *roleEntry) maxIdleConns() int {
+	if r.MaxIdleConns == 0 {
+		return defaultMaxIdleConns
+	}
+	return r.MaxIdleConns
+}
+
+func (r *roleEntry) idleConnTimeout() time.Duration {
+	if r.IdleConnTimeout == 0 {
+		return defaultIdleConnTimeout
+	}
+	return r.IdleConnTimeout
+}
+
+func (r *roleEntry) tcpKeepAlive() time.Duration {
+	if r.TCPKeepAlive == 0 {
+		return defaultTCPKeepAlive
+	}
+	return r.TCPKeepAlive
+}
+
+// transportCache shares HTTP transports between clients with the same connection settings
+type transportCache struct {
+	sync.Mutex
+	transports map[string]http.RoundTripper
+}
+
+func (c *transportCache) get(b *backend, role *roleEntry) (http.RoundTripper, error) {
+	trustBundle, err := b.getTrustBundle(role)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := json.Marshal(struct {
+		TrustBundle            string
+		ServerCertFingerprints []string
+		HTTPProxy              string
+		SOCKS5Proxy            string
+		MaxIdleConns           int
+		IdleConnTimeout        time.Duration
+		TCPKeepAlive           time.Duration
+	}{trustBundle, role.ServerCertFingerprints, role.HTTPProxy, role.SOCKS5Proxy,
+		role.maxIdleConns(), role.idleConnTimeout(), role.tcpKeepAlive()})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(settings)
+	key := hex.EncodeToString(sum[:])
+
+	c.Lock()
+	defer c.Unlock()
+	if transport, ok := c.transports[key]; ok {
+		return transport, nil
+	}
+	transport, err := b.newTransport(role)
+	if err != nil {
+		return nil, err
+	}
+	if c.transports == nil {
+		c.transports = make(map[string]http.RoundTripper)
+	}
+	c.transports[key] = keepAliveTransport{transport}
+	return c.transports[key], nil
+}
+
+// keepAliveTransport keeps connections open for the following requests.
+// vcert asks to close the connection after each Platform request, which exhausts ephemeral ports under load.
+type keepAliveTransport struct {
+	transport http.RoundTripper
+}
+
+func (t keepAliveTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !req.Close {
+		return t.transport.RoundTrip(req)
+	}
+	//RoundTrip must not modify the request, so a shallow copy is changed
+	r := *req
+	r.Close = false
+	return t.transport.RoundTrip(&r)
+}
+
+// maxLoggedErrorBody limits the size of the Venafi error response logged by log_venafi_requests
+const maxLoggedErrorBody = 4096
+
+// requestLoggingState caches log_venafi_requests of the mount config, it's checked on every Venafi API call
+type requestLoggingState struct {
+	sync.Mutex
+	loaded  bool
+	enabled bool
+}
+
+func (s *requestLoggingState) set(enabled bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.loaded, s.enabled = true, enabled
+}
+
+// reset makes the setting to be read from the storage again, e.g. after the config was changed on another node
+func (s *requestLoggingState) reset() {
+	s.Lock()
+	defer s.Unlock()
+	s.loaded = false
+}
+
+func (b *backend) logVenafiRequests() bool {
+	b.requestLogging.Lock()
+	defer b.requestLogging.Unlock()
+	if !b.requestLogging.loaded {
+		config, err := b.getConfig(context.Background(), b.storage)
+		if err != nil {
+			return false
+		}
+		b.requestLogging.loaded, b.requestLogging.enabled = true, config.LogVenafiRequests
+	}
+	return b.requestLogging.enabled
+}
+
+// loggingTransport logs the summary of every Venafi API call at the debug level if log_venafi_requests is configured
+type loggingTransport struct {
+	b         *backend
+	transport http.RoundTripper
+}
+
+func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := t.b.Logger()
+	if !logger.IsDebug() || !t.b.logVenafiRequests() {
+		return t.transport.RoundTrip(req)
+	}
+
+	started := time.Now()
+	resp, err := t.transport.RoundTrip(req)
+	//the query can contain the credentials, so only the path is logged
+	args := []interface{}{"method", req.Method, "endpoint", req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		"duration", time.Since(started)}
+	if err != nil {
+		logger.Debug("Venafi API request failed", append(args, "error", err)...)
+		return resp, err
+	}
+	args = append(args, "status", resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest && resp.Body != nil {
+		body, readErr := ioutil.ReadAll(io.LimitReader(resp.Body, maxLoggedErrorBody))
+		//the caller reads the whole body, so the logged part is put back in front of the rest
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		if readErr == nil {
+			args = append(args, "error_body", string(body))
+		}
+	}
+	logger.Debug("Venafi API request", args...)
+	return resp, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
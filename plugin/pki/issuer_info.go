@@ -0,0 +1,330 @@
+package pki
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	defaultCAChainTTL = 24 * time.Hour
+	//the chain of issued certificates is not written to storage more often than this
+	caChainRefreshInterval = time.Hour
+	//the CA chain of the role is not retrieved from Venafi again for this long after it failed
+	caChainRetryInterval = 5 * time.Minute
+)
+
+// issuerInfo keeps information about the issuing CA of the role, learned from the issued certificates
+type issuerInfo struct {
+	CRLDistributionPoints []string `json:"crl_distribution_points"`
+	OCSPServers           []string `json:"ocsp_servers"`
+	//PEM certificates starting from the issuing CA
+	CAChain        []string  `json:"ca_chain"`
+	CAChainFetched time.Time `json:"ca_chain_fetched"`
+}
+
+func (b *backend) getIssuerInfo(ctx context.Context, s logical.Storage, roleName string) (*issuerInfo, error) {
+	entry, err := s.Get(ctx, "issuer/"+roleName)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var info issuerInfo
+	if err := entry.DecodeJSON(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (b *backend) putIssuerInfo(ctx context.Context, s logical.Storage, roleName string, info *issuerInfo) error {
+	//performance standby can't write, it will be recorded on the active node
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+	entry, err := logical.StorageEntryJSON("issuer/"+roleName, info)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// updateIssuerInfo records the issuing CA information of the certificate issued for the role
+func (b *backend) updateIssuerInfo(ctx context.Context, s logical.Storage, roleName string, cert *x509.Certificate, chain []string) error {
+	info, err := b.getIssuerInfo(ctx, s, roleName)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		info = &issuerInfo{}
+	} else if SameStringSlice(info.CRLDistributionPoints, cert.CRLDistributionPoints) &&
+		SameStringSlice(info.OCSPServers, cert.OCSPServer) &&
+		(len(chain) == 0 || strings.Join(info.CAChain, "\n") == strings.Join(chain, "\n")) &&
+		time.Since(info.CAChainFetched) < caChainRefreshInterval {
+		return nil
+	}
+	info.CRLDistributionPoints = cert.CRLDistributionPoints
+	info.OCSPServers = cert.OCSPServer
+	if len(chain) > 0 {
+		info.CAChain = chain
+		info.CAChainFetched = time.Now()
+	}
+	return b.putIssuerInfo(ctx, s, roleName, info)
+}
+
+// issuerFirstChain returns the chain ordered from the issuing CA to the root
+func issuerFirstChain(chain []string, chainOption string) []string {
+	if chainOption != "first" {
+		return chain
+	}
+	reversed := make([]string, len(chain))
+	for i, c := range chain {
+		reversed[len(chain)-1-i] = c
+	}
+	return reversed
+}
+
+// selectPreferredChain cuts the chain at the certificate matching preferred_chain by common name or SHA-256
+// fingerprint, so the same root is returned when the CA is cross-signed by several roots
+func (b *backend) selectPreferredChain(chain []string, preferred string, chainOption string) []string {
+	fingerprint := strings.ToLower(strings.Replace(preferred, ":", "", -1))
+	issuerFirst := issuerFirstChain(chain, chainOption)
+	for i, certPEM := range issuerFirst {
+		cert, err := parseCertificatePEM(certPEM)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(cert.Raw)
+		if cert.Subject.CommonName == preferred || hex.EncodeToString(sum[:]) == fingerprint {
+			//issuerFirstChain is its own inverse
+			return issuerFirstChain(issuerFirst[:i+1], chainOption)
+		}
+	}
+	b.Logger().Warn("No certificate in the chain matches preferred_chain, returning the chain unchanged", "preferred_chain", preferred)
+	return chain
+}
+
+// caChainFailures remembers the failed retrievals of the CA chains, so Venafi isn't asked again for every request
+type caChainFailures struct {
+	sync.Mutex
+	roles map[string]caChainFailure
+}
+
+type caChainFailure struct {
+	failed time.Time
+	err    error
+}
+
+func (f *caChainFailures) put(roleName string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.roles == nil {
+		f.roles = make(map[string]caChainFailure)
+	}
+	f.roles[roleName] = caChainFailure{failed: time.Now(), err: err}
+}
+
+// get returns the error of the retrieval failed less than caChainRetryInterval ago
+func (f *caChainFailures) get(roleName string) error {
+	f.Lock()
+	defer f.Unlock()
+	failure, ok := f.roles[roleName]
+	if !ok || time.Since(failure.failed) >= caChainRetryInterval {
+		return nil
+	}
+	return fmt.Errorf("%s, it is retried after %s", failure.err, failure.failed.Add(caChainRetryInterval).UTC().Format(time.RFC3339))
+}
+
+// purge removes the failure of the role, e.g. when its configuration is changed
+func (f *caChainFailures) purge(roleName string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.roles, roleName)
+}
+
+// getCachedCAChain returns the stored CA chain of the role, even if ca_chain_ttl has expired. It is used by the
+// unauthenticated paths, which must not make requests to Venafi or write to the storage.
+func (b *backend) getCachedCAChain(ctx context.Context, s logical.Storage, roleName string) ([]string, error) {
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("unknown role: %s", roleName)
+	}
+	info, err := b.getIssuerInfo(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || len(info.CAChain) == 0 {
+		return nil, fmt.Errorf("CA chain of role %s is not known yet, it is learned from issued certificates or retrieved from Venafi periodically", roleName)
+	}
+	return info.CAChain, nil
+}
+
+// getCAChain returns the CA chain of the role starting from the issuing CA. The chain learned from the issued
+// certificates is used until ca_chain_ttl expires, then the chain of a certificate from the role zone is retrieved
+// from Venafi.
+func (b *backend) getCAChain(ctx context.Context, req *logical.Request, roleName string) ([]string, error) {
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("unknown role: %s", roleName)
+	}
+	info, err := b.getIssuerInfo(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil && len(info.CAChain) > 0 && time.Since(info.CAChainFetched) < role.caChainTTL() {
+		return info.CAChain, nil
+	}
+	return b.refreshCAChain(ctx, req, roleName, info)
+}
+
+func (b *backend) refreshCAChain(ctx context.Context, req *logical.Request, roleName string, info *issuerInfo) ([]string, error) {
+	b.caChainLock.Lock()
+	defer b.caChainLock.Unlock()
+
+	//the chain could be refreshed by another request while this one was waiting for the lock
+	current, err := b.getIssuerInfo(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && len(current.CAChain) > 0 && (info == nil || current.CAChainFetched.After(info.CAChainFetched)) {
+		return current.CAChain, nil
+	}
+	info = current
+
+	err = b.caChainFailures.get(roleName)
+	var chain []string
+	var cert *x509.Certificate
+	if err == nil {
+		chain, cert, err = b.fetchCAChain(ctx, req, roleName)
+		if err != nil {
+			b.caChainFailures.put(roleName, err)
+		}
+	}
+	if err != nil {
+		//the chain rarely changes, so the expired one is better than nothing
+		if info != nil && len(info.CAChain) > 0 {
+			b.Logger().Warn("Failed to refresh CA chain, using the expired one", "role", roleName, "error", err)
+			return info.CAChain, nil
+		}
+		return nil, err
+	}
+	if info == nil {
+		info = &issuerInfo{}
+	}
+	info.CAChain = chain
+	info.CAChainFetched = time.Now()
+	//the CRL and OCSP endpoints of the zone CA are known from Venafi before any certificate is issued for the role
+	if cert != nil && (len(cert.CRLDistributionPoints) > 0 || len(cert.OCSPServer) > 0) {
+		info.CRLDistributionPoints = cert.CRLDistributionPoints
+		info.OCSPServers = cert.OCSPServer
+	}
+	if err := b.putIssuerInfo(ctx, req.Storage, roleName, info); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// refreshExpiringCAChains retrieves CA chains of the roles which ca_chain_ttl has expired or which chain isn't known
+// yet, so the unauthenticated paths can return it
+func (b *backend) refreshExpiringCAChains(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	roles, err := s.List(ctx, "role/")
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, roleName := range roles {
+		role, err := b.getRole(ctx, s, roleName)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		info, err := b.getIssuerInfo(ctx, s, roleName)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if role == nil || b.caChainFailures.get(roleName) != nil {
+			continue
+		}
+		if info != nil && len(info.CAChain) > 0 && time.Since(info.CAChainFetched) < role.caChainTTL() {
+			continue
+		}
+		b.Logger().Debug("Refreshing CA chain", "role", roleName)
+		if _, err := b.refreshCAChain(ctx, &logical.Request{Storage: s}, roleName, info); err != nil {
+			//e.g. nothing was issued in the role zone yet, it is retried after caChainRetryInterval
+			b.Logger().Debug("Failed to retrieve CA chain", "role", roleName, "error", err)
+		}
+	}
+	return result
+}
+
+// invalidateCAChain removes the stored CA chain of the role, so it is retrieved from Venafi on the next read
+func (b *backend) invalidateCAChain(ctx context.Context, s logical.Storage, roleName string) error {
+	b.caChainFailures.purge(roleName)
+	info, err := b.getIssuerInfo(ctx, s, roleName)
+	if err != nil || info == nil {
+		return err
+	}
+	info.CAChain = nil
+	info.CAChainFetched = time.Time{}
+	return b.putIssuerInfo(ctx, s, roleName, info)
+}
+
+func (r *roleEntry) caChainTTL() time.Duration {
+	if r.CAChainTTL == 0 {
+		return defaultCAChainTTL
+	}
+	return r.CAChainTTL
+}
+
+// fetchCAChain retrieves the chain of a certificate of the role zone from Venafi, the certificate is returned too if
+// it can be parsed
+func (b *backend) fetchCAChain(ctx context.Context, req *logical.Request, roleName string) ([]string, *x509.Certificate, error) {
+	//the chains are mostly retrieved by the periodic function, which doesn't make the role recently used
+	cl, timeout, err := b.venafiClient(ctx, req, roleName, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	limit := 1
+	certs, err := cl.ListCertificates(endpoint.Filter{Limit: &limit})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find certificate in the role zone to get the CA chain: %s", err)
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no certificates in the role zone to get the CA chain from")
+	}
+	pcc, err := cl.RetrieveCertificate(&certificate.Request{
+		Thumbprint:  certs[0].Thumbprint,
+		ChainOption: certificate.ChainOptionRootLast,
+		Timeout:     timeout,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pcc.Chain) == 0 {
+		return nil, nil, fmt.Errorf("Venafi didn't return the CA chain")
+	}
+	//the chain is returned even if the endpoints of the certificate can't be read
+	cert, _ := parseCertificatePEM(pcc.Certificate)
+	return pcc.Chain, cert, nil
+}
@@ -0,0 +1,214 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	oidExtensionKeyUsage     = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionExtKeyUsage  = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtKeyUsageAny        = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+	oidExtKeyUsageServerAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	oidExtKeyUsageClientAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
+)
+
+// keyUsageNames are compared in lower case, so both "DigitalSignature" and "digitalSignature" can be used
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"nonrepudiation":    x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = []struct {
+	name  string
+	usage x509.ExtKeyUsage
+	oid   asn1.ObjectIdentifier
+}{
+	{"serverauth", x509.ExtKeyUsageServerAuth, oidExtKeyUsageServerAuth},
+	{"clientauth", x509.ExtKeyUsageClientAuth, oidExtKeyUsageClientAuth},
+	{"codesigning", x509.ExtKeyUsageCodeSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}},
+	{"emailprotection", x509.ExtKeyUsageEmailProtection, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}},
+	{"timestamping", x509.ExtKeyUsageTimeStamping, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}},
+	{"ocspsigning", x509.ExtKeyUsageOCSPSigning, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 9}},
+}
+
+func parseKeyUsage(names []string) (x509.KeyUsage, error) {
+	var result x509.KeyUsage
+	for _, name := range names {
+		usage, ok := keyUsageNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage %s", name)
+		}
+		result |= usage
+	}
+	return result, nil
+}
+
+func parseExtKeyUsage(names []string) ([]x509.ExtKeyUsage, error) {
+	var result []x509.ExtKeyUsage
+	for _, name := range names {
+		found := false
+		for _, eku := range extKeyUsages {
+			if eku.name == strings.ToLower(strings.TrimSpace(name)) {
+				result = append(result, eku.usage)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown extended key usage %s", name)
+		}
+	}
+	return result, nil
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	for _, eku := range extKeyUsages {
+		if eku.usage == usage {
+			return eku.name
+		}
+	}
+	return fmt.Sprintf("%d", usage)
+}
+
+// keyUsageExtensions returns the CSR extensions requesting the key usage and the extended key usage
+func keyUsageExtensions(keyUsage x509.KeyUsage, extKeyUsage []x509.ExtKeyUsage) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+	if keyUsage != 0 {
+		//bit 0 of the key usage is the most significant bit of the first byte, RFC 5280 4.2.1.3
+		var bits [2]byte
+		for i := uint(0); i < 9; i++ {
+			if keyUsage&(1<<i) != 0 {
+				bits[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+		bytes, bitLength := bits[:1], 8
+		if bits[1] != 0 {
+			bytes, bitLength = bits[:2], 16
+		}
+		for bitLength > 0 && bytes[(bitLength-1)/8]&(0x80>>uint((bitLength-1)%8)) == 0 {
+			bitLength--
+		}
+		value, err := asn1.Marshal(asn1.BitString{Bytes: bytes, BitLength: bitLength})
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionKeyUsage, Critical: true, Value: value})
+	}
+	if len(extKeyUsage) > 0 {
+		var oids []asn1.ObjectIdentifier
+		for _, usage := range extKeyUsage {
+			for _, eku := range extKeyUsages {
+				if eku.usage == usage {
+					oids = append(oids, eku.oid)
+				}
+			}
+		}
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionExtKeyUsage, Value: value})
+	}
+	return extensions, nil
+}
+
+// keyUsageWarnings describes the requested key usages which the CA didn't put in the certificate as requested
+func keyUsageWarnings(cert *x509.Certificate, keyUsage x509.KeyUsage, extKeyUsage []x509.ExtKeyUsage) []string {
+	var warnings []string
+	for name, usage := range keyUsageNames {
+		//nonrepudiation is the old name of contentcommitment
+		if name != "nonrepudiation" && keyUsage&usage != 0 && cert.KeyUsage&usage == 0 {
+			warnings = append(warnings, fmt.Sprintf("Key usage %s requested in key_usage was not included in the certificate by the CA", name))
+		}
+	}
+	sort.Strings(warnings)
+	for _, usage := range extKeyUsage {
+		found := false
+		for _, certUsage := range cert.ExtKeyUsage {
+			found = found || certUsage == usage
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("Extended key usage %s requested in ext_key_usage was not included in the certificate by the CA", extKeyUsageName(usage)))
+		}
+	}
+	return warnings
+}
+
+// requestKeyUsage returns the key usage requested by names, or the role key usage if names is nil. The requested
+// key usage must be allowed by the role if the role sets it.
+func requestKeyUsage(roleNames []string, names []string) (x509.KeyUsage, error) {
+	roleUsage, err := parseKeyUsage(roleNames)
+	if err != nil || names == nil {
+		return roleUsage, err
+	}
+	usage, err := parseKeyUsage(names)
+	if err != nil {
+		return 0, err
+	}
+	if len(roleNames) > 0 && usage&^roleUsage != 0 {
+		return 0, fmt.Errorf("key_usage %s is not allowed by key_usage of the role", strings.Join(names, ","))
+	}
+	return usage, nil
+}
+
+// requestExtKeyUsage returns the extended key usage requested by names, or the role extended key usage if names is nil.
+// The requested extended key usage must be allowed by the role if the role sets it.
+func requestExtKeyUsage(roleNames []string, names []string) ([]x509.ExtKeyUsage, error) {
+	roleUsage, err := parseExtKeyUsage(roleNames)
+	if err != nil || names == nil {
+		return roleUsage, err
+	}
+	usage, err := parseExtKeyUsage(names)
+	if err != nil {
+		return nil, err
+	}
+	for i, u := range usage {
+		allowed := len(roleNames) == 0
+		for _, r := range roleUsage {
+			allowed = allowed || r == u
+		}
+		if !allowed {
+			return nil, fmt.Errorf("ext_key_usage %s is not allowed by ext_key_usage of the role", names[i])
+		}
+	}
+	return usage, nil
+}
+
+// clientAuthExtKeyUsage returns the extended key usage of a certificate issued by a client_auth_only role, which
+// is client authentication unless other usages without server authentication are requested
+func clientAuthExtKeyUsage(extKeyUsage []x509.ExtKeyUsage) ([]x509.ExtKeyUsage, error) {
+	if len(extKeyUsage) == 0 {
+		return []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, nil
+	}
+	for _, usage := range extKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return nil, fmt.Errorf(errorTextServerAuthWithClientAuthOnly)
+		}
+	}
+	return extKeyUsage, nil
+}
+
+// isServerAuthCertificate returns true if the certificate can be used for server authentication, which is also the
+// case if it has no extended key usage
+func isServerAuthCertificate(cert *x509.Certificate) bool {
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return true
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
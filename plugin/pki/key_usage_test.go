@@ -0,0 +1,106 @@
+package pki
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRequestExtKeyUsage(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/ext-key-usage",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "ext_key_usage": "ServerAuth,ClientAuth"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	for _, c := range []struct {
+		extKeyUsage string
+		valid       bool
+		warning     bool
+	}{
+		//the fake CA issues certificates only for server authentication
+		{"ServerAuth", true, false},
+		{"ClientAuth", true, true},
+		{"EmailProtection", false, false},
+		{"Unknown", false, false},
+	} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/ext-key-usage",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": "eku.venafi.example.com", "ext_key_usage": c.extKeyUsage},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.valid == (resp == nil || resp.IsError()) {
+			t.Fatalf("%s: expecting valid %v but got %#v", c.extKeyUsage, c.valid, resp)
+		}
+		if c.valid && c.warning != strings.Contains(strings.Join(resp.Warnings, " "), "ext_key_usage") {
+			t.Fatalf("%s: expecting warning %v, got %v", c.extKeyUsage, c.warning, resp.Warnings)
+		}
+	}
+}
+
+func TestClientAuthOnly(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/client-auth",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "client_auth_only": true, "ext_key_usage": "ServerAuth"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextServerAuthWithClientAuthOnly {
+		t.Fatalf("Expecting error %s, got %#v", errorTextServerAuthWithClientAuthOnly, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/client-auth",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "client_auth_only": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/client-auth",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "client.venafi.example.com", "ext_key_usage": "ServerAuth"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextServerAuthWithClientAuthOnly {
+		t.Fatalf("Expecting error %s, got %#v", errorTextServerAuthWithClientAuthOnly, resp)
+	}
+
+	//the fake CA issues certificates only for server authentication, which must be reported
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/client-auth",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "client.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	warnings := strings.Join(resp.Warnings, " ")
+	if !strings.Contains(warnings, "clientauth") || !strings.Contains(warnings, "client_auth_only") {
+		t.Fatalf("Expecting warnings about client authentication, got %v", resp.Warnings)
+	}
+}
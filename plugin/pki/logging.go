@@ -0,0 +1,95 @@
+package pki
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	//names of the log fields which values are never logged
+	sensitiveFieldRegex = regexp.MustCompile(`(?i)(password|passwd|secret|api_?key|token|private_?key|authorization|auth_header|credential)`)
+	privateKeyPEMRegex  = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?(-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`)
+	//secrets embedded in the messages and errors, e.g. in the JSON bodies or headers of the Venafi API requests
+	sensitiveValueRegex = regexp.MustCompile(
+		`(?i)("?(password|passwd|client_secret|api_?key|access_token|refresh_token|private_?key|tppl-api-key|x-venafi-api-key)"?\s*[:=]\s*)("[^"]*"|\[[^\]]*\]|[^\s,;&}]+)`)
+	bearerRegex = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[a-z0-9._~+/=-]+`)
+)
+
+// scrub removes the private keys, passwords, API keys and tokens from the text
+func scrub(s string) string {
+	s = privateKeyPEMRegex.ReplaceAllString(s, redacted)
+	s = sensitiveValueRegex.ReplaceAllString(s, "${1}"+redacted)
+	return bearerRegex.ReplaceAllString(s, "${1} "+redacted)
+}
+
+// scrubArgs scrubs the values of the key/value pairs, the values of the sensitive keys are replaced entirely
+func scrubArgs(args []interface{}) []interface{} {
+	scrubbed := make([]interface{}, len(args))
+	for i, arg := range args {
+		if i%2 == 1 {
+			if key, ok := args[i-1].(string); ok && sensitiveFieldRegex.MatchString(key) {
+				scrubbed[i] = redacted
+				continue
+			}
+		}
+		switch v := arg.(type) {
+		case string:
+			scrubbed[i] = scrub(v)
+		case error:
+			scrubbed[i] = scrub(v.Error())
+		case fmt.Stringer:
+			scrubbed[i] = scrub(v.String())
+		case []byte:
+			scrubbed[i] = scrub(string(v))
+		default:
+			scrubbed[i] = arg
+		}
+	}
+	return scrubbed
+}
+
+// scrubbingLogger guarantees the secrets never reach the plugin logs even at the trace level
+type scrubbingLogger struct {
+	hclog.Logger
+}
+
+func (l scrubbingLogger) Trace(msg string, args ...interface{}) {
+	l.Logger.Trace(scrub(msg), scrubArgs(args)...)
+}
+
+func (l scrubbingLogger) Debug(msg string, args ...interface{}) {
+	l.Logger.Debug(scrub(msg), scrubArgs(args)...)
+}
+
+func (l scrubbingLogger) Info(msg string, args ...interface{}) {
+	l.Logger.Info(scrub(msg), scrubArgs(args)...)
+}
+
+func (l scrubbingLogger) Warn(msg string, args ...interface{}) {
+	l.Logger.Warn(scrub(msg), scrubArgs(args)...)
+}
+
+func (l scrubbingLogger) Error(msg string, args ...interface{}) {
+	l.Logger.Error(scrub(msg), scrubArgs(args)...)
+}
+
+func (l scrubbingLogger) With(args ...interface{}) hclog.Logger {
+	return scrubbingLogger{l.Logger.With(scrubArgs(args)...)}
+}
+
+func (l scrubbingLogger) Named(name string) hclog.Logger {
+	return scrubbingLogger{l.Logger.Named(name)}
+}
+
+func (l scrubbingLogger) ResetNamed(name string) hclog.Logger {
+	return scrubbingLogger{l.Logger.ResetNamed(name)}
+}
+
+// Logger returns the backend logger wrapped by the scrubber
+func (b *backend) Logger() hclog.Logger {
+	return scrubbingLogger{b.Backend.Logger()}
+}
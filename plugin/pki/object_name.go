@@ -0,0 +1,45 @@
+package pki
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// objectNameData is available in object_name_template of the role
+type objectNameData struct {
+	CommonName string
+	RoleName   string
+	Zone       string
+	//UTC time of the request in 20060102150405 format
+	Timestamp string
+	//Unix time of the request
+	Unix int64
+}
+
+func parseObjectNameTemplate(text string) (*template.Template, error) {
+	return template.New("object_name").Option("missingkey=error").Parse(text)
+}
+
+// renderObjectName returns the certificate object name from object_name_template of the role
+func (r *roleEntry) renderObjectName(roleName string, commonName string, zone string, now time.Time) (string, error) {
+	tmpl, err := parseObjectNameTemplate(r.ObjectNameTemplate)
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		zone = r.Zone
+	}
+	var name strings.Builder
+	err = tmpl.Execute(&name, objectNameData{
+		CommonName: commonName,
+		RoleName:   roleName,
+		Zone:       zone,
+		Timestamp:  now.UTC().Format("20060102150405"),
+		Unix:       now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(name.String()), nil
+}
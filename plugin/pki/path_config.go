@@ -0,0 +1,163 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	roleReadRedactionMask = "mask"
+	roleReadRedactionOmit = "omit"
+	redactedValue         = "<redacted>"
+)
+
+// redactedRoleFields identify the Venafi connection of the role and are hidden from roles/<name> reads if configured
+var redactedRoleFields = []string{"tpp_url", "tpp_failover_urls", "tpp_user", "trust_bundle_file", "trust_bundle_pem",
+	"http_proxy", "socks5_proxy"}
+
+// backendConfig contains the settings of the mount, which are not specific to a role
+type backendConfig struct {
+	RoleReadRedaction    string `json:"role_read_redaction"`
+	FIPSMode             bool   `json:"fips_mode"`
+	CompressCertificates bool   `json:"compress_certificates"`
+	LogVenafiRequests    bool   `json:"log_venafi_requests"`
+}
+
+func pathConfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config",
+		Fields: map[string]*framework.FieldSchema{
+			"role_read_redaction": {
+				Type: framework.TypeString,
+				Description: `Redaction of tpp_url, tpp_failover_urls, tpp_user, trust_bundle_file, trust_bundle_pem, http_proxy and socks5_proxy
+in roles/<name> reads:
+"mask" replaces the values, "omit" removes the fields. Full role settings are read from roles/<name>/full.
+If empty, all fields are returned`,
+			},
+			"fips_mode": {
+				Type: framework.TypeBool,
+				Description: `Reject key settings which are not FIPS approved, e.g. RSA keys smaller than 2048 bits or curves other than
+P256, P384 and P521, at role write and issuance time. Roles written before it was enabled are checked at issuance`,
+			},
+			"compress_certificates": {
+				Type: framework.TypeBool,
+				Description: `Store the certificates and their chains compressed with gzip to reduce the storage size.
+The entries written before it was changed are read as before`,
+			},
+			"log_venafi_requests": {
+				Type: framework.TypeBool,
+				Description: `Log the method, endpoint, status and duration of every Platform and Cloud API call and the error
+response body at the debug level. The credentials are never logged`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigRead,
+			logical.UpdateOperation: b.pathConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigHelpSyn,
+		HelpDescription: pathConfigHelpDesc,
+	}
+}
+
+func (b *backend) getConfig(ctx context.Context, s logical.Storage) (*backendConfig, error) {
+	entry, err := s.Get(ctx, "config")
+	if err != nil {
+		return nil, err
+	}
+	var config backendConfig
+	if entry == nil {
+		return &config, nil
+	}
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"role_read_redaction":   config.RoleReadRedaction,
+			"fips_mode":             config.FIPSMode,
+			"compress_certificates": config.CompressCertificates,
+			"log_venafi_requests":   config.LogVenafiRequests,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if redaction, ok := data.GetOk("role_read_redaction"); ok {
+		config.RoleReadRedaction = redaction.(string)
+	}
+	if fipsMode, ok := data.GetOk("fips_mode"); ok {
+		config.FIPSMode = fipsMode.(bool)
+	}
+	if compress, ok := data.GetOk("compress_certificates"); ok {
+		config.CompressCertificates = compress.(bool)
+	}
+	if logRequests, ok := data.GetOk("log_venafi_requests"); ok {
+		config.LogVenafiRequests = logRequests.(bool)
+	}
+	switch config.RoleReadRedaction {
+	case "", roleReadRedactionMask, roleReadRedactionOmit:
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid role_read_redaction %s, it must be mask or omit", config.RoleReadRedaction)), nil
+	}
+
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	b.requestLogging.set(config.LogVenafiRequests)
+	return nil, nil
+}
+
+// redactRoleData hides the connection identifying fields of the role response data
+func redactRoleData(data map[string]interface{}, redaction string) {
+	for _, field := range redactedRoleFields {
+		value, ok := data[field]
+		if !ok {
+			continue
+		}
+		switch redaction {
+		case roleReadRedactionOmit:
+			delete(data, field)
+		case roleReadRedactionMask:
+			switch v := value.(type) {
+			case string:
+				if v != "" {
+					data[field] = redactedValue
+				}
+			case []string:
+				if len(v) != 0 {
+					data[field] = []string{redactedValue}
+				}
+			}
+		}
+	}
+}
+
+const (
+	pathConfigHelpSyn  = `Configure the settings of the mount.`
+	pathConfigHelpDesc = `
+This path configures the settings which apply to all roles of the mount,
+e.g. redaction of the connection identifying fields in role reads,
+the FIPS approved key settings mode, the compression of the stored certificates
+and the debug logging of the Venafi API calls.
+`
+)
@@ -0,0 +1,149 @@
+package pki
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	autoTidyConfigKey       = "config/auto-tidy"
+	defaultAutoTidyInterval = 12 * time.Hour
+)
+
+// autoTidyConfig configures the tidy of the stored certificates run by the periodic function
+type autoTidyConfig struct {
+	Enabled          bool          `json:"enabled"`
+	Interval         time.Duration `json:"interval"`
+	SafetyBuffer     time.Duration `json:"safety_buffer"`
+	TidyRevokedCerts bool          `json:"tidy_revoked_certs"`
+}
+
+func pathConfigAutoTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/auto-tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"enabled": {
+				Type:        framework.TypeBool,
+				Description: "Tidy the expired stored certificates periodically",
+			},
+			"interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Time between the starts of the automatic tidy runs",
+				Default:     int(defaultAutoTidyInterval / time.Second),
+			},
+			"safety_buffer": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Time after the expiration or revocation of the certificate before its entry is deleted",
+				Default:     int(defaultTidySafetyBuffer / time.Second),
+			},
+			"tidy_revoked_certs": {
+				Type:        framework.TypeBool,
+				Description: "Delete the revoked certificates too",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigAutoTidyRead,
+			logical.UpdateOperation: b.pathConfigAutoTidyWrite,
+		},
+
+		HelpSynopsis:    pathConfigAutoTidyHelpSyn,
+		HelpDescription: pathConfigAutoTidyHelpDesc,
+	}
+}
+
+func (b *backend) getAutoTidyConfig(ctx context.Context, s logical.Storage) (*autoTidyConfig, error) {
+	config := autoTidyConfig{Interval: defaultAutoTidyInterval, SafetyBuffer: defaultTidySafetyBuffer}
+	entry, err := s.Get(ctx, autoTidyConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return &config, nil
+	}
+	if err := entry.DecodeJSON(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (b *backend) pathConfigAutoTidyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getAutoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"enabled":            config.Enabled,
+			"interval":           int64(config.Interval.Seconds()),
+			"safety_buffer":      int64(config.SafetyBuffer.Seconds()),
+			"tidy_revoked_certs": config.TidyRevokedCerts,
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigAutoTidyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.getAutoTidyConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if enabled, ok := data.GetOk("enabled"); ok {
+		config.Enabled = enabled.(bool)
+	}
+	if interval, ok := data.GetOk("interval"); ok {
+		config.Interval = time.Duration(interval.(int)) * time.Second
+	}
+	if safetyBuffer, ok := data.GetOk("safety_buffer"); ok {
+		config.SafetyBuffer = time.Duration(safetyBuffer.(int)) * time.Second
+	}
+	if tidyRevokedCerts, ok := data.GetOk("tidy_revoked_certs"); ok {
+		config.TidyRevokedCerts = tidyRevokedCerts.(bool)
+	}
+	if config.Interval <= 0 {
+		return logical.ErrorResponse("interval must be positive"), nil
+	}
+	if config.SafetyBuffer <= 0 {
+		return logical.ErrorResponse("safety_buffer must be positive"), nil
+	}
+
+	entry, err := logical.StorageEntryJSON(autoTidyConfigKey, config)
+	if err != nil {
+		return nil, err
+	}
+	return nil, req.Storage.Put(ctx, entry)
+}
+
+// autoTidy starts the tidy of the stored certificates if the interval passed since the last automatic run
+func (b *backend) autoTidy(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	config, err := b.getAutoTidyConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	if !config.Enabled {
+		return nil
+	}
+	b.autoTidyLock.Lock()
+	defer b.autoTidyLock.Unlock()
+	if time.Since(b.lastAutoTidy) < config.Interval {
+		return nil
+	}
+	if b.startTidy(s, true, config.TidyRevokedCerts, config.SafetyBuffer, "") {
+		b.lastAutoTidy = time.Now()
+	}
+	return nil
+}
+
+const (
+	pathConfigAutoTidyHelpSyn  = `Configure the automatic tidy of the stored certificates.`
+	pathConfigAutoTidyHelpDesc = `
+If enabled, the expired stored certificates, and the revoked ones with tidy_revoked_certs,
+are deleted every interval by the periodic function of the backend. The progress of the
+runs is returned by the tidy-status path.
+`
+)
@@ -0,0 +1,88 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestAutoTidy(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "expired.venafi.example.com"},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     time.Now().Add(-24 * time.Hour),
+	}, &x509.Certificate{}, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := logical.StorageEntryJSON("certs/expired", VenafiCert{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	autoTidy := func() {
+		if err := b.autoTidy(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; atomic.LoadInt32(&b.tidyRunning) != 0; i++ {
+			if i > 100 {
+				t.Fatal("Tidy is not finished")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	autoTidy()
+	if b.tidyStatus.state != "" {
+		t.Fatal("Tidy must not run before auto-tidy is enabled")
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/auto-tidy",
+		Storage:   storage,
+		Data:      map[string]interface{}{"enabled": true, "interval": "1h", "safety_buffer": "1h"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/auto-tidy",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.Data["interval"] != int64(3600) || resp.Data["tidy_revoked_certs"] != false {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	autoTidy()
+	if entry, err := storage.Get(ctx, "certs/expired"); err != nil || entry != nil {
+		t.Fatalf("Expecting expired certificate to be deleted, err: %v", err)
+	}
+	started := b.tidyStatus.started
+	autoTidy()
+	if b.tidyStatus.started != started {
+		t.Fatal("Tidy must not run again within the interval")
+	}
+}
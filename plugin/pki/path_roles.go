@@ -2,7 +2,12 @@ package pki
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/logical"
@@ -22,6 +27,24 @@ func pathListRoles(b *backend) *framework.Path {
 	}
 }
 
+func pathRoleFull(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/full",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleReadFull,
+		},
+
+		HelpSynopsis:    pathRoleFullHelpSyn,
+		HelpDescription: pathRoleFullHelpDesc,
+	}
+}
+
 func pathRoles(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/" + framework.GenericNameRegex("name"),
@@ -48,6 +71,17 @@ Example for Venafi Cloud: e33f3e40-4e7e-11ea-8da3-b3c196ebeb0b`,
 				Required: true,
 			},
 
+			"tpp_failover_urls": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Additional URLs of the Venafi Platform tried in order when tpp_url is unreachable or returns a server error.
+Example: tpp_failover_urls=https://tpp2.venafi.example:443/vedsdk,https://tpp3.venafi.example:443/vedsdk`,
+			},
+			"tpp_load_balancing": {
+				Type: framework.TypeString,
+				Description: `How requests are distributed between tpp_url and tpp_failover_urls: "failover" (default) always starts with tpp_url,
+"round_robin" rotates the nodes, "least_latency" prefers the fastest node. Nodes which failed recently are tried last.`,
+				Default: tppLoadBalancingFailover,
+			},
 			"tpp_user": {
 				Type:        framework.TypeString,
 				Description: `web API user for Venafi Platfrom Example: admin`,
@@ -56,11 +90,41 @@ Example for Venafi Cloud: e33f3e40-4e7e-11ea-8da3-b3c196ebeb0b`,
 				Type:        framework.TypeString,
 				Description: `Password for web API user Example: password`,
 			},
+			"access_token": {
+				Type:        framework.TypeString,
+				Description: `Access token for Venafi Platform 19.2 and higher. Used instead of tpp_user and tpp_password when set.`,
+			},
+			"refresh_token": {
+				Type:        framework.TypeString,
+				Description: `Refresh token for Venafi Platform 19.2 and higher. Used to obtain a new access token when the current one expires.`,
+			},
 			"trust_bundle_file": {
 				Type: framework.TypeString,
 				Description: `Use to specify a PEM formatted file with certificates to be used as trust anchors when communicating with the remote server.
 Example:
   trust_bundle_file = "/full/path/to/bundle.pem""`,
+			},
+			"trust_bundle_pem": {
+				Type: framework.TypeString,
+				Description: `PEM formatted certificates to be used as trust anchors when communicating with the remote server.
+Stored in Vault, so unlike trust_bundle_file it doesn't require a file on every Vault node. Can't be used together with trust_bundle_file.`,
+			},
+			"server_cert_fingerprints": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `SHA-256 fingerprints of the Venafi server certificate. If set, connections are rejected unless the server certificate matches one of them.
+If trust bundle is not specified the fingerprints are used instead of the certificate chain validation.
+Example: server_cert_fingerprints="0d8ef2d4e1...,5c3a1d..."`,
+			},
+			"http_proxy": {
+				Type: framework.TypeString,
+				Description: `HTTP(S) proxy used for connections to the Venafi Platform or Cloud.
+If not set HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+Example: http_proxy=http://proxy.example.com:3128`,
+			},
+			"socks5_proxy": {
+				Type: framework.TypeString,
+				Description: `SOCKS5 proxy used for connections to the Venafi Platform or Cloud. Can't be used together with http_proxy.
+Example: socks5_proxy=socks5://proxy.example.com:1080`,
 			},
 			"apikey": {
 				Type:        framework.TypeString,
@@ -85,8 +149,8 @@ Example:
 			},
 
 			"store_by": {
-				Type: framework.TypeString,
-				Description: `The attribute by which certificates are stored in the backend.  "serial" (default) and "cn" are the only valid values.`,
+				Type:        framework.TypeString,
+				Description: `The attribute by which certificates are stored in the backend.  "serial" (default), "cn" and "hash", the SHA-256 fingerprint of the certificate, are the only valid values.`,
 			},
 
 			"no_store": {
@@ -95,19 +159,92 @@ Example:
 			},
 
 			"service_generated_cert": {
-				Type:        framework.TypeBool,
-				Description: `Use service generated CSR for Venafi Platfrom (ignored if Saas endpoint used)`,
-				Default:     false,
+				Type: framework.TypeBool,
+				Description: `Let Venafi Platform generate the private key and the CSR from the common name and SANs. The key is
+retrieved with key_password or a random password and returned like the generated one. other_sans and key usages
+can't be requested. It can't be used with Venafi Cloud, which doesn't support central key generation yet`,
+				Default: false,
 			},
 			"store_pkey": {
 				Type:        framework.TypeBool,
 				Description: `Set it to true to store certificates privates key in certificate fields`,
 			},
+			"purge_pkey_on_read": {
+				Type: framework.TypeBool,
+				Description: `If set with store_pkey, the stored private key is deleted after the first read of the certificate
+from cert/, so the key is kept in Vault only until it is retrieved. Defaults to "false".`,
+			},
+			"separate_private_key": {
+				Type: framework.TypeBool,
+				Description: `If set, issue returns only the certificate and the private key can be read once from key/<serial>,
+so access to the keys can be granted by a different policy. It can't be used with store_pkey. Defaults to "false".`,
+			},
+			"prevent_reissue": {
+				Type: framework.TypeBool,
+				Description: `If set, a stored unexpired and unrevoked certificate issued by the role for the same common name and SANs
+is returned instead of requesting a new one. Issue requests reuse only certificates with a private key stored by
+store_pkey without key_password, sign requests only certificates with the public key of the CSR. If no certificate
+is stored, e.g. with no_store, sign requests search the valid certificates of the zone at Venafi, which can be slow
+for zones with many certificates. Defaults to "false".`,
+			},
+			"min_cert_time_left": {
+				Type: framework.TypeDurationSecond,
+				Description: `Minimum remaining validity of the certificate reused by prevent_reissue. If the matching certificate
+expires earlier, a new one is issued. If not set, certificates are reused until they expire`,
+			},
+			"auto_renew": {
+				Type: framework.TypeBool,
+				Description: `If set, the stored certificates issued by the role are renewed at Venafi with a new key when they
+expire within auto_renew_window. The results are read from auto-renew/status. Defaults to "false".`,
+			},
+			"auto_renew_window": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long before the expiration the certificates of the auto_renew role are renewed",
+				Default:     int(defaultAutoRenewWindow / time.Second),
+			},
+			"renew_before": {
+				Type: framework.TypeDurationSecond,
+				Description: `How long before the certificate expiration its lease expires, so the consumers of the leases
+rotate the certificates before they expire. It is ignored for certificates valid for less than renew_before`,
+			},
+			"renew_on_lease_renew": {
+				Type: framework.TypeBool,
+				Description: `If set, renewing the lease of a stored certificate renews the certificate at Venafi with a new key
+and the lease returns the renewed certificate. It requires generate_lease. Otherwise the leases can't be renewed.
+Defaults to "false".`,
+			},
+			"storage_prefix": {
+				Type: framework.TypeString,
+				Description: `Path under certs/ where the certificates of the role are stored, e.g. "prod-web" stores them in
+certs/prod-web/, so certificates of different teams are kept apart. The stored certificates are read by serial number
+or fingerprint`,
+			},
+			"crl_url": {
+				Type: framework.TypeString,
+				Description: `URL of the issuing CA CRL served at crl/<role>. If not set, the CRL distribution point
+of the last certificate issued for the role is used`,
+			},
+			"crl_cache_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long the CRL is cached before it is downloaded again. It is never cached after its next update time",
+				Default:     int(defaultCRLCacheTTL / time.Second),
+			},
+			"ca_chain_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long the CA chain of the role is used before it is retrieved from Venafi again",
+				Default:     int(defaultCAChainTTL / time.Second),
+			},
 			"chain_option": {
 				Type:        framework.TypeString,
 				Description: `Specify ordering certificates in chain. Root can be "first" or "last"`,
 				Default:     "last",
 			},
+			"preferred_chain": {
+				Type: framework.TypeString,
+				Description: `Common name or SHA-256 fingerprint of the root the chain should end with when the CA is cross-signed.
+The chain returned by Venafi is cut at the matching certificate, it is returned unchanged if there is no match.
+Example: preferred_chain="ISRG Root X1"`,
+			},
 			"key_type": {
 				Type:    framework.TypeString,
 				Default: "rsa",
@@ -117,15 +254,24 @@ Example:
 			"key_bits": {
 				Type:    framework.TypeInt,
 				Default: 2048,
-				Description: `The number of bits to use. You will almost
-certainly want to change this if you adjust
-the key_type. Default: 2048`,
+				Description: `The number of bits of RSA keys, 2048, 3072 or 4096. The zone policy of
+Venafi may allow only some of them. Default: 2048`,
 			},
 			"key_curve": {
 				Type:        framework.TypeString,
 				Default:     "P256",
 				Description: `Key curve for EC key type. Valid values are: "P256","P384","P521"`,
 			},
+			"validate_zone_policy": {
+				Type: framework.TypeBool,
+				Description: `If set, the zone policy is read from Venafi when the role is written and the role is rejected
+if the zone doesn't allow its key_type, key_bits or key_curve. It is not stored in the role. Defaults to "false".`,
+			},
+			"venafi_policy": {
+				Type: framework.TypeString,
+				Description: `Name of the zone policy synced to venafi-policy/<name> which the role is bound to. The CN, SANs,
+subject and key of the requests are checked against it before they are sent to Venafi`,
+			},
 			"ttl": {
 				Type: framework.TypeDurationSecond,
 				Description: `The lease duration if no specific lease duration is
@@ -139,6 +285,144 @@ the value of max_ttl.`,
 				Description: "The maximum allowed lease duration",
 			},
 
+			"allow_zone_override": {
+				Type:        framework.TypeBool,
+				Description: `If set, issue and sign requests can specify "zone" to use a zone other than the role zone. Defaults to "false".`,
+			},
+			"allowed_zones": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Zones which can be requested when allow_zone_override is set. Globs are supported. If empty any zone can be requested.
+Example: allowed_zones="DevOps\\*,Certificates\\Web"`,
+			},
+			"allowed_domains": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Domains which can be requested in common_name and alt_names or in the signed CSR, these are
+checked before the request is sent to Venafi. If empty, any domain allowed by the zone can be requested.
+Example: allowed_domains="example.com,example.org"`,
+			},
+			"allowed_domains_template": {
+				Type: framework.TypeBool,
+				Description: `If set, allowed_domains can contain identity templates rendered for the entity of the request,
+e.g. "{{identity.entity.name}}.example.com" or "{{identity.entity.metadata.team}}.example.com". Defaults to "false".`,
+			},
+			"denied_domains": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Names which can't be requested in common_name, alt_names or in the signed CSR even if allowed_domains
+or the zone allow them. Globs are supported, e.g. denied_domains="*.prod-admin.example.com,prod-admin.example.com"`,
+			},
+			"allow_subdomains": {
+				Type:        framework.TypeBool,
+				Description: `If set, subdomains of allowed_domains can be requested too, including wildcards. Defaults to "false".`,
+			},
+			"allow_glob_domains": {
+				Type: framework.TypeBool,
+				Description: `If set, allowed_domains can contain glob patterns matched against the requested names,
+e.g. "*.eng.example.com" or "web-*.example.com". Defaults to "false".`,
+			},
+			"allow_bare_domains": {
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If set, allowed_domains themselves can be requested, not only their subdomains.
+Defaults to "true".`,
+			},
+			"allow_wildcard_certificates": {
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If set, wildcard names like "*.example.com" can be requested in common_name, alt_names or in
+the signed CSR. Defaults to "true".`,
+			},
+			"enforce_hostnames": {
+				Type: framework.TypeBool,
+				Description: `If set, common_name and the DNS names of alt_names or of the signed CSR must be valid hostnames,
+e.g. without spaces or empty labels. Defaults to "false".`,
+			},
+			"allow_underscores": {
+				Type:        framework.TypeBool,
+				Description: `If set, hostnames can contain underscores when enforce_hostnames is set. Defaults to "false".`,
+			},
+			"allow_ip_sans": {
+				Type:    framework.TypeBool,
+				Default: true,
+				Description: `If set, IP SANs can be requested in ip_sans, alt_names, as the common_name or in the signed CSR.
+It's checked before the request is sent to Venafi, so IP SANs can be forbidden even if the zone allows them. Defaults to "true".`,
+			},
+			"allow_email_sans": {
+				Type:        framework.TypeBool,
+				Default:     true,
+				Description: `If set, email SANs can be requested in email_sans, alt_names or in the signed CSR. Defaults to "true".`,
+			},
+			"allowed_uri_sans": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `URI SANs which can be requested in uri_sans or in the signed CSR. Globs are supported.
+If empty, URI SANs are not allowed. Example: allowed_uri_sans="spiffe://example.com/*"`,
+			},
+			"allowed_other_sans": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Other SANs which can be requested in other_sans, in oid;UTF8:value format. Globs are supported in value,
+"*" allows any other SAN. If empty, other SANs are not allowed. Example: allowed_other_sans="1.3.6.1.4.1.311.20.2.3;UTF8:*@example.com"`,
+			},
+			"key_usage": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Key usages requested for issued certificates and allowed in key_usage of issue requests,
+e.g. "DigitalSignature,KeyEncipherment". If empty, the CSR has no key usage and any can be requested`,
+			},
+			"ext_key_usage": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Extended key usages requested for issued certificates and allowed in ext_key_usage of issue
+requests: ServerAuth, ClientAuth, CodeSigning, EmailProtection, TimeStamping or OCSPSigning. If empty, the CSR has
+no extended key usage and any can be requested`,
+			},
+			"client_auth_only": {
+				Type: framework.TypeBool,
+				Description: `If set, certificates are issued only for client authentication: ClientAuth extended key usage is
+requested by default, and ServerAuth can't be requested in ext_key_usage or in signed CSRs. Defaults to "false".`,
+			},
+			"organization": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Default organization (O) of the certificate subject. It can be overridden in the issue request",
+			},
+			"organizational_unit": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Default organizational unit (OU) of the certificate subject. It can be overridden in the issue request",
+			},
+			"country": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Default country (C) of the certificate subject. It can be overridden in the issue request",
+			},
+			"locality": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Default locality (L) of the certificate subject. It can be overridden in the issue request",
+			},
+			"province": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Default province (ST) of the certificate subject. It can be overridden in the issue request",
+			},
+			"object_name_template": {
+				Type: framework.TypeString,
+				Description: `Go template of the certificate object name created in the Venafi Platform policy folder, used when
+friendly_name is not requested. Available fields: .CommonName, .RoleName, .Zone, .Timestamp (UTC, 20060102150405) and .Unix.
+Example: object_name_template="{{.CommonName}}-{{.Timestamp}}"`,
+			},
+			"origin": {
+				Type: framework.TypeString,
+				Description: `Value of the Origin custom field of the Venafi requests. Defaults to "HashiCorp Vault <mount> <plugin version>",
+so it can be traced which mount issued the certificate`,
+			},
+			"contacts": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Venafi Platform identities set as contacts of the created certificate objects, so they receive
+expiration notifications. Example: contacts="local:{a1b2c3d4-...}"`,
+			},
+			"cloud_issuing_templates": {
+				Type: framework.TypeKVPairs,
+				Description: `Venafi Cloud issuing templates which can be requested in issuing_template, as a map or a list
+of name=zone pairs where zone is the Cloud zone using the template. Example: cloud_issuing_templates="Internal=Internal Zone"`,
+			},
+			"preserve_csr_extensions": {
+				Type: framework.TypeBool,
+				Description: `If set, sign requests check that the extensions requested in the CSR, e.g. key usage or custom OIDs,
+are present in the issued certificate and return a warning for each extension the CA didn't include. Defaults to "false".`,
+			},
 			"generate_lease": {
 				Type: framework.TypeBool,
 				Description: `
@@ -150,6 +434,50 @@ attached to them. Defaults to "false".`,
 				Description: "Timeout of waiting certificate",
 				Default:     180,
 			},
+			"retry_max_attempts": {
+				Type:        framework.TypeInt,
+				Description: "Number of attempts of a certificate request when the Venafi endpoint returns a transient error (429, 5xx or timeout). Set to 1 to disable retries",
+				Default:     defaultRetryMaxAttempts,
+			},
+			"retry_base_delay": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Delay before the first retry, doubled on every following retry",
+				Default:     int(defaultRetryBaseDelay / time.Second),
+			},
+			"retry_max_delay": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum delay between retries",
+				Default:     int(defaultRetryMaxDelay / time.Second),
+			},
+			"retry_disable_jitter": {
+				Type:        framework.TypeBool,
+				Description: `If set, retries wait exactly the backoff delay instead of a random part of it. Defaults to "false".`,
+			},
+			"issuance_rate_limit": {
+				Type: framework.TypeInt,
+				Description: `Maximum number of issue and sign requests of the role sent to Venafi per
+issuance_rate_limit_period, the requests exceeding it are rejected with status 429. Set to 0 to disable the limit`,
+			},
+			"issuance_rate_limit_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Period of issuance_rate_limit, e.g. 1s for requests per second or 1m for requests per minute",
+				Default:     int(defaultIssuanceLimitPeriod / time.Second),
+			},
+			"max_idle_conns": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of idle keep-alive connections to the Venafi endpoint",
+				Default:     defaultMaxIdleConns,
+			},
+			"idle_conn_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long an idle keep-alive connection to the Venafi endpoint is kept open",
+				Default:     int(defaultIdleConnTimeout / time.Second),
+			},
+			"tcp_keepalive": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Interval of TCP keep-alive probes on connections to the Venafi endpoint",
+				Default:     int(defaultTCPKeepAlive / time.Second),
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -166,13 +494,44 @@ attached to them. Defaults to "false".`,
 const (
 	storeByCNString                              = "cn"
 	storeBySerialString                          = "serial"
+	storeByHashString                            = "hash"
 	errorTextInvalidMode                         = "Invalid mode. fakemode or apikey or tpp credentials required"
+	errorTextRefreshTokenWithoutAccessToken      = `refresh_token requires access_token to be set`
+	errorTextTrustBundleFileAndPEMConflict       = `Can't specify both trust_bundle_file and trust_bundle_pem options`
+	errorTextInvalidTrustBundlePEM               = `trust_bundle_pem doesn't contain any PEM formatted certificates`
+	errorTextInvalidFingerprint                  = `Invalid SHA-256 fingerprint in server_cert_fingerprints: %s`
+	errorTextHTTPAndSOCKS5ProxyConflict          = `Can't specify both http_proxy and socks5_proxy options`
+	errorTextFailoverURLsWithoutTPPURL           = `tpp_failover_urls requires tpp_url to be set`
+	errorTextInvalidLoadBalancing                = `Invalid tpp_load_balancing option %s, allowed options are failover, round_robin and least_latency`
+	errorTextZoneOverrideNotAllowed              = `zone override is not allowed by the role, set allow_zone_override to enable it`
+	errorTextZoneNotAllowed                      = `zone %s is not in allowed_zones of the role`
+	errorTextNegativeConnectionSetting           = `max_idle_conns, idle_conn_timeout and tcp_keepalive can't be negative`
+	errorTextNegativeRetrySetting                = `retry_max_attempts, retry_base_delay and retry_max_delay can't be negative`
+	errorTextNegativeRateLimit                   = `issuance_rate_limit and issuance_rate_limit_period can't be negative`
+	errorTextIPSANsNotAllowed                    = `IP SANs are not allowed by the role, set allow_ip_sans to enable them`
+	errorTextURISANNotAllowed                    = `URI SAN %s is not in allowed_uri_sans of the role`
+	errorTextEmailSANsNotAllowed                 = `email SANs are not allowed by the role, set allow_email_sans to enable them`
+	errorTextOtherSANNotAllowed                  = `other SAN %s is not in allowed_other_sans of the role`
+	errorTextInvalidObjectNameTemplate           = `Invalid object_name_template: %s`
+	errorTextContactsWithoutTPPURL               = `contacts require tpp_url to be set`
+	errorTextIssuingTemplatesWithTPP             = `cloud_issuing_templates can be used only with Venafi Cloud`
+	errorTextIssuingTemplateNotAllowed           = `issuing template %s is not in cloud_issuing_templates of the role`
+	errorTextInvalidKeyBits                      = `key_bits %d is not supported for RSA keys, it must be one of %v`
+	errorTextServiceGeneratedWithCloud           = `service_generated_cert is not supported by Venafi Cloud`
+	errorTextSeparateKeyAndStorePrivateKey       = `separate_private_key can't be used with store_pkey`
+	errorTextPurgeOnReadWithoutStorePrivateKey   = `purge_pkey_on_read requires store_pkey to be set`
+	errorTextServerAuthWithClientAuthOnly        = `ServerAuth extended key usage can't be requested from a client_auth_only role`
+	errorTextMinCertTimeLeftWithoutReissue       = `min_cert_time_left requires prevent_reissue to be set`
+	errorTextRenewOnLeaseRenewWithoutLease       = `renew_on_lease_renew requires generate_lease to be set`
+	errorTextInvalidStoragePrefix                = `Invalid storage_prefix %q, it must be slash separated names of letters, digits, "_" and "-"`
+	errorTextNoStoreAndStoragePrefixConflict     = `Can't specify both no_store and storage_prefix options`
+	errorTextUnknownVenafiPolicy                 = `Unknown venafi_policy %s, it must be synced to venafi-policy/%s first`
 	errorTextValueMustBeLess                     = `"ttl" value must be less than "max_ttl" value`
 	errorTextTPPandCloudMixedCredentials         = `TPP credentials and Cloud API key can't be specified in one role`
 	errorTextStoreByAndStoreByCNOrSerialConflict = `Can't specify both story_by and store_by_cn or store_by_serial options '`
 	errorTextNoStoreAndStoreByCNOrSerialConflict = `Can't specify both no_store and store_by_cn or store_by_serial options '`
 	errorTextNoStoreAndStoreByConflict           = `Can't specify both no_store and store_by options '`
-	errTextStoreByWrongOption                    = "Option store_by can be %s or %s, not %s"
+	errTextStoreByWrongOption                    = "Option store_by can be %s, %s or %s, not %s"
 )
 
 func (b *backend) getRole(ctx context.Context, s logical.Storage, n string) (*roleEntry, error) {
@@ -184,11 +543,25 @@ func (b *backend) getRole(ctx context.Context, s logical.Storage, n string) (*ro
 		return nil, nil
 	}
 
-	var result roleEntry
+	//fields missing in roles created by the older versions keep these defaults
+	result := roleEntry{
+		AllowIPSANs:      true,
+		AllowEmailSANs:   true,
+		AllowBareDomains: true,
+		AllowWildcards:   true,
+	}
 	if err := entry.DecodeJSON(&result); err != nil {
 		return nil, err
 	}
 
+	credentials, err := getRoleCredentials(ctx, s, n)
+	if err != nil {
+		return nil, err
+	}
+	if credentials != nil {
+		result.setCredentials(*credentials)
+	}
+
 	return &result, nil
 }
 
@@ -197,6 +570,22 @@ func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data
 	if err != nil {
 		return nil, err
 	}
+	err = req.Storage.Delete(ctx, credentialsPrefix+data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	//the role created again with the same name can use another CA
+	err = req.Storage.Delete(ctx, "issuer/"+data.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	b.clientCache.purge(data.Get("name").(string))
+	b.crls.purge(data.Get("name").(string))
+	b.crlFailures.purge(data.Get("name").(string))
+	b.ocspFailures.purge(data.Get("name").(string))
+	b.caChainFailures.purge(data.Get("name").(string))
+	b.credentialChecks.purge(data.Get("name").(string))
+	b.issuanceLimits.purge(data.Get("name").(string))
 
 	return nil, nil
 }
@@ -215,9 +604,40 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 		return nil, nil
 	}
 
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	respData := role.ToResponseData()
+	redactRoleData(respData, config.RoleReadRedaction)
+
+	resp := &logical.Response{
+		Data: respData,
+	}
+	b.addCredentialWarnings(resp, roleName, role)
+	if err := b.addPolicyWarnings(ctx, req.Storage, resp, role); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pathRoleReadFull returns the role without redaction, access to it can be granted to the administrators only
+func (b *backend) pathRoleReadFull(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, nil
+	}
 	resp := &logical.Response{
 		Data: role.ToResponseData(),
 	}
+	b.addCredentialWarnings(resp, roleName, role)
+	if err := b.addPolicyWarnings(ctx, req.Storage, resp, role); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
@@ -235,28 +655,87 @@ func (b *backend) pathRoleCreate(ctx context.Context, req *logical.Request, data
 	name := data.Get("name").(string)
 
 	entry := &roleEntry{
-		TPPURL:           data.Get("tpp_url").(string),
-		CloudURL:         data.Get("cloud_url").(string),
-		Zone:             data.Get("zone").(string),
-		TPPPassword:      data.Get("tpp_password").(string),
-		Apikey:           data.Get("apikey").(string),
-		TPPUser:          data.Get("tpp_user").(string),
-		TrustBundleFile:  data.Get("trust_bundle_file").(string),
-		Fakemode:         data.Get("fakemode").(bool),
-		ChainOption:      data.Get("chain_option").(string),
-		StoreByCN:        data.Get("store_by_cn").(bool),
-		StoreBySerial:    data.Get("store_by_serial").(bool),
-		StoreBy:          data.Get("store_by").(string),
-		NoStore:          data.Get("no_store").(bool),
-		ServiceGenerated: data.Get("service_generated_cert").(bool),
-		StorePrivateKey:  data.Get("store_pkey").(bool),
-		KeyType:          data.Get("key_type").(string),
-		KeyBits:          data.Get("key_bits").(int),
-		KeyCurve:         data.Get("key_curve").(string),
-		MaxTTL:           time.Duration(data.Get("max_ttl").(int)) * time.Second,
-		TTL:              time.Duration(data.Get("ttl").(int)) * time.Second,
-		GenerateLease:    data.Get("generate_lease").(bool),
-		ServerTimeout:    time.Duration(data.Get("server_timeout").(int)) * time.Second,
+		TPPURL:                 data.Get("tpp_url").(string),
+		CloudURL:               data.Get("cloud_url").(string),
+		Zone:                   data.Get("zone").(string),
+		TPPPassword:            data.Get("tpp_password").(string),
+		Apikey:                 data.Get("apikey").(string),
+		TPPUser:                data.Get("tpp_user").(string),
+		TPPFailoverURLs:        data.Get("tpp_failover_urls").([]string),
+		TPPLoadBalancing:       data.Get("tpp_load_balancing").(string),
+		AccessToken:            data.Get("access_token").(string),
+		RefreshToken:           data.Get("refresh_token").(string),
+		TrustBundleFile:        data.Get("trust_bundle_file").(string),
+		TrustBundlePEM:         data.Get("trust_bundle_pem").(string),
+		ServerCertFingerprints: data.Get("server_cert_fingerprints").([]string),
+		HTTPProxy:              data.Get("http_proxy").(string),
+		SOCKS5Proxy:            data.Get("socks5_proxy").(string),
+		Fakemode:               data.Get("fakemode").(bool),
+		ChainOption:            data.Get("chain_option").(string),
+		PreferredChain:         strings.TrimSpace(data.Get("preferred_chain").(string)),
+		StoreByCN:              data.Get("store_by_cn").(bool),
+		StoreBySerial:          data.Get("store_by_serial").(bool),
+		StoreBy:                data.Get("store_by").(string),
+		NoStore:                data.Get("no_store").(bool),
+		ServiceGenerated:       data.Get("service_generated_cert").(bool),
+		StorePrivateKey:        data.Get("store_pkey").(bool),
+		PurgePrivateKeyOnRead:  data.Get("purge_pkey_on_read").(bool),
+		SeparatePrivateKey:     data.Get("separate_private_key").(bool),
+		PreventReissue:         data.Get("prevent_reissue").(bool),
+		MinCertTimeLeft:        time.Duration(data.Get("min_cert_time_left").(int)) * time.Second,
+		AutoRenew:              data.Get("auto_renew").(bool),
+		AutoRenewWindow:        time.Duration(data.Get("auto_renew_window").(int)) * time.Second,
+		RenewOnLeaseRenew:      data.Get("renew_on_lease_renew").(bool),
+		RenewBefore:            time.Duration(data.Get("renew_before").(int)) * time.Second,
+		StoragePrefix:          data.Get("storage_prefix").(string),
+		KeyType:                data.Get("key_type").(string),
+		KeyBits:                data.Get("key_bits").(int),
+		KeyCurve:               data.Get("key_curve").(string),
+		MaxTTL:                 time.Duration(data.Get("max_ttl").(int)) * time.Second,
+		TTL:                    time.Duration(data.Get("ttl").(int)) * time.Second,
+		GenerateLease:          data.Get("generate_lease").(bool),
+		PreserveCSRExtensions:  data.Get("preserve_csr_extensions").(bool),
+		AllowedDomains:         data.Get("allowed_domains").([]string),
+		AllowedDomainsTemplate: data.Get("allowed_domains_template").(bool),
+		DeniedDomains:          data.Get("denied_domains").([]string),
+		AllowSubdomains:        data.Get("allow_subdomains").(bool),
+		AllowGlobDomains:       data.Get("allow_glob_domains").(bool),
+		AllowBareDomains:       data.Get("allow_bare_domains").(bool),
+		AllowWildcards:         data.Get("allow_wildcard_certificates").(bool),
+		EnforceHostnames:       data.Get("enforce_hostnames").(bool),
+		AllowUnderscores:       data.Get("allow_underscores").(bool),
+		AllowIPSANs:            data.Get("allow_ip_sans").(bool),
+		AllowedURISANs:         data.Get("allowed_uri_sans").([]string),
+		AllowEmailSANs:         data.Get("allow_email_sans").(bool),
+		AllowedOtherSANs:       data.Get("allowed_other_sans").([]string),
+		KeyUsage:               data.Get("key_usage").([]string),
+		ExtKeyUsage:            data.Get("ext_key_usage").([]string),
+		ClientAuthOnly:         data.Get("client_auth_only").(bool),
+		Organization:           data.Get("organization").([]string),
+		OrganizationalUnit:     data.Get("organizational_unit").([]string),
+		Country:                data.Get("country").([]string),
+		Locality:               data.Get("locality").([]string),
+		Province:               data.Get("province").([]string),
+		ObjectNameTemplate:     data.Get("object_name_template").(string),
+		Origin:                 data.Get("origin").(string),
+		Contacts:               data.Get("contacts").([]string),
+		CloudIssuingTemplates:  templateZones(data.Get("cloud_issuing_templates").(map[string]string)),
+		AllowZoneOverride:      data.Get("allow_zone_override").(bool),
+		AllowedZones:           data.Get("allowed_zones").([]string),
+		VenafiPolicy:           data.Get("venafi_policy").(string),
+		ServerTimeout:          time.Duration(data.Get("server_timeout").(int)) * time.Second,
+		RetryMaxAttempts:       data.Get("retry_max_attempts").(int),
+		RetryBaseDelay:         time.Duration(data.Get("retry_base_delay").(int)) * time.Second,
+		RetryMaxDelay:          time.Duration(data.Get("retry_max_delay").(int)) * time.Second,
+		RetryDisableJitter:     data.Get("retry_disable_jitter").(bool),
+		IssuanceRateLimit:      data.Get("issuance_rate_limit").(int),
+		IssuanceLimitPeriod:    time.Duration(data.Get("issuance_rate_limit_period").(int)) * time.Second,
+		MaxIdleConns:           data.Get("max_idle_conns").(int),
+		CRLURL:                 data.Get("crl_url").(string),
+		CAChainTTL:             time.Duration(data.Get("ca_chain_ttl").(int)) * time.Second,
+		CRLCacheTTL:            time.Duration(data.Get("crl_cache_ttl").(int)) * time.Second,
+		IdleConnTimeout:        time.Duration(data.Get("idle_conn_timeout").(int)) * time.Second,
+		TCPKeepAlive:           time.Duration(data.Get("tcp_keepalive").(int)) * time.Second,
 	}
 
 	err = validateEntry(entry)
@@ -264,34 +743,176 @@ func (b *backend) pathRoleCreate(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
-	// Store it
-	jsonEntry, err := logical.StorageEntryJSON("role/"+name, entry)
+	config, err := b.getConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
-	if err := req.Storage.Put(ctx, jsonEntry); err != nil {
+	if config.FIPSMode {
+		if err := validateFIPSRole(entry); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if entry.VenafiPolicy != "" {
+		policy, err := b.getVenafiPolicy(ctx, req.Storage, entry.VenafiPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			return logical.ErrorResponse(fmt.Sprintf(errorTextUnknownVenafiPolicy, entry.VenafiPolicy, entry.VenafiPolicy)), nil
+		}
+	}
+
+	if data.Get("validate_zone_policy").(bool) {
+		cl, err := b.newVenafiClient(entry, "", entry.Zone)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		zoneConfig, err := cl.ReadZoneConfiguration()
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("failed to read zone policy: %s", err)), nil
+		}
+		if err := validateZoneKeyPolicy(entry, zoneConfig); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	// Store it
+	if err := b.putRole(ctx, req.Storage, name, entry); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
+// putRole stores the role and its credentials separately, the role entry written by the older versions with the
+// credentials is replaced by the one without them
+func (b *backend) putRole(ctx context.Context, s logical.Storage, name string, entry *roleEntry) error {
+	role := *entry
+	role.setCredentials(roleCredentials{})
+	jsonEntry, err := logical.StorageEntryJSON("role/"+name, role)
+	if err != nil {
+		return err
+	}
+	defer b.clientCache.purge(name)
+	defer b.crls.purge(name)
+	defer b.crlFailures.purge(name)
+	defer b.ocspFailures.purge(name)
+	defer b.credentialChecks.purge(name)
+	if err := putRoleCredentials(ctx, s, name, entry.credentials()); err != nil {
+		return err
+	}
+	return s.Put(ctx, jsonEntry)
+}
+
+// allowedRSAKeyBits are the RSA key sizes which can be requested from Venafi with key_bits
+var allowedRSAKeyBits = []int{2048, 3072, 4096}
+
 func validateEntry(entry *roleEntry) (err error) {
-	if !entry.Fakemode && entry.Apikey == "" && (entry.TPPURL == "" || entry.TPPUser == "" || entry.TPPPassword == "") {
+	if !entry.Fakemode && entry.Apikey == "" && (entry.TPPURL == "" || !entry.hasTPPCredentials()) {
 		return fmt.Errorf(errorTextInvalidMode)
 	}
 
+	if entry.RefreshToken != "" && entry.AccessToken == "" {
+		return fmt.Errorf(errorTextRefreshTokenWithoutAccessToken)
+	}
+
+	if entry.TrustBundleFile != "" && entry.TrustBundlePEM != "" {
+		return fmt.Errorf(errorTextTrustBundleFileAndPEMConflict)
+	}
+
+	if entry.TrustBundlePEM != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(entry.TrustBundlePEM)) {
+		return fmt.Errorf(errorTextInvalidTrustBundlePEM)
+	}
+
+	for i, fingerprint := range entry.ServerCertFingerprints {
+		normalized := strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+		if raw, err := hex.DecodeString(normalized); err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf(errorTextInvalidFingerprint, fingerprint)
+		}
+		entry.ServerCertFingerprints[i] = normalized
+	}
+
+	//the template is executed with sample data, so references to unknown fields are found too
+	if _, err := entry.renderObjectName("role", "example.com", "", time.Now()); err != nil {
+		return fmt.Errorf(errorTextInvalidObjectNameTemplate, err)
+	}
+
+	if err := validateDomainTemplates(entry); err != nil {
+		return err
+	}
+
+	if entry.HTTPProxy != "" && entry.SOCKS5Proxy != "" {
+		return fmt.Errorf(errorTextHTTPAndSOCKS5ProxyConflict)
+	}
+
+	if _, err := entry.proxyURL(); err != nil {
+		return err
+	}
+
+	if entry.RetryMaxAttempts < 0 || entry.RetryBaseDelay < 0 || entry.RetryMaxDelay < 0 {
+		return fmt.Errorf(errorTextNegativeRetrySetting)
+	}
+
+	if entry.IssuanceRateLimit < 0 || entry.IssuanceLimitPeriod < 0 {
+		return fmt.Errorf(errorTextNegativeRateLimit)
+	}
+
+	if entry.MaxIdleConns < 0 || entry.IdleConnTimeout < 0 || entry.TCPKeepAlive < 0 {
+		return fmt.Errorf(errorTextNegativeConnectionSetting)
+	}
+
+	if _, err := parseKeyUsage(entry.KeyUsage); err != nil {
+		return err
+	}
+
+	extKeyUsage, err := parseExtKeyUsage(entry.ExtKeyUsage)
+	if err != nil {
+		return err
+	}
+	if entry.ClientAuthOnly {
+		if _, err := clientAuthExtKeyUsage(extKeyUsage); err != nil {
+			return err
+		}
+	}
+
+	if entry.KeyType == "rsa" && !intSliceContains(allowedRSAKeyBits, entry.KeyBits) {
+		return fmt.Errorf(errorTextInvalidKeyBits, entry.KeyBits, allowedRSAKeyBits)
+	}
+
 	if entry.MaxTTL > 0 && entry.TTL > entry.MaxTTL {
 		return fmt.Errorf(
 			errorTextValueMustBeLess,
 		)
 	}
 
+	if len(entry.CloudIssuingTemplates) > 0 && entry.TPPURL != "" {
+		return fmt.Errorf(errorTextIssuingTemplatesWithTPP)
+	}
+
+	if entry.ServiceGenerated && entry.Apikey != "" {
+		return fmt.Errorf(errorTextServiceGeneratedWithCloud)
+	}
+
+	if len(entry.Contacts) > 0 && entry.TPPURL == "" {
+		return fmt.Errorf(errorTextContactsWithoutTPPURL)
+	}
+
+	if len(entry.TPPFailoverURLs) > 0 && entry.TPPURL == "" {
+		return fmt.Errorf(errorTextFailoverURLsWithoutTPPURL)
+	}
+
+	switch entry.TPPLoadBalancing {
+	case "", tppLoadBalancingFailover, tppLoadBalancingRoundRobin, tppLoadBalancingLeastLatency:
+	default:
+		return fmt.Errorf(errorTextInvalidLoadBalancing, entry.TPPLoadBalancing)
+	}
+
 	if entry.TPPURL != "" && entry.Apikey != "" {
 		return fmt.Errorf(errorTextTPPandCloudMixedCredentials)
 	}
 
-	if entry.TPPUser != "" && entry.Apikey != "" {
+	if (entry.TPPUser != "" || entry.AccessToken != "") && entry.Apikey != "" {
 		return fmt.Errorf(errorTextTPPandCloudMixedCredentials)
 	}
 
@@ -299,6 +920,31 @@ func validateEntry(entry *roleEntry) (err error) {
 		return fmt.Errorf(errorTextStoreByAndStoreByCNOrSerialConflict)
 	}
 
+	if entry.PurgePrivateKeyOnRead && !entry.StorePrivateKey {
+		return fmt.Errorf(errorTextPurgeOnReadWithoutStorePrivateKey)
+	}
+
+	if entry.SeparatePrivateKey && entry.StorePrivateKey {
+		return fmt.Errorf(errorTextSeparateKeyAndStorePrivateKey)
+	}
+
+	if entry.MinCertTimeLeft > 0 && !entry.PreventReissue {
+		return fmt.Errorf(errorTextMinCertTimeLeftWithoutReissue)
+	}
+
+	if entry.RenewOnLeaseRenew && !entry.GenerateLease {
+		return fmt.Errorf(errorTextRenewOnLeaseRenewWithoutLease)
+	}
+
+	if entry.StoragePrefix != "" {
+		if entry.NoStore {
+			return fmt.Errorf(errorTextNoStoreAndStoragePrefixConflict)
+		}
+		if !storagePrefixRegex.MatchString(entry.StoragePrefix) {
+			return fmt.Errorf(errorTextInvalidStoragePrefix, entry.StoragePrefix)
+		}
+	}
+
 	if (entry.StoreByCN || entry.StoreBySerial) && entry.NoStore {
 		return fmt.Errorf(errorTextNoStoreAndStoreByCNOrSerialConflict)
 	}
@@ -308,9 +954,9 @@ func validateEntry(entry *roleEntry) (err error) {
 	}
 
 	if entry.StoreBy != "" {
-		if (entry.StoreBy != storeBySerialString) && (entry.StoreBy != storeByCNString) {
+		if (entry.StoreBy != storeBySerialString) && (entry.StoreBy != storeByCNString) && (entry.StoreBy != storeByHashString) {
 			return fmt.Errorf(
-				fmt.Sprintf(errTextStoreByWrongOption, storeBySerialString, storeByCNString, entry.StoreBy),
+				fmt.Sprintf(errTextStoreByWrongOption, storeBySerialString, storeByCNString, storeByHashString, entry.StoreBy),
 			)
 		}
 	}
@@ -327,59 +973,188 @@ func validateEntry(entry *roleEntry) (err error) {
 	return nil
 }
 
+var storagePrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(/[a-zA-Z0-9_-]+)*$`)
+
 type roleEntry struct {
 
 	//Venafi values
-	TPPURL           string        `json:"tpp_url"`
-	CloudURL         string        `json:"cloud_url"`
-	Zone             string        `json:"zone"`
-	TPPPassword      string        `json:"tpp_password"`
-	Apikey           string        `json:"apikey"`
-	TPPUser          string        `json:"tpp_user"`
-	TrustBundleFile  string        `json:"trust_bundle_file"`
-	Fakemode         bool          `json:"fakemode"`
-	ChainOption      string        `json:"chain_option"`
-	StoreByCN        bool          `json:"store_by_cn"`
-	StoreBySerial    bool          `json:"store_by_serial"`
-	StoreBy          string        `json:"store_by"`
-	NoStore          bool          `json:"no_store"`
-	ServiceGenerated bool          `json:"service_generated_cert"`
-	StorePrivateKey  bool          `json:"store_pkey"`
-	KeyType          string        `json:"key_type"`
-	KeyBits          int           `json:"key_bits"`
-	KeyCurve         string        `json:"key_curve"`
-	LeaseMax         string        `json:"lease_max"`
-	Lease            string        `json:"lease"`
-	TTL              time.Duration `json:"ttl_duration"`
-	MaxTTL           time.Duration `json:"max_ttl_duration"`
-	GenerateLease    bool          `json:"generate_lease,omitempty"`
-	DeprecatedMaxTTL string        `json:"max_ttl"`
-	DeprecatedTTL    string        `json:"ttl"`
-	ServerTimeout    time.Duration `json:"server_timeout"`
+	TPPURL                 string        `json:"tpp_url"`
+	TPPFailoverURLs        []string      `json:"tpp_failover_urls"`
+	TPPLoadBalancing       string        `json:"tpp_load_balancing"`
+	CloudURL               string        `json:"cloud_url"`
+	Zone                   string        `json:"zone"`
+	TPPPassword            string        `json:"tpp_password"`
+	Apikey                 string        `json:"apikey"`
+	TPPUser                string        `json:"tpp_user"`
+	AccessToken            string        `json:"access_token"`
+	RefreshToken           string        `json:"refresh_token"`
+	AccessTokenExpiry      time.Time     `json:"access_token_expiry"`
+	TrustBundleFile        string        `json:"trust_bundle_file"`
+	ServerCertFingerprints []string      `json:"server_cert_fingerprints"`
+	HTTPProxy              string        `json:"http_proxy"`
+	SOCKS5Proxy            string        `json:"socks5_proxy"`
+	TrustBundlePEM         string        `json:"trust_bundle_pem"`
+	Fakemode               bool          `json:"fakemode"`
+	ChainOption            string        `json:"chain_option"`
+	PreferredChain         string        `json:"preferred_chain"`
+	StoreByCN              bool          `json:"store_by_cn"`
+	StoreBySerial          bool          `json:"store_by_serial"`
+	StoreBy                string        `json:"store_by"`
+	NoStore                bool          `json:"no_store"`
+	ServiceGenerated       bool          `json:"service_generated_cert"`
+	StorePrivateKey        bool          `json:"store_pkey"`
+	PurgePrivateKeyOnRead  bool          `json:"purge_pkey_on_read"`
+	SeparatePrivateKey     bool          `json:"separate_private_key"`
+	PreventReissue         bool          `json:"prevent_reissue"`
+	MinCertTimeLeft        time.Duration `json:"min_cert_time_left"`
+	AutoRenew              bool          `json:"auto_renew"`
+	AutoRenewWindow        time.Duration `json:"auto_renew_window"`
+	RenewOnLeaseRenew      bool          `json:"renew_on_lease_renew"`
+	RenewBefore            time.Duration `json:"renew_before"`
+	StoragePrefix          string        `json:"storage_prefix"`
+	KeyType                string        `json:"key_type"`
+	KeyBits                int           `json:"key_bits"`
+	KeyCurve               string        `json:"key_curve"`
+	LeaseMax               string        `json:"lease_max"`
+	Lease                  string        `json:"lease"`
+	TTL                    time.Duration `json:"ttl_duration"`
+	MaxTTL                 time.Duration `json:"max_ttl_duration"`
+	GenerateLease          bool          `json:"generate_lease,omitempty"`
+	PreserveCSRExtensions  bool          `json:"preserve_csr_extensions"`
+	AllowedDomains         []string      `json:"allowed_domains"`
+	AllowedDomainsTemplate bool          `json:"allowed_domains_template"`
+	DeniedDomains          []string      `json:"denied_domains"`
+	AllowSubdomains        bool          `json:"allow_subdomains"`
+	AllowGlobDomains       bool          `json:"allow_glob_domains"`
+	AllowBareDomains       bool          `json:"allow_bare_domains"`
+	AllowWildcards         bool          `json:"allow_wildcard_certificates"`
+	EnforceHostnames       bool          `json:"enforce_hostnames"`
+	AllowUnderscores       bool          `json:"allow_underscores"`
+	AllowIPSANs            bool          `json:"allow_ip_sans"`
+	AllowedURISANs         []string      `json:"allowed_uri_sans"`
+	AllowEmailSANs         bool          `json:"allow_email_sans"`
+	AllowedOtherSANs       []string      `json:"allowed_other_sans"`
+	KeyUsage               []string      `json:"key_usage"`
+	ExtKeyUsage            []string      `json:"ext_key_usage"`
+	ClientAuthOnly         bool          `json:"client_auth_only"`
+	Organization           []string      `json:"organization"`
+	OrganizationalUnit     []string      `json:"organizational_unit"`
+	Country                []string      `json:"country"`
+	Locality               []string      `json:"locality"`
+	Province               []string      `json:"province"`
+	ObjectNameTemplate     string        `json:"object_name_template"`
+	Origin                 string        `json:"origin"`
+	Contacts               []string      `json:"contacts"`
+	CloudIssuingTemplates  templateZones `json:"cloud_issuing_templates"`
+	AllowZoneOverride      bool          `json:"allow_zone_override"`
+	AllowedZones           []string      `json:"allowed_zones"`
+	VenafiPolicy           string        `json:"venafi_policy"`
+	DeprecatedMaxTTL       string        `json:"max_ttl"`
+	DeprecatedTTL          string        `json:"ttl"`
+	ServerTimeout          time.Duration `json:"server_timeout"`
+	RetryMaxAttempts       int           `json:"retry_max_attempts"`
+	RetryBaseDelay         time.Duration `json:"retry_base_delay"`
+	RetryMaxDelay          time.Duration `json:"retry_max_delay"`
+	RetryDisableJitter     bool          `json:"retry_disable_jitter"`
+	IssuanceRateLimit      int           `json:"issuance_rate_limit"`
+	IssuanceLimitPeriod    time.Duration `json:"issuance_rate_limit_period"`
+	MaxIdleConns           int           `json:"max_idle_conns"`
+	CRLURL                 string        `json:"crl_url"`
+	CAChainTTL             time.Duration `json:"ca_chain_ttl"`
+	CRLCacheTTL            time.Duration `json:"crl_cache_ttl"`
+	IdleConnTimeout        time.Duration `json:"idle_conn_timeout"`
+	TCPKeepAlive           time.Duration `json:"tcp_keepalive"`
+}
+
+// templateZones maps Venafi Cloud issuing template names to the zones using them
+type templateZones map[string]string
+
+// hasTPPCredentials returns true if the role has either user and password or an access token for Venafi Platform
+func (r *roleEntry) hasTPPCredentials() bool {
+	return (r.TPPUser != "" && r.TPPPassword != "") || r.AccessToken != ""
 }
 
 func (r *roleEntry) ToResponseData() map[string]interface{} {
 	responseData := map[string]interface{}{
 		//Venafi
-		"tpp_url":   r.TPPURL,
-		"cloud_url": r.CloudURL,
-		"zone":      r.Zone,
+		"tpp_url":            r.TPPURL,
+		"tpp_failover_urls":  r.TPPFailoverURLs,
+		"tpp_load_balancing": r.TPPLoadBalancing,
+		"cloud_url":          r.CloudURL,
+		"zone":               r.Zone,
 		//We shouldn't show credentials
 		//"tpp_password":      r.TPPPassword,
 		//"apikey":            r.Apikey,
-		"tpp_user":               r.TPPUser,
-		"trust_bundle_file":      r.TrustBundleFile,
-		"fakemode":               r.Fakemode,
-		"store_by":               r.StoreBy,
-		"no_store":               r.NoStore,
-		"store_by_cn":            r.StoreByCN,
-		"store_by_serial":        r.StoreBySerial,
-		"service_generated_cert": r.ServiceGenerated,
-		"store_pkey":             r.StorePrivateKey,
-		"ttl":                    int64(r.TTL.Seconds()),
-		"max_ttl":                int64(r.MaxTTL.Seconds()),
-		"generate_lease":         r.GenerateLease,
-		"chain_option":           r.ChainOption,
+		//"access_token":      r.AccessToken,
+		//"refresh_token":     r.RefreshToken,
+		"tpp_user":                    r.TPPUser,
+		"trust_bundle_file":           r.TrustBundleFile,
+		"trust_bundle_pem":            r.TrustBundlePEM,
+		"server_cert_fingerprints":    r.ServerCertFingerprints,
+		"http_proxy":                  r.HTTPProxy,
+		"socks5_proxy":                r.SOCKS5Proxy,
+		"fakemode":                    r.Fakemode,
+		"store_by":                    r.StoreBy,
+		"no_store":                    r.NoStore,
+		"store_by_cn":                 r.StoreByCN,
+		"store_by_serial":             r.StoreBySerial,
+		"service_generated_cert":      r.ServiceGenerated,
+		"store_pkey":                  r.StorePrivateKey,
+		"purge_pkey_on_read":          r.PurgePrivateKeyOnRead,
+		"separate_private_key":        r.SeparatePrivateKey,
+		"prevent_reissue":             r.PreventReissue,
+		"min_cert_time_left":          int64(r.MinCertTimeLeft.Seconds()),
+		"auto_renew":                  r.AutoRenew,
+		"auto_renew_window":           int64(r.AutoRenewWindow.Seconds()),
+		"renew_on_lease_renew":        r.RenewOnLeaseRenew,
+		"renew_before":                int64(r.RenewBefore.Seconds()),
+		"storage_prefix":              r.StoragePrefix,
+		"ttl":                         int64(r.TTL.Seconds()),
+		"max_ttl":                     int64(r.MaxTTL.Seconds()),
+		"generate_lease":              r.GenerateLease,
+		"preserve_csr_extensions":     r.PreserveCSRExtensions,
+		"allowed_domains":             r.AllowedDomains,
+		"allowed_domains_template":    r.AllowedDomainsTemplate,
+		"denied_domains":              r.DeniedDomains,
+		"allow_subdomains":            r.AllowSubdomains,
+		"allow_glob_domains":          r.AllowGlobDomains,
+		"allow_bare_domains":          r.AllowBareDomains,
+		"allow_wildcard_certificates": r.AllowWildcards,
+		"enforce_hostnames":           r.EnforceHostnames,
+		"allow_underscores":           r.AllowUnderscores,
+		"allow_ip_sans":               r.AllowIPSANs,
+		"allowed_uri_sans":            r.AllowedURISANs,
+		"allow_email_sans":            r.AllowEmailSANs,
+		"allowed_other_sans":          r.AllowedOtherSANs,
+		"key_usage":                   r.KeyUsage,
+		"ext_key_usage":               r.ExtKeyUsage,
+		"client_auth_only":            r.ClientAuthOnly,
+		"organization":                r.Organization,
+		"organizational_unit":         r.OrganizationalUnit,
+		"country":                     r.Country,
+		"locality":                    r.Locality,
+		"province":                    r.Province,
+		"object_name_template":        r.ObjectNameTemplate,
+		"origin":                      r.Origin,
+		"contacts":                    r.Contacts,
+		"cloud_issuing_templates":     r.CloudIssuingTemplates,
+		"allow_zone_override":         r.AllowZoneOverride,
+		"allowed_zones":               r.AllowedZones,
+		"venafi_policy":               r.VenafiPolicy,
+		"chain_option":                r.ChainOption,
+		"preferred_chain":             r.PreferredChain,
+		"retry_max_attempts":          r.RetryMaxAttempts,
+		"retry_base_delay":            int64(r.RetryBaseDelay.Seconds()),
+		"retry_max_delay":             int64(r.RetryMaxDelay.Seconds()),
+		"retry_disable_jitter":        r.RetryDisableJitter,
+		"issuance_rate_limit":         r.IssuanceRateLimit,
+		"issuance_rate_limit_period":  int64(r.issuanceRateLimitPeriod().Seconds()),
+		"max_idle_conns":              r.MaxIdleConns,
+		"crl_url":                     r.CRLURL,
+		"ca_chain_ttl":                int64(r.CAChainTTL.Seconds()),
+		"crl_cache_ttl":               int64(r.CRLCacheTTL.Seconds()),
+		"idle_conn_timeout":           int64(r.IdleConnTimeout.Seconds()),
+		"tcp_keepalive":               int64(r.TCPKeepAlive.Seconds()),
 	}
 	return responseData
 }
@@ -389,4 +1164,6 @@ const (
 	pathListRolesHelpDesc = `Roles will be listed by the role name.`
 	pathRoleHelpSyn       = `Manage the roles that can be created with this backend.`
 	pathRoleHelpDesc      = `This path lets you manage the roles that can be created with this backend.`
+	pathRoleFullHelpSyn   = `Read all settings of the role.`
+	pathRoleFullHelpDesc  = `This path returns the role settings without the redaction configured by role_read_redaction in config.`
 )
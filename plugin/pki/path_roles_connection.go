@@ -0,0 +1,102 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathRoleTestConnection(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/test",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRoleTestConnection,
+		},
+
+		HelpSynopsis:    pathRoleTestConnectionHelpSyn,
+		HelpDescription: pathRoleTestConnectionHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleTestConnection(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	respData := map[string]interface{}{
+		"success": false,
+		"zone":    role.Zone,
+	}
+	switch {
+	case role.Fakemode:
+		respData["connector_type"] = "fake"
+	case role.TPPURL != "":
+		respData["connector_type"] = "tpp"
+		respData["url"] = role.TPPURL
+	default:
+		respData["connector_type"] = "cloud"
+		respData["url"] = role.CloudURL
+	}
+	resp := &logical.Response{Data: respData}
+
+	//connection failures are returned as diagnostic data, not as errors
+	//the test always authenticates again instead of using the cached client
+	b.clientCache.purge(roleName)
+	cl, _, err := b.ClientVenafi(ctx, req.Storage, data, req, roleName)
+	if err != nil {
+		respData["stage"] = "authenticate"
+		respData["error"] = err.Error()
+		return resp, nil
+	}
+
+	err = cl.Ping()
+	if err != nil {
+		respData["stage"] = "ping"
+		respData["error"] = err.Error()
+		return resp, nil
+	}
+
+	zoneConfig, err := cl.ReadZoneConfiguration()
+	if err != nil {
+		respData["stage"] = "read_zone"
+		respData["error"] = err.Error()
+		return resp, nil
+	}
+
+	var allowedKeys []string
+	for _, kc := range zoneConfig.AllowedKeyConfigurations {
+		allowedKeys = append(allowedKeys, kc.KeyType.String())
+	}
+
+	respData["success"] = true
+	respData["organization"] = zoneConfig.Organization
+	respData["organizational_unit"] = zoneConfig.OrganizationalUnit
+	respData["country"] = zoneConfig.Country
+	respData["province"] = zoneConfig.Province
+	respData["locality"] = zoneConfig.Locality
+	respData["allowed_key_types"] = allowedKeys
+	return resp, nil
+}
+
+const (
+	pathRoleTestConnectionHelpSyn  = `Test the connection to the Venafi endpoint configured in the role.`
+	pathRoleTestConnectionHelpDesc = `
+This path authenticates to the Venafi Platform or Cloud with the role settings
+and reads the zone configuration without requesting any certificate.
+The "success" field reports the result. On failure "stage" tells which step
+failed (authenticate, ping or read_zone) and "error" contains the reason.
+`
+)
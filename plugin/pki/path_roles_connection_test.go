@@ -0,0 +1,37 @@
+package pki
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRoleTestConnection(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/fake",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roles/fake/test",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["success"] != true {
+		t.Fatalf("Expecting successful connection test but got %#v", resp)
+	}
+	if resp.Data["connector_type"] != "fake" {
+		t.Fatalf("Expecting fake connector but got %s", resp.Data["connector_type"])
+	}
+}
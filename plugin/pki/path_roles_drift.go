@@ -0,0 +1,176 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathRolePolicyDrift(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/policy-drift",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathRolePolicyDrift,
+		},
+
+		HelpSynopsis:    pathRolePolicyDriftHelpSyn,
+		HelpDescription: pathRolePolicyDriftHelpDesc,
+	}
+}
+
+func (b *backend) pathRolePolicyDrift(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	var synced *venafiPolicy
+	zone := role.Zone
+	if role.VenafiPolicy != "" {
+		if synced, err = b.getVenafiPolicy(ctx, req.Storage, role.VenafiPolicy); err != nil {
+			return nil, err
+		}
+		if synced != nil {
+			zone = synced.Zone
+		}
+	}
+
+	cl, _, err := b.clientVenafi(ctx, req, roleName, "", zone)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	zoneConfig, err := cl.ReadZoneConfiguration()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to read policy of zone %s: %s", zone, err)), nil
+	}
+
+	differences := policyDrift(role, zoneConfig, synced)
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"zone":        zone,
+			"in_sync":     len(differences) == 0,
+			"differences": differences,
+		},
+	}, nil
+}
+
+func driftDifference(setting string, local interface{}, zone interface{}, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"setting":     setting,
+		"local":       local,
+		"zone":        zone,
+		"description": description,
+	}
+}
+
+// policyDrift compares the local constraints of the role and the synced policy it is bound to with the live zone policy
+func policyDrift(role *roleEntry, zoneConfig *endpoint.ZoneConfiguration, synced *venafiPolicy) []map[string]interface{} {
+	live := newVenafiPolicy(role.VenafiPolicy, "", zoneConfig)
+	differences := make([]map[string]interface{}, 0)
+
+	if err := validateZoneKeyPolicy(role, zoneConfig); err != nil {
+		differences = append(differences, driftDifference("key_type", map[string]interface{}{
+			"key_type":  role.KeyType,
+			"key_bits":  role.KeyBits,
+			"key_curve": role.KeyCurve,
+		}, live.AllowedKeyConfigurations, err.Error()))
+	}
+
+	for _, field := range []struct {
+		name    string
+		values  []string
+		regexes []string
+	}{
+		{"organization", role.Organization, live.SubjectORegexes},
+		{"organizational_unit", role.OrganizationalUnit, live.SubjectOURegexes},
+		{"country", role.Country, live.SubjectCRegexes},
+		{"province", role.Province, live.SubjectSTRegexes},
+		{"locality", role.Locality, live.SubjectLRegexes},
+	} {
+		for _, value := range field.values {
+			if !matchesPolicyRegex(value, field.regexes) {
+				differences = append(differences, driftDifference(field.name, field.values, field.regexes,
+					fmt.Sprintf("%s %q of the role is not allowed by the zone", field.name, value)))
+				break
+			}
+		}
+	}
+
+	//a name which the role allows for each domain is checked against the zone
+	for _, domain := range role.AllowedDomains {
+		//the templated domains depend on the entity of the request
+		if role.AllowedDomainsTemplate && strings.Contains(domain, "{{") {
+			continue
+		}
+		name := domain
+		if role.AllowGlobDomains && strings.Contains(domain, "*") {
+			name = strings.Replace(domain, "*", "vault", -1)
+		} else if !role.AllowBareDomains && role.AllowSubdomains {
+			name = "vault." + domain
+		}
+		if !matchesPolicyRegex(name, live.SubjectCNRegexes) && !matchesPolicyRegex(name, live.DNSSANRegexes) {
+			differences = append(differences, driftDifference("allowed_domains", role.AllowedDomains, live.SubjectCNRegexes,
+				fmt.Sprintf("allowed domain %s of the role is not allowed by the zone", domain)))
+		}
+	}
+
+	if role.AllowWildcards && !live.AllowWildcards {
+		differences = append(differences, driftDifference("allow_wildcard_certificates", true, false,
+			"wildcards are allowed by the role but not by the zone"))
+	}
+
+	if role.AllowIPSANs && len(live.IPSANRegexes) == 0 {
+		differences = append(differences, driftDifference("allow_ip_sans", true, live.IPSANRegexes,
+			"IP SANs are allowed by the role but not by the zone"))
+	}
+	if role.AllowEmailSANs && len(live.EmailSANRegexes) == 0 {
+		differences = append(differences, driftDifference("allow_email_sans", true, live.EmailSANRegexes,
+			"email SANs are allowed by the role but not by the zone"))
+	}
+	if len(role.AllowedURISANs) > 0 && len(live.URISANRegexes) == 0 {
+		differences = append(differences, driftDifference("allowed_uri_sans", role.AllowedURISANs, live.URISANRegexes,
+			"URI SANs are allowed by the role but not by the zone"))
+	}
+
+	if synced == nil {
+		return differences
+	}
+	//the settings of the synced policy changed at Venafi since it was refreshed
+	syncedData, liveData := synced.toResponseData(), live.toResponseData()
+	for _, setting := range []string{"subject_cn_regexes", "subject_o_regexes", "subject_ou_regexes", "subject_st_regexes",
+		"subject_l_regexes", "subject_c_regexes", "allowed_key_configurations", "dns_san_regexes", "ip_san_regexes",
+		"email_san_regexes", "uri_san_regexes", "upn_san_regexes", "allow_wildcards", "allow_key_reuse"} {
+		if !reflect.DeepEqual(syncedData[setting], liveData[setting]) {
+			differences = append(differences, driftDifference(setting, syncedData[setting], liveData[setting],
+				fmt.Sprintf("%s of policy %s synced at %s differs from the zone", setting, role.VenafiPolicy,
+					formatStatusTime(synced.LastRefreshed))))
+		}
+	}
+	return differences
+}
+
+const pathRolePolicyDriftHelpSyn = `
+Compare the role with the live Venafi zone policy.
+`
+
+const pathRolePolicyDriftHelpDesc = `
+Reads the zone policy from Venafi and reports the local constraints of the role which it doesn't allow anymore,
+e.g. the key settings, the subject defaults or the allowed SAN types, and the settings of the synced venafi_policy
+the role is bound to which changed at Venafi since it was refreshed. The zone of the bound policy is compared
+if it's set, the zone of the role otherwise. in_sync is true if there are no differences.
+`
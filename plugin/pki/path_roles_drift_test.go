@@ -0,0 +1,88 @@
+package pki
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRolePolicyDrift(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request(logical.ReadOperation, "roles/missing/policy-drift", nil); resp == nil || !resp.IsError() {
+		t.Fatalf("Expected an error for an unknown role, got %#v", resp)
+	}
+	if resp := request(logical.UpdateOperation, "roles/drift", map[string]interface{}{"fakemode": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := request(logical.ReadOperation, "roles/drift/policy-drift", nil)
+	if resp == nil || resp.IsError() || resp.Data["in_sync"] != true {
+		t.Fatalf("Expected the role to be in sync with the zone, got %#v", resp)
+	}
+
+	//the synced policy is changed to simulate a zone policy changed at Venafi
+	if resp := request(logical.UpdateOperation, "venafi-policy/drift", map[string]interface{}{"role": "drift"}); resp == nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := request(logical.UpdateOperation, "roles/drift", map[string]interface{}{"fakemode": true, "venafi_policy": "drift"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	policy, err := b.getVenafiPolicy(ctx, storage, "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.AllowWildcards = false
+	policy.DNSSANRegexes = []string{".*\\.venafi\\.example\\.com"}
+	if err := putVenafiPolicy(ctx, storage, "drift", policy); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(logical.ReadOperation, "roles/drift/policy-drift", nil)
+	differences := resp.Data["differences"].([]map[string]interface{})
+	if resp.Data["in_sync"] != false || len(differences) != 2 || differences[0]["setting"] != "dns_san_regexes" ||
+		differences[1]["setting"] != "allow_wildcards" || differences[1]["local"] != false {
+		t.Fatalf("Expected the changed settings of the synced policy to be reported, got %#v", resp.Data)
+	}
+
+	//the local constraints of the role are compared with a restricted zone
+	role, err := b.getRole(ctx, storage, "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role.KeyType = "rsa"
+	role.KeyBits = 1024
+	role.Organization = []string{"Example"}
+	role.AllowIPSANs = true
+	role.AllowEmailSANs = false
+	zoneConfig := &endpoint.ZoneConfiguration{
+		Policy: endpoint.Policy{
+			SubjectORegexes: []string{"^Venafi$"},
+			AllowedKeyConfigurations: []endpoint.AllowedKeyConfiguration{
+				{KeyType: certificate.KeyTypeRSA, KeySizes: []int{2048, 4096}},
+			},
+		},
+	}
+	var settings []string
+	for _, difference := range policyDrift(role, zoneConfig, nil) {
+		settings = append(settings, difference["setting"].(string))
+	}
+	if !reflect.DeepEqual(settings, []string{"key_type", "organization", "allow_wildcard_certificates", "allow_ip_sans"}) {
+		t.Fatalf("Expected the key, organization, wildcards and IP SANs drift, got %v", settings)
+	}
+}
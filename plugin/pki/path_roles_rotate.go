@@ -0,0 +1,262 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/helper/base62"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathRoleRotateCredentials(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/rotate-credentials",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+			"new_password": {
+				Type:        framework.TypeString,
+				Description: `New password for web API user. If not set a random password will be generated`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRoleRotateCredentials,
+		},
+
+		HelpSynopsis:    pathRoleRotateCredentialsHelpSyn,
+		HelpDescription: pathRoleRotateCredentialsHelpDesc,
+	}
+}
+
+func pathRoleRotateAPIKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/rotate-apikey",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRoleRotateAPIKey,
+		},
+
+		HelpSynopsis:    pathRoleRotateAPIKeyHelpSyn,
+		HelpDescription: pathRoleRotateAPIKeyHelpDesc,
+	}
+}
+
+const (
+	errorTextRotateAPIKeyNoAPIKey        = `API key rotation requires apikey in the role`
+	errorTextRotateCredentialsNoPassword = `credentials rotation requires tpp_url, tpp_user and tpp_password in the role`
+	generatedPasswordLength              = 32
+)
+
+func (b *backend) pathRoleRotateCredentials(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	unlock := b.lockCredentials()
+	defer unlock()
+
+	roleName := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if role.TPPURL == "" || role.TPPUser == "" || role.TPPPassword == "" {
+		return logical.ErrorResponse(errorTextRotateCredentialsNoPassword), nil
+	}
+
+	newPassword := data.Get("new_password").(string)
+	if newPassword == "" {
+		newPassword, err = base62.Random(generatedPasswordLength, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := b.newTPPAPIClient(role)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.authorize(role.TPPUser, role.TPPPassword); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	identity, err := client.self()
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	b.Logger().Info("Rotating password", "identity", identity.PrefixedName, "tpp_url", role.TPPURL)
+	if err := client.setPassword(identity, role.TPPPassword, newPassword); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	//the old password doesn't work anymore, so all roles which are using the same account are updated before
+	//the new password is verified
+	oldPassword := role.TPPPassword
+	updatedRoles, err := b.putRotatedRoles(ctx, req.Storage, func(r *roleEntry) bool {
+		if r.TPPURL != role.TPPURL || r.TPPUser != role.TPPUser || r.TPPPassword != oldPassword {
+			return false
+		}
+		r.TPPPassword = newPassword
+		return true
+	})
+	if err != nil {
+		b.Logger().Error("Password was changed but storing it failed", "tpp_url", role.TPPURL, "updated_roles", updatedRoles, "error", err)
+		return rotationFailureResponse(req, err, "tpp_password", newPassword, updatedRoles)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"tpp_url":       role.TPPURL,
+			"tpp_user":      role.TPPUser,
+			"updated_roles": updatedRoles,
+		},
+	}
+	if err := client.authorize(role.TPPUser, newPassword); err != nil {
+		resp.AddWarning(fmt.Sprintf("password was changed on %s and stored, but authentication with it failed: %s", role.TPPURL, err))
+	}
+	return resp, nil
+}
+
+func (b *backend) pathRoleRotateAPIKey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	unlock := b.lockCredentials()
+	defer unlock()
+
+	roleName := data.Get("name").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if role.Apikey == "" {
+		return logical.ErrorResponse(errorTextRotateAPIKeyNoAPIKey), nil
+	}
+
+	client, err := b.newCloudAPIClient(role)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := client.rotateAPIKey()
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	//the old key is not valid anymore, so all roles which are using it are updated before the new key is verified
+	oldKey := role.Apikey
+	updatedRoles, err := b.putRotatedRoles(ctx, req.Storage, func(r *roleEntry) bool {
+		if r.Apikey != oldKey {
+			return false
+		}
+		r.Apikey = newKey.Key
+		return true
+	})
+	if err != nil {
+		b.Logger().Error("API key was rotated but storing it failed", "updated_roles", updatedRoles, "error", err)
+		return rotationFailureResponse(req, err, "apikey", newKey.Key, updatedRoles)
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"username":            newKey.Username,
+			"validity_start_date": newKey.ValidityStartDate,
+			"validity_end_date":   newKey.ValidityEndDate,
+			"updated_roles":       updatedRoles,
+		},
+	}
+	client.apiKey = newKey.Key
+	if _, err := client.userDetails(); err != nil {
+		resp.AddWarning(fmt.Sprintf("API key was rotated on Venafi Cloud and stored, but authentication with it failed: %s", err))
+	}
+	return resp, nil
+}
+
+// lockCredentials serializes the credential rotations with each other and with the access token refreshes and the
+// credentials migration of the periodic function, so none of them overwrites the roles with stale credentials
+func (b *backend) lockCredentials() func() {
+	b.credentialsLock.Lock()
+	b.tokenRefreshLock.Lock()
+	return func() {
+		b.tokenRefreshLock.Unlock()
+		b.credentialsLock.Unlock()
+	}
+}
+
+// putRotatedRoles stores the rotated credentials in every role which update changes. All roles are read before any
+// of them is written, so a read error doesn't leave a part of the roles with the old credentials. The names of the
+// roles written before a failure are returned with the error
+func (b *backend) putRotatedRoles(ctx context.Context, s logical.Storage, update func(r *roleEntry) bool) ([]string, error) {
+	names, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	roles := make(map[string]*roleEntry)
+	for _, name := range names {
+		r, err := b.getRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil || !update(r) {
+			continue
+		}
+		rotated = append(rotated, name)
+		roles[name] = r
+	}
+
+	var updatedRoles []string
+	for _, name := range rotated {
+		if err := b.putRole(ctx, s, name, roles[name]); err != nil {
+			return updatedRoles, fmt.Errorf("failed to store role %s: %s", name, err)
+		}
+		updatedRoles = append(updatedRoles, name)
+	}
+	return updatedRoles, nil
+}
+
+// rotationFailureResponse is returned when the credentials were rotated at Venafi but couldn't be stored in all roles.
+// The old credentials don't work anymore, so the new ones are returned for the operator to update the remaining roles,
+// an error response can't have data besides the error message, so the status code is set instead
+func rotationFailureResponse(req *logical.Request, err error, field, secret string, updatedRoles []string) (*logical.Response, error) {
+	return logical.RespondWithStatusCode(&logical.Response{
+		Data: map[string]interface{}{
+			"error": fmt.Sprintf("credentials were rotated at Venafi but storing them failed, "+
+				"set %s from this response in the roles which are not in updated_roles: %s", field, err),
+			field:           secret,
+			"updated_roles": updatedRoles,
+		},
+	}, req, http.StatusInternalServerError)
+}
+
+const (
+	pathRoleRotateAPIKeyHelpSyn  = `Rotate the Venafi Cloud API key used by the role.`
+	pathRoleRotateAPIKeyHelpDesc = `
+This path exchanges the apikey of the role for a new one on Venafi Cloud, stores
+it in every role which used the old key and checks that the new key works, a
+warning is returned if it doesn't. Only the key metadata is returned, unless the
+new key couldn't be stored, then it's returned with the roles which were updated.
+`
+	pathRoleRotateCredentialsHelpSyn  = `Rotate the password of the Venafi Platform web API user used by the role.`
+	pathRoleRotateCredentialsHelpDesc = `
+This path changes the password of the tpp_user on Venafi Platform, stores it in
+every role which uses the same tpp_url and tpp_user and checks that authentication
+with the new password works, a warning is returned if it doesn't. The new password
+is returned only if it couldn't be stored, with the roles which were updated.
+`
+)
@@ -0,0 +1,211 @@
+package pki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+// failingStorage fails the writes of the given key, e.g. to interrupt a rotation half way
+type failingStorage struct {
+	logical.Storage
+	failPut string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if entry.Key == s.failPut {
+		return fmt.Errorf("storage unavailable")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestRoleRotateCredentials(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	//the new password is set, but authentication with it fails
+	password := "old-password"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vedsdk/authorize/":
+			var auth tppAuthorizeRequest
+			if err := json.NewDecoder(r.Body).Decode(&auth); err != nil || auth.Password != "old-password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"APIKey":"api-key"}`)
+		case "/vedsdk/Identity/Self":
+			fmt.Fprint(w, `{"Identities":[{"PrefixedName":"local:vault","PrefixedUniversal":"local:{1}"}]}`)
+		case "/vedsdk/Identity/SetPassword":
+			var set tppSetPasswordRequest
+			if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+				t.Error(err)
+			}
+			password = set.Password
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for name, user := range map[string]string{"first": "vault", "second": "vault", "other": "other"} {
+		role := &roleEntry{TPPURL: server.URL, TPPUser: user, TPPPassword: "old-password", Zone: "Certificates"}
+		if err := b.putRole(ctx, storage, name, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/first/rotate-credentials",
+		Storage:   storage,
+		Data:      map[string]interface{}{"new_password": "new-password"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if !reflect.DeepEqual(resp.Data["updated_roles"], []string{"first", "second"}) {
+		t.Fatalf("Expecting the roles of the account to be updated, got %v", resp.Data["updated_roles"])
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "authentication with it failed") {
+		t.Fatalf("Expecting a verification warning, got %v", resp.Warnings)
+	}
+	if _, ok := resp.Data["tpp_password"]; ok {
+		t.Fatalf("Expecting the stored password not to be returned")
+	}
+	if password != "new-password" {
+		t.Fatalf("Expecting the password to be changed, got %s", password)
+	}
+	for name, expected := range map[string]string{"first": "new-password", "second": "new-password", "other": "old-password"} {
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role.TPPPassword != expected {
+			t.Fatalf("Expecting password %s in role %s, got %s", expected, name, role.TPPPassword)
+		}
+	}
+}
+
+func TestRoleRotateAPIKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/useraccounts/rotateapikey" && r.Header.Get("tppl-api-key") == "old-key":
+			fmt.Fprint(w, `{"apiKey":{"key":"new-key","username":"vault@example.com"}}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"first", "second", "third"} {
+		if err := b.putRole(ctx, storage, name, &roleEntry{Apikey: "old-key", CloudURL: server.URL, Zone: "zone"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the rotated key is returned if it can't be stored in all roles, second is listed before third
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/first/rotate-apikey",
+		Storage:   &failingStorage{Storage: storage, failPut: credentialsPrefix + "third"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data[logical.HTTPStatusCode] != http.StatusInternalServerError {
+		t.Fatalf("Expecting status 500, got %#v", resp)
+	}
+	var body struct {
+		Data struct {
+			Error        string   `json:"error"`
+			APIKey       string   `json:"apikey"`
+			UpdatedRoles []string `json:"updated_roles"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(resp.Data[logical.HTTPRawBody].(string)), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.Error == "" || body.Data.APIKey != "new-key" || !reflect.DeepEqual(body.Data.UpdatedRoles, []string{"first", "second"}) {
+		t.Fatalf("Expecting the new key and the updated roles in the error response, got %#v", body)
+	}
+	for name, expected := range map[string]string{"first": "new-key", "second": "new-key", "third": "old-key"} {
+		role, err := b.getRole(ctx, storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role.Apikey != expected {
+			t.Fatalf("Expecting key %s in role %s, got %s", expected, name, role.Apikey)
+		}
+	}
+
+	//the key is stored even if the verification with the new key fails
+	if err := b.putRole(ctx, storage, "first", &roleEntry{Apikey: "old-key", CloudURL: server.URL, Zone: "zone"}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/first/rotate-apikey",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "authentication with it failed") {
+		t.Fatalf("Expecting a verification warning, got %v", resp.Warnings)
+	}
+	if !reflect.DeepEqual(resp.Data["updated_roles"], []string{"first", "third"}) {
+		t.Fatalf("Expecting the roles with the old key to be updated, got %v", resp.Data["updated_roles"])
+	}
+}
+
+func TestRoleRotateRequiresCredentials(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/fake",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/fake/rotate-credentials",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error response but got %#v", resp)
+	}
+	if resp.Data["error"] != errorTextRotateCredentialsNoPassword {
+		t.Fatalf("Expecting error %s but got %s", errorTextRotateCredentialsNoPassword, resp.Data["error"])
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/fake/rotate-apikey",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextRotateAPIKeyNoAPIKey {
+		t.Fatalf("Expecting error %s but got %#v", errorTextRotateAPIKeyNoAPIKey, resp)
+	}
+}
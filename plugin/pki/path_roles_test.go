@@ -1,8 +1,22 @@
 package pki
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
 )
 
 func TestRoleValidate(t *testing.T) {
@@ -50,6 +64,180 @@ func TestRoleValidate(t *testing.T) {
 		t.Fatalf("Expecting error %s but got %s", errorTextTPPandCloudMixedCredentials, err)
 	}
 
+	entry = &roleEntry{
+		TPPURL:       "https://qa-tpp.exmple.com/vedsdk",
+		RefreshToken: "xxxxxxxxxxxxxxxxxxxxxx==",
+	}
+
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextInvalidMode {
+		t.Fatalf("Expecting error %s but got %s", errorTextInvalidMode, err)
+	}
+
+	entry = &roleEntry{
+		TPPURL:       "https://qa-tpp.exmple.com/vedsdk",
+		TPPUser:      "admin",
+		TPPPassword:  "xxxx",
+		RefreshToken: "xxxxxxxxxxxxxxxxxxxxxx==",
+	}
+
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextRefreshTokenWithoutAccessToken {
+		t.Fatalf("Expecting error %s but got %s", errorTextRefreshTokenWithoutAccessToken, err)
+	}
+
+	entry = &roleEntry{
+		TPPURL:       "https://qa-tpp.exmple.com/vedsdk",
+		AccessToken:  "xxxxxxxxxxxxxxxxxxxxxx==",
+		RefreshToken: "xxxxxxxxxxxxxxxxxxxxxx==",
+	}
+
+	err = validateEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:        true,
+		TrustBundleFile: "/opt/venafi/bundle.pem",
+		TrustBundlePEM:  "-----BEGIN CERTIFICATE-----",
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextTrustBundleFileAndPEMConflict {
+		t.Fatalf("Expecting error %s but got %s", errorTextTrustBundleFileAndPEMConflict, err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:       true,
+		TrustBundlePEM: "not a certificate",
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextInvalidTrustBundlePEM {
+		t.Fatalf("Expecting error %s but got %s", errorTextInvalidTrustBundlePEM, err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:               true,
+		ServerCertFingerprints: []string{"AB:CD"},
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != fmt.Sprintf(errorTextInvalidFingerprint, "AB:CD") {
+		t.Fatalf("Expecting error %s but got %s", fmt.Sprintf(errorTextInvalidFingerprint, "AB:CD"), err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:               true,
+		ServerCertFingerprints: []string{strings.Repeat("AB:", 31) + "AB"},
+	}
+	err = validateEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ServerCertFingerprints[0] != strings.Repeat("ab", 32) {
+		t.Fatalf("Expecting normalized fingerprint but got %s", entry.ServerCertFingerprints[0])
+	}
+
+	entry = &roleEntry{
+		Fakemode:    true,
+		HTTPProxy:   "http://proxy.example.com:3128",
+		SOCKS5Proxy: "socks5://proxy.example.com:1080",
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextHTTPAndSOCKS5ProxyConflict {
+		t.Fatalf("Expecting error %s but got %s", errorTextHTTPAndSOCKS5ProxyConflict, err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:     true,
+		MaxIdleConns: -1,
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextNegativeConnectionSetting {
+		t.Fatalf("Expecting error %s but got %s", errorTextNegativeConnectionSetting, err)
+	}
+
+	entry = &roleEntry{
+		Apikey:          "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		TPPFailoverURLs: []string{"https://tpp2.venafi.example/vedsdk"},
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextFailoverURLsWithoutTPPURL {
+		t.Fatalf("Expecting error %s but got %s", errorTextFailoverURLsWithoutTPPURL, err)
+	}
+
+	entry = &roleEntry{
+		Apikey:   "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		Contacts: []string{"local:{1}"},
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextContactsWithoutTPPURL {
+		t.Fatalf("Expecting error %s but got %s", errorTextContactsWithoutTPPURL, err)
+	}
+
+	entry = &roleEntry{
+		Apikey:           "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
+		ServiceGenerated: true,
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error")
+	}
+	if err.Error() != errorTextServiceGeneratedWithCloud {
+		t.Fatalf("Expecting error %s but got %s", errorTextServiceGeneratedWithCloud, err)
+	}
+
+	entry = &roleEntry{
+		Fakemode:    true,
+		SOCKS5Proxy: "http://proxy.example.com:1080",
+	}
+	err = validateEntry(entry)
+	if err == nil {
+		t.Fatalf("Expecting error for socks5_proxy with http scheme")
+	}
+
+	entry = &roleEntry{
+		Fakemode:  true,
+		HTTPProxy: "proxy.example.com:3128",
+	}
+	err = validateEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := entry.proxyURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.String() != "http://proxy.example.com:3128" {
+		t.Fatalf("Expecting http://proxy.example.com:3128 but got %s", proxy)
+	}
+
 	entry = &roleEntry{
 		Apikey:    "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx",
 		StoreByCN: true,
@@ -125,7 +313,7 @@ func TestRoleValidate(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Expecting error")
 	}
-	expectingError := fmt.Sprintf(errTextStoreByWrongOption, storeBySerialString, storeByCNString, "sebial")
+	expectingError := fmt.Sprintf(errTextStoreByWrongOption, storeBySerialString, storeByCNString, storeByHashString, "sebial")
 	if err.Error() != expectingError {
 		t.Fatalf("Expecting error %s but got %s", expectingError, err)
 	}
@@ -159,3 +347,414 @@ func TestRoleValidate(t *testing.T) {
 		t.Fatalf("Expecting store_by parameter will be set to %s", storeBySerialString)
 	}
 }
+
+func TestAccessTokenNeedsRefresh(t *testing.T) {
+	entry := &roleEntry{
+		AccessToken: "xxxxxxxxxxxxxxxxxxxxxx==",
+	}
+	if entry.accessTokenNeedsRefresh() {
+		t.Fatalf("Role without refresh token should not be refreshed")
+	}
+
+	entry.RefreshToken = "xxxxxxxxxxxxxxxxxxxxxx=="
+	if !entry.accessTokenNeedsRefresh() {
+		t.Fatalf("Access token with unknown expiration should be refreshed")
+	}
+
+	entry.AccessTokenExpiry = time.Now().Add(accessTokenRefreshWindow / 2)
+	if !entry.accessTokenNeedsRefresh() {
+		t.Fatalf("Access token expiring in %s should be refreshed", accessTokenRefreshWindow/2)
+	}
+
+	entry.AccessTokenExpiry = time.Now().Add(24 * time.Hour)
+	if entry.accessTokenNeedsRefresh() {
+		t.Fatalf("Access token expiring in 24h should not be refreshed")
+	}
+}
+
+func TestRoleZoneOverride(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for name, allow := range map[string]bool{"fixed": false, "override": true} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data: map[string]interface{}{"fakemode": true, "zone": "Default", "allow_zone_override": allow,
+				"allowed_zones": "Other,DevOps\\*"},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+
+	issueData := map[string]interface{}{"common_name": "zone.venafi.example.com", "zone": "Other"}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/fixed",
+		Storage:   storage,
+		Data:      issueData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextZoneOverrideNotAllowed {
+		t.Fatalf("Expecting error %s but got %#v", errorTextZoneOverrideNotAllowed, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/override",
+		Storage:   storage,
+		Data:      issueData,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	issueData["zone"] = "DevOps\\Web"
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/override",
+		Storage:   storage,
+		Data:      issueData,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	issueData["zone"] = "Production"
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/override",
+		Storage:   storage,
+		Data:      issueData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != fmt.Sprintf(errorTextZoneNotAllowed, "Production") {
+		t.Fatalf("Expecting zone not allowed error but got %#v", resp)
+	}
+
+	//empty zone doesn't override the zone of the role
+	issueData["zone"] = ""
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/fixed",
+		Storage:   storage,
+		Data:      issueData,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+}
+
+func TestRoleObjectNameTemplate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for template, valid := range map[string]bool{
+		"{{.CommonName}}-{{.Timestamp}}": true,
+		"{{.CommonName":                  false,
+		"{{.Unknown}}":                   false,
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/object-name",
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "object_name_template": template},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("object_name_template %s: expecting valid %v but got %#v", template, valid, resp)
+		}
+	}
+
+	role := &roleEntry{Zone: "DevOps\\Web", ObjectNameTemplate: "{{.RoleName}}/{{.CommonName}}-{{.Timestamp}}"}
+	name, err := role.renderObjectName("web", "www.example.com", "", time.Date(2020, 3, 5, 9, 4, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "web/www.example.com-20200305090400" {
+		t.Fatalf("Unexpected object name %s", name)
+	}
+}
+
+func TestRoleKeyBits(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/key-bits",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "key_bits": 1024},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != fmt.Sprintf(errorTextInvalidKeyBits, 1024, allowedRSAKeyBits) {
+		t.Fatalf("Expecting error for key_bits 1024, got %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/key-bits",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "key_bits": 3072},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/key-bits",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "key-bits.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits := cert.PublicKey.(*rsa.PublicKey).N.BitLen(); bits != 3072 {
+		t.Fatalf("Expecting 3072 bits key, got %d", bits)
+	}
+}
+
+func TestRoleValidateZonePolicy(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, c := range []struct {
+		data  map[string]interface{}
+		valid bool
+	}{
+		//the fake zone allows RSA keys with 1024, 2048, 4096 and 8192 bits and all EC curves
+		{map[string]interface{}{"key_bits": 4096}, true},
+		{map[string]interface{}{"key_bits": 3072}, false},
+		{map[string]interface{}{"key_bits": 3072, "validate_zone_policy": false}, true},
+		{map[string]interface{}{"key_type": "ec", "key_curve": "P384"}, true},
+	} {
+		c.data["fakemode"] = true
+		if _, ok := c.data["validate_zone_policy"]; !ok {
+			c.data["validate_zone_policy"] = true
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/zone-policy",
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.valid == (resp != nil && resp.IsError()) {
+			t.Fatalf("%v: expecting valid %v but got %#v", c.data, c.valid, resp)
+		}
+	}
+}
+
+func TestRoleCredentialsStorage(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/credentials",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"tpp_url":      "https://tpp.example.com/vedsdk",
+			"tpp_user":     "admin",
+			"tpp_password": "secret-password",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	entry, err := storage.Get(ctx, "role/credentials")
+	if err != nil || entry == nil {
+		t.Fatalf("Expected role entry, got %v %v", entry, err)
+	}
+	if strings.Contains(string(entry.Value), "secret-password") {
+		t.Fatal("Role entry contains the password")
+	}
+	entry, err = storage.Get(ctx, credentialsPrefix+"credentials")
+	if err != nil || entry == nil || !strings.Contains(string(entry.Value), "secret-password") {
+		t.Fatalf("Expected password in the credentials entry, got %v %v", entry, err)
+	}
+	role, err := b.getRole(ctx, storage, "credentials")
+	if err != nil || role.TPPPassword != "secret-password" {
+		t.Fatalf("Expected password in the role, got %v %v", role, err)
+	}
+
+	//roles written by the older versions keep the credentials in the role entry
+	legacy, err := logical.StorageEntryJSON("role/legacy", roleEntry{Fakemode: true, Apikey: "legacy-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+	role, err = b.getRole(ctx, storage, "legacy")
+	if err != nil || role.Apikey != "legacy-key" {
+		t.Fatalf("Expected API key from the legacy role entry, got %v %v", role, err)
+	}
+	if err := b.putRole(ctx, storage, "legacy", role); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = storage.Get(ctx, "role/legacy")
+	if err != nil || strings.Contains(string(entry.Value), "legacy-key") {
+		t.Fatalf("Expected the API key to be moved out of the role entry, got %v", entry)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roles/credentials",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if entry, err = storage.Get(ctx, credentialsPrefix+"credentials"); err != nil || entry != nil {
+		t.Fatalf("Expected credentials to be deleted with the role, got %v %v", entry, err)
+	}
+}
+
+func TestRoleReadRedaction(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Trusted CA"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/redacted",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"tpp_url":           "https://tpp.example.com/vedsdk",
+			"tpp_failover_urls": "https://tpp2.example.com/vedsdk",
+			"tpp_user":          "admin",
+			"tpp_password":      "secret-password",
+			"trust_bundle_pem":  trustBundle,
+			"http_proxy":        "http://proxy.example.com:3128",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	readRole := func(path string) map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp.Data
+	}
+	if data := readRole("roles/redacted"); data["tpp_user"] != "admin" {
+		t.Fatalf("Expected tpp_user without redaction, got %v", data["tpp_user"])
+	}
+
+	for redaction, expected := range map[string]interface{}{roleReadRedactionMask: redactedValue, roleReadRedactionOmit: nil} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Storage:   storage,
+			Data:      map[string]interface{}{"role_read_redaction": redaction},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		data := readRole("roles/redacted")
+		if data["tpp_user"] != expected || data["http_proxy"] != expected {
+			t.Fatalf("%s: expected %v, got tpp_user %v and http_proxy %v", redaction, expected, data["tpp_user"], data["http_proxy"])
+		}
+		if data["tpp_url"] != expected || data["trust_bundle_pem"] != expected {
+			t.Fatalf("%s: expected %v, got tpp_url %v and trust_bundle_pem %v", redaction, expected, data["tpp_url"], data["trust_bundle_pem"])
+		}
+		urls, present := data["tpp_failover_urls"]
+		if redaction == roleReadRedactionMask && !reflect.DeepEqual(urls, []string{redactedValue}) ||
+			redaction == roleReadRedactionOmit && present {
+			t.Fatalf("%s: unexpected tpp_failover_urls %v", redaction, urls)
+		}
+		//empty values are not masked
+		if redaction == roleReadRedactionMask && data["socks5_proxy"] != "" {
+			t.Fatalf("Expected empty socks5_proxy, got %v", data["socks5_proxy"])
+		}
+		if data := readRole("roles/redacted/full"); data["tpp_user"] != "admin" {
+			t.Fatalf("Expected tpp_user in the full role, got %v", data["tpp_user"])
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"role_read_redaction": "hide"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expected error for invalid redaction, got err: %v resp: %#v", err, resp)
+	}
+}
+
+func TestMigrateRoleCredentials(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for name, role := range map[string]roleEntry{
+		"legacy-tpp":   {TPPURL: "https://tpp.example.com/vedsdk", TPPUser: "admin", TPPPassword: "legacy-password"},
+		"legacy-cloud": {Apikey: "legacy-key"},
+		"fake":         {Fakemode: true},
+	} {
+		entry, err := logical.StorageEntryJSON("role/"+name, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.migrateRoleCredentials(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	for name, secret := range map[string]string{"legacy-tpp": "legacy-password", "legacy-cloud": "legacy-key"} {
+		entry, err := storage.Get(ctx, "role/"+name)
+		if err != nil || strings.Contains(string(entry.Value), secret) {
+			t.Fatalf("Expected %s credentials to be moved out of the role entry, got %v %v", name, entry, err)
+		}
+		entry, err = storage.Get(ctx, credentialsPrefix+name)
+		if err != nil || entry == nil || !strings.Contains(string(entry.Value), secret) {
+			t.Fatalf("Expected %s credentials in %s, got %v %v", name, credentialsPrefix, entry, err)
+		}
+	}
+	if entry, err := storage.Get(ctx, credentialsPrefix+"fake"); err != nil || entry != nil {
+		t.Fatalf("Expected role without credentials to be left unchanged, got %v %v", entry, err)
+	}
+	role, err := b.getRole(ctx, storage, "legacy-tpp")
+	if err != nil || role.TPPPassword != "legacy-password" || role.TPPUser != "admin" {
+		t.Fatalf("Expected migrated role with credentials, got %v %v", role, err)
+	}
+}
@@ -0,0 +1,105 @@
+package pki
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// certificateStats counts the stored certificates of a role or of the whole mount
+type certificateStats struct {
+	Total        int
+	Revoked      int
+	Expired      int
+	StorageBytes int
+}
+
+func (s *certificateStats) add(cert VenafiCert, expired bool, size int) {
+	s.Total++
+	if cert.RevocationTime != 0 {
+		s.Revoked++
+	}
+	if expired {
+		s.Expired++
+	}
+	s.StorageBytes += size
+}
+
+func (s *certificateStats) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"total":         s.Total,
+		"revoked":       s.Revoked,
+		"expired":       s.Expired,
+		"storage_bytes": s.StorageBytes,
+	}
+}
+
+func pathStats(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "stats",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathStatsRead,
+		},
+
+		HelpSynopsis:    pathStatsHelpSyn,
+		HelpDescription: pathStatsHelpDesc,
+	}
+}
+
+func (b *backend) pathStatsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	uids, err := listStoredCertificates(ctx, req.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+	var total certificateStats
+	roles := make(map[string]*certificateStats)
+	now := time.Now()
+	for _, uid := range uids {
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		expired := false
+		if parsed, err := parseCertificatePEM(cert.Certificate); err == nil {
+			expired = now.After(parsed.NotAfter)
+		}
+		//the size of the entry value, the key and the storage backend overhead are not counted
+		size := len(entry.Value)
+		total.add(cert, expired, size)
+		//the certificates stored by the older versions don't record the role
+		if cert.Role == "" {
+			continue
+		}
+		if roles[cert.Role] == nil {
+			roles[cert.Role] = &certificateStats{}
+		}
+		roles[cert.Role].add(cert, expired, size)
+	}
+
+	respData := total.toResponseData()
+	roleData := make(map[string]interface{})
+	for name, stats := range roles {
+		roleData[name] = stats.toResponseData()
+	}
+	respData["roles"] = roleData
+	return &logical.Response{Data: respData}, nil
+}
+
+const pathStatsHelpSyn = `
+Returns the statistics of the stored certificates.
+`
+
+const pathStatsHelpDesc = `
+Returns the numbers of the stored, revoked and expired certificates and the approximate size of their
+storage entries in bytes, in total and per role, for capacity planning. Certificates stored by the
+versions which didn't record the issuing role are counted only in the totals.
+`
@@ -0,0 +1,77 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestStats(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for role, count := range map[string]int{"stats-a": 2, "stats-b": 1} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		for i := 0; i < count; i++ {
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "issue/" + role,
+				Storage:   storage,
+				Data:      map[string]interface{}{"common_name": fmt.Sprintf("%d.%s.venafi.example.com", i, role)},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("bad: err: %v resp: %#v", err, resp)
+			}
+		}
+		if role != "stats-a" {
+			continue
+		}
+		//the fake connector doesn't support revocation
+		uid := normalizeSerial(resp.Data["serial_number"].(string))
+		entry, err := storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			t.Fatal(err)
+		}
+		cert.RevocationTime = time.Now().Unix()
+		if entry, err = logical.StorageEntryJSON("certs/"+uid, cert); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "stats",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if resp.Data["total"] != 3 || resp.Data["revoked"] != 1 || resp.Data["expired"] != 0 || resp.Data["storage_bytes"].(int) == 0 {
+		t.Fatalf("Unexpected stats %v", resp.Data)
+	}
+	roles := resp.Data["roles"].(map[string]interface{})
+	statsA := roles["stats-a"].(map[string]interface{})
+	statsB := roles["stats-b"].(map[string]interface{})
+	if statsA["total"] != 2 || statsA["revoked"] != 1 || statsB["total"] != 1 || statsB["revoked"] != 0 ||
+		statsA["storage_bytes"].(int)+statsB["storage_bytes"].(int) != resp.Data["storage_bytes"] {
+		t.Fatalf("Unexpected role stats %v", roles)
+	}
+}
@@ -0,0 +1,242 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// endpointURLs returns the Venafi endpoints the role connects to, fakemode roles don't connect anywhere
+func (r *roleEntry) endpointURLs() (string, []string) {
+	switch {
+	case r.Fakemode:
+		return "fake", nil
+	case r.TPPURL != "":
+		return "tpp", r.tppURLs()
+	case r.CloudURL != "":
+		return "cloud", []string{r.CloudURL}
+	default:
+		return "cloud", []string{defaultCloudURL}
+	}
+}
+
+// endpointAuthStatus tracks the authentication results per Venafi endpoint
+type endpointAuthStatus struct {
+	sync.Mutex
+	endpoints map[string]*endpointAuthState
+}
+
+type endpointAuthState struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+func (s *endpointAuthStatus) state(url string) *endpointAuthState {
+	if s.endpoints == nil {
+		s.endpoints = make(map[string]*endpointAuthState)
+	}
+	state, ok := s.endpoints[url]
+	if !ok {
+		state = &endpointAuthState{}
+		s.endpoints[url] = state
+	}
+	return state
+}
+
+func (s *endpointAuthStatus) reportSuccess(url string) {
+	s.Lock()
+	defer s.Unlock()
+	s.state(url).lastSuccess = time.Now()
+}
+
+func (s *endpointAuthStatus) reportFailure(url string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	state := s.state(url)
+	//the error can contain the response body of the Venafi API
+	state.lastFailure, state.lastError = time.Now(), scrub(err.Error())
+}
+
+func (s *endpointAuthStatus) get(url string) endpointAuthState {
+	s.Lock()
+	defer s.Unlock()
+	return *s.state(url)
+}
+
+func (h *tppEndpointHealth) unhealthyUntil(url string) time.Time {
+	h.Lock()
+	defer h.Unlock()
+	return h.state(url).unhealthyUntil
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func pathStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "status",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathStatusRead,
+		},
+
+		HelpSynopsis:    pathStatusHelpSyn,
+		HelpDescription: pathStatusHelpDesc,
+	}
+}
+
+func (b *backend) pathStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleNames, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(roleNames)
+
+	now := time.Now()
+	var degraded []string
+	endpoints := make(map[string]map[string]interface{})
+	caChains := make(map[string]interface{})
+	credentials := make(map[string]interface{})
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			continue
+		}
+
+		endpointType, urls := role.endpointURLs()
+		for _, url := range urls {
+			if endpoint, ok := endpoints[url]; ok {
+				endpoint["roles"] = append(endpoint["roles"].([]string), roleName)
+				continue
+			}
+			auth := b.authStatus.get(url)
+			endpoint := map[string]interface{}{
+				"type":                    endpointType,
+				"roles":                   []string{roleName},
+				"last_authenticated":      formatStatusTime(auth.lastSuccess),
+				"last_auth_failure":       formatStatusTime(auth.lastFailure),
+				"last_auth_error":         auth.lastError,
+				"quarantined_until":       "",
+				"authentication_degraded": auth.lastFailure.After(auth.lastSuccess),
+			}
+			if auth.lastFailure.After(auth.lastSuccess) {
+				degraded = append(degraded, fmt.Sprintf("authentication to %s failed at %s: %s", url,
+					formatStatusTime(auth.lastFailure), auth.lastError))
+			}
+			if endpointType == "tpp" {
+				if until := b.tppHealth.unhealthyUntil(url); until.After(now) {
+					endpoint["quarantined_until"] = formatStatusTime(until)
+					degraded = append(degraded, fmt.Sprintf("Venafi Platform %s is skipped until %s after a failure", url,
+						formatStatusTime(until)))
+				}
+			}
+			endpoints[url] = endpoint
+		}
+
+		if check, ok := b.credentialChecks.get(roleName); ok {
+			credentials[roleName] = map[string]interface{}{
+				"last_validated": formatStatusTime(check.checked),
+				"error":          check.err,
+			}
+			if check.err != "" {
+				degraded = append(degraded, fmt.Sprintf("credential validation of role %s failed at %s: %s", roleName,
+					formatStatusTime(check.checked), check.err))
+			}
+		}
+
+		info, err := b.getIssuerInfo(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil || len(info.CAChain) == 0 {
+			continue
+		}
+		expires := info.CAChainFetched.Add(role.caChainTTL())
+		caChains[roleName] = map[string]interface{}{
+			"fetched": formatStatusTime(info.CAChainFetched),
+			"expires": formatStatusTime(expires),
+			"stale":   now.After(expires),
+		}
+		if now.After(expires) {
+			degraded = append(degraded, fmt.Sprintf("CA chain of role %s expired at %s", roleName, formatStatusTime(expires)))
+		}
+	}
+
+	policyNames, err := req.Storage.List(ctx, venafiPolicyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]interface{})
+	for _, name := range policyNames {
+		policy, err := b.getVenafiPolicy(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			continue
+		}
+		policies[name] = map[string]interface{}{
+			"last_refreshed":   formatStatusTime(policy.LastRefreshed),
+			"refresh_failures": policy.RefreshFailures,
+			"stale":            policy.stale(),
+		}
+		if warning := policy.staleWarning(name); warning != "" {
+			degraded = append(degraded, warning)
+		}
+	}
+
+	entry, err := req.Storage.Get(ctx, autoRenewStatusKey)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		var status autoRenewStatus
+		if err := entry.DecodeJSON(&status); err != nil {
+			return nil, err
+		}
+		if len(status.Failed) > 0 {
+			degraded = append(degraded, fmt.Sprintf("%d certificates failed to renew at %s, see auto-renew/status",
+				len(status.Failed), formatStatusTime(status.LastRun)))
+		}
+	}
+
+	endpointData := make(map[string]interface{}, len(endpoints))
+	for url, endpoint := range endpoints {
+		endpointData[url] = endpoint
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":     pluginVersion,
+			"endpoints":   endpointData,
+			"ca_chains":   caChains,
+			"credentials": credentials,
+			"policies":    policies,
+			"degraded":    degraded,
+			"healthy":     len(degraded) == 0,
+		},
+	}, nil
+}
+
+const pathStatusHelpSyn = `
+Returns the status of the plugin and its Venafi endpoints.
+`
+
+const pathStatusHelpDesc = `
+Returns the plugin version, the Venafi endpoints configured in the roles with the time of the last successful
+and failed authentication, the freshness of the cached CA chains, the result of the last periodic credential
+validation of the roles, the freshness of the synced zone policies and the list of degraded conditions, e.g. failed authentication or credential validation,
+Platform nodes skipped after a failure, expired CA chains, stale policies or failed auto-renewals.
+healthy is false if any condition is degraded, so the path can be used by external monitoring checks.
+`
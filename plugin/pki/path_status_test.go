@@ -0,0 +1,88 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestStatus(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for role, data := range map[string]map[string]interface{}{
+		"status-fake": {"fakemode": true},
+		"status-tpp": {"tpp_url": "https://tpp1.venafi.example/vedsdk", "tpp_failover_urls": "https://tpp2.venafi.example/vedsdk",
+			"tpp_user": "admin", "tpp_password": "password", "zone": "devops"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/status-fake",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "status.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	readStatus := func() map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "status",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp.Data
+	}
+	status := readStatus()
+	if status["version"] != pluginVersion || status["healthy"] != true {
+		t.Fatalf("Unexpected status %v", status)
+	}
+	endpoints := status["endpoints"].(map[string]interface{})
+	if len(endpoints) != 2 {
+		t.Fatalf("Expected both Platform nodes to be reported, got %v", endpoints)
+	}
+	tpp1 := endpoints["https://tpp1.venafi.example/vedsdk"].(map[string]interface{})
+	if tpp1["type"] != "tpp" || !reflect.DeepEqual(tpp1["roles"], []string{"status-tpp"}) || tpp1["last_authenticated"] != "" {
+		t.Fatalf("Unexpected endpoint status %v", tpp1)
+	}
+	if chain, ok := status["ca_chains"].(map[string]interface{})["status-fake"]; !ok || chain.(map[string]interface{})["stale"] != false {
+		t.Fatalf("Expected the CA chain of the fakemode role to be fresh, got %v", status["ca_chains"])
+	}
+
+	b.authStatus.reportFailure("https://tpp1.venafi.example/vedsdk", fmt.Errorf(`Invalid status: 401 {"Password":"password"}`))
+	b.tppHealth.reportFailure("https://tpp2.venafi.example/vedsdk")
+	status = readStatus()
+	degraded := status["degraded"].([]string)
+	if status["healthy"] != false || len(degraded) != 2 {
+		t.Fatalf("Expected the failed authentication and the skipped node to be reported, got %v", status)
+	}
+	if strings.Contains(degraded[0]+degraded[1], `"password"`) {
+		t.Fatalf("Secret leaked to the status %v", degraded)
+	}
+
+	b.authStatus.reportSuccess("https://tpp1.venafi.example/vedsdk")
+	b.tppHealth.reportSuccess("https://tpp2.venafi.example/vedsdk", time.Second)
+	status = readStatus()
+	tpp1 = status["endpoints"].(map[string]interface{})["https://tpp1.venafi.example/vedsdk"].(map[string]interface{})
+	if status["healthy"] != true || tpp1["last_authenticated"] == "" || tpp1["last_auth_error"] == "" {
+		t.Fatalf("Expected the endpoint to recover, got %v", status)
+	}
+}
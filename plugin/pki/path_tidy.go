@@ -0,0 +1,313 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	defaultTidySafetyBuffer = 72 * time.Hour
+
+	tidyStateInactive = "Inactive"
+	tidyStateRunning  = "Running"
+	tidyStateFinished = "Finished"
+)
+
+// tidyStatus is the progress and the outcome of the last tidy run on this node
+type tidyStatus struct {
+	sync.Mutex
+	state            string
+	started          time.Time
+	finished         time.Time
+	tidyCertStore    bool
+	tidyRevokedCerts bool
+	safetyBuffer     time.Duration
+	storagePrefix    string
+	scanned          int
+	deleted          int
+	errors           []string
+}
+
+func (s *tidyStatus) addScanned() {
+	s.Lock()
+	s.scanned++
+	s.Unlock()
+}
+
+func (s *tidyStatus) addDeleted() {
+	s.Lock()
+	s.deleted++
+	s.Unlock()
+}
+
+func (s *tidyStatus) addError(format string, args ...interface{}) {
+	s.Lock()
+	s.errors = append(s.errors, fmt.Sprintf(format, args...))
+	s.Unlock()
+}
+
+func pathTidyStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy-status",
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathTidyStatusRead,
+		},
+
+		HelpSynopsis:    pathTidyStatusHelpSyn,
+		HelpDescription: pathTidyStatusHelpDesc,
+	}
+}
+
+func pathTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "tidy",
+		Fields: map[string]*framework.FieldSchema{
+			"tidy_cert_store": {
+				Type:        framework.TypeBool,
+				Description: "Delete the stored certificates which expired more than safety_buffer ago",
+			},
+			"tidy_revoked_certs": {
+				Type:        framework.TypeBool,
+				Description: "Delete the stored certificates which were revoked more than safety_buffer ago",
+			},
+			"storage_prefix": {
+				Type:        framework.TypeString,
+				Description: "Tidy only the certificates stored under certs/<storage_prefix>/ by the roles with this storage_prefix",
+			},
+			"safety_buffer": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Time after the expiration or revocation of the certificate before its entry is deleted",
+				Default:     int(defaultTidySafetyBuffer / time.Second),
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathTidyWrite,
+		},
+
+		HelpSynopsis:    pathTidyHelpSyn,
+		HelpDescription: pathTidyHelpDesc,
+	}
+}
+
+func (b *backend) pathTidyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+	tidyCertStore := data.Get("tidy_cert_store").(bool)
+	tidyRevokedCerts := data.Get("tidy_revoked_certs").(bool)
+	safetyBuffer := time.Duration(data.Get("safety_buffer").(int)) * time.Second
+	if !tidyCertStore && !tidyRevokedCerts {
+		return logical.ErrorResponse("tidy_cert_store or tidy_revoked_certs must be set"), nil
+	}
+	if safetyBuffer <= 0 {
+		return logical.ErrorResponse("safety_buffer must be positive"), nil
+	}
+	storagePrefix := data.Get("storage_prefix").(string)
+	if storagePrefix != "" && !storagePrefixRegex.MatchString(storagePrefix) {
+		return logical.ErrorResponse(fmt.Sprintf(errorTextInvalidStoragePrefix, storagePrefix)), nil
+	}
+
+	if !b.startTidy(req.Storage, tidyCertStore, tidyRevokedCerts, safetyBuffer, storagePrefix) {
+		resp := &logical.Response{}
+		resp.AddWarning("Tidy operation already in progress.")
+		return resp, nil
+	}
+
+	resp := &logical.Response{}
+	resp.AddWarning("Tidy operation successfully started. Any information from the operation will be printed to Vault's server logs.")
+	return logical.RespondWithStatusCode(resp, req, http.StatusAccepted)
+}
+
+// startTidy runs the tidy in the background unless it is already running
+func (b *backend) startTidy(s logical.Storage, tidyCertStore bool, tidyRevokedCerts bool, safetyBuffer time.Duration,
+	storagePrefix string) bool {
+	if !atomic.CompareAndSwapInt32(&b.tidyRunning, 0, 1) {
+		return false
+	}
+	b.tidyStatus.Lock()
+	b.tidyStatus.state = tidyStateRunning
+	b.tidyStatus.started = time.Now()
+	b.tidyStatus.finished = time.Time{}
+	b.tidyStatus.tidyCertStore = tidyCertStore
+	b.tidyStatus.tidyRevokedCerts = tidyRevokedCerts
+	b.tidyStatus.safetyBuffer = safetyBuffer
+	b.tidyStatus.storagePrefix = storagePrefix
+	b.tidyStatus.scanned = 0
+	b.tidyStatus.deleted = 0
+	b.tidyStatus.errors = nil
+	b.tidyStatus.Unlock()
+
+	//the stores with many certificates can't be tidied within the request timeout
+	go func() {
+		defer atomic.StoreInt32(&b.tidyRunning, 0)
+		b.tidyCertificates(context.Background(), s, &b.tidyStatus)
+
+		status := &b.tidyStatus
+		status.Lock()
+		defer status.Unlock()
+		status.state = tidyStateFinished
+		status.finished = time.Now()
+		if len(status.errors) > 0 {
+			b.Logger().Error("Tidy finished with errors", "error_count", len(status.errors), "scanned", status.scanned,
+				"deleted", status.deleted, "errors", strings.Join(status.errors, "; "))
+		} else {
+			b.Logger().Info("Tidy finished", "scanned", status.scanned, "deleted", status.deleted)
+		}
+	}()
+	return true
+}
+
+func (b *backend) pathTidyStatusRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	status := &b.tidyStatus
+	status.Lock()
+	defer status.Unlock()
+
+	if status.state == "" {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"state": tidyStateInactive,
+			},
+		}, nil
+	}
+	respData := map[string]interface{}{
+		"state":              status.state,
+		"time_started":       status.started.UTC().Format(time.RFC3339),
+		"time_finished":      nil,
+		"tidy_cert_store":    status.tidyCertStore,
+		"tidy_revoked_certs": status.tidyRevokedCerts,
+		"safety_buffer":      int64(status.safetyBuffer.Seconds()),
+		"storage_prefix":     status.storagePrefix,
+		"certs_scanned":      status.scanned,
+		"certs_deleted":      status.deleted,
+		"error_count":        len(status.errors),
+		"last_error":         nil,
+	}
+	finished := time.Now()
+	if !status.finished.IsZero() {
+		finished = status.finished
+		respData["time_finished"] = status.finished.UTC().Format(time.RFC3339)
+	}
+	respData["duration"] = int64(finished.Sub(status.started).Seconds())
+	if len(status.errors) > 0 {
+		respData["last_error"] = status.errors[len(status.errors)-1]
+	}
+	return &logical.Response{Data: respData}, nil
+}
+
+// tidyCertificates deletes the expired and revoked certificates from the storage together with their private keys
+// and the reissue index entries pointing to them
+func (b *backend) tidyCertificates(ctx context.Context, s logical.Storage, status *tidyStatus) {
+	status.Lock()
+	tidyCertStore, tidyRevokedCerts, safetyBuffer := status.tidyCertStore, status.tidyRevokedCerts, status.safetyBuffer
+	prefix := status.storagePrefix
+	status.Unlock()
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	paths, err := listStoredCertificates(ctx, s, prefix)
+	if err != nil {
+		status.addError("failed to list certificates: %s", err)
+		return
+	}
+	deleted := 0
+	for _, path := range paths {
+		status.addScanned()
+		entry, err := s.Get(ctx, "certs/"+path)
+		if err != nil {
+			status.addError("failed to read certificate %s: %s", path, err)
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			status.addError("failed to decode certificate %s: %s", path, err)
+			continue
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			status.addError("failed to parse certificate %s: %s", path, err)
+			continue
+		}
+
+		expired := tidyCertStore && time.Since(parsed.NotAfter) > safetyBuffer
+		revoked := tidyRevokedCerts && cert.RevocationTime != 0 && time.Since(time.Unix(cert.RevocationTime, 0)) > safetyBuffer
+		if !expired && !revoked {
+			continue
+		}
+		b.Logger().Debug("Deleting certificate", "path", path)
+		if err := s.Delete(ctx, "certs/"+path); err != nil {
+			status.addError("failed to delete certificate %s: %s", path, err)
+			continue
+		}
+		if cert.SerialNumber != "" {
+			if err := s.Delete(ctx, "keys/"+normalizeSerial(cert.SerialNumber)); err != nil {
+				status.addError("failed to delete private key of certificate %s: %s", path, err)
+			}
+		}
+		if err := unindexCertificate(ctx, s, "certs/"+path, parsed, cert.Role); err != nil {
+			status.addError("failed to delete search index entries of certificate %s: %s", path, err)
+		}
+		status.addDeleted()
+		deleted++
+	}
+	if deleted > 0 {
+		b.tidyReissueIndex(ctx, s, status)
+	}
+}
+
+// tidyReissueIndex deletes the reissue index entries of the deleted certificates
+func (b *backend) tidyReissueIndex(ctx context.Context, s logical.Storage, status *tidyStatus) {
+	keys, err := s.List(ctx, reissuePrefix)
+	if err != nil {
+		status.addError("failed to list reissue index: %s", err)
+		return
+	}
+	for _, key := range keys {
+		entry, err := s.Get(ctx, reissuePrefix+key)
+		if err != nil || entry == nil {
+			continue
+		}
+		var index reissueEntry
+		if err := entry.DecodeJSON(&index); err != nil {
+			continue
+		}
+		cert, err := s.Get(ctx, index.StoragePath)
+		if err != nil || cert != nil {
+			continue
+		}
+		if err := s.Delete(ctx, reissuePrefix+key); err != nil {
+			status.addError("failed to delete reissue index entry %s: %s", key, err)
+		}
+	}
+}
+
+const pathTidyHelpSyn = `
+Tidy up the stored certificates.
+`
+
+const pathTidyHelpDesc = `
+This endpoint deletes the stored certificates which expired or were revoked more than
+safety_buffer ago. Their private keys stored with separate_private_key are deleted too.
+The tidy runs in the background, its progress is returned by the tidy-status path.
+`
+
+const pathTidyStatusHelpSyn = `
+Returns the status of the tidy operation.
+`
+
+const pathTidyStatusHelpDesc = `
+This endpoint returns the state of the last tidy run on this node, the numbers of the
+scanned and deleted certificates, the errors and the duration of the run.
+`
@@ -0,0 +1,183 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTidy(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeCertificate := func(path string, notAfter time.Time, revocationTime int64) {
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      pkix.Name{CommonName: path + ".venafi.example.com"},
+			NotBefore:    notAfter.Add(-24 * time.Hour),
+			NotAfter:     notAfter,
+		}, &x509.Certificate{}, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry, err := logical.StorageEntryJSON("certs/"+path, VenafiCert{
+			Certificate:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			SerialNumber:   path,
+			RevocationTime: revocationTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		entry, err = logical.StorageEntryJSON("keys/"+path, privateKeyEntry{SerialNumber: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+		entry, err = logical.StorageEntryJSON(reissuePrefix+path, reissueEntry{StoragePath: "certs/" + path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storeCertificate("valid", time.Now().Add(24*time.Hour), 0)
+	storeCertificate("expired", time.Now().Add(-time.Hour), 0)
+	storeCertificate("expired-in-buffer", time.Now().Add(-time.Minute), 0)
+	storeCertificate("revoked", time.Now().Add(24*time.Hour), time.Now().Add(-time.Hour).Unix())
+
+	tidy := func(data map[string]interface{}) {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tidy",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		for i := 0; atomic.LoadInt32(&b.tidyRunning) != 0; i++ {
+			if i > 100 {
+				t.Fatal("Tidy is not finished")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	assertStored := func(expected ...string) {
+		for _, prefix := range []string{"certs/", "keys/", reissuePrefix} {
+			stored, err := storage.List(ctx, prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(stored)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(stored, expected) {
+				t.Fatalf("Expecting %v stored under %s, got %v", expected, prefix, stored)
+			}
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error without tidy flags, got err: %v resp: %#v", err, resp)
+	}
+
+	tidy(map[string]interface{}{"tidy_cert_store": true, "safety_buffer": "30m"})
+	assertStored("valid", "expired-in-buffer", "revoked")
+
+	tidy(map[string]interface{}{"tidy_revoked_certs": true, "safety_buffer": "30m"})
+	assertStored("valid", "expired-in-buffer")
+}
+
+func TestTidyStatus(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	readStatus := func() map[string]interface{} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "tidy-status",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp.Data
+	}
+	if state := readStatus()["state"]; state != tidyStateInactive {
+		t.Fatalf("Expecting state %s before the first tidy, got %v", tidyStateInactive, state)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/tidy-status",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	for _, cn := range []string{"tidy-1.venafi.example.com", "tidy-2.venafi.example.com"} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/tidy-status",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": cn},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	//the entry which is not a certificate is reported as an error
+	if err := storage.Put(ctx, &logical.StorageEntry{Key: "certs/invalid", Value: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+		Data:      map[string]interface{}{"tidy_cert_store": true},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	var status map[string]interface{}
+	for i := 0; ; i++ {
+		if status = readStatus(); status["state"] == tidyStateFinished {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("Tidy is not finished, status %v", status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if status["certs_scanned"] != 3 || status["certs_deleted"] != 0 || status["error_count"] != 1 ||
+		status["last_error"] == nil || status["time_finished"] == nil || status["tidy_cert_store"] != true {
+		t.Fatalf("Unexpected tidy status %v", status)
+	}
+}
@@ -0,0 +1,276 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathVenafiCertCA(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cert/ca/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The role which issuing CA certificate should be returned`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCertCARead,
+		},
+
+		HelpSynopsis:    pathVenafiCAHelpSyn,
+		HelpDescription: pathVenafiCAHelpDesc,
+	}
+}
+
+func pathVenafiCA(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "ca/" + framework.GenericNameRegex("role") + framework.OptionalParamRegex("format"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The role which issuing CA certificate should be returned`,
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Description: `Set to "pem" to get the PEM encoded certificate, DER is returned otherwise`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCARead,
+		},
+
+		HelpSynopsis:    pathVenafiCAHelpSyn,
+		HelpDescription: pathVenafiCAHelpDesc,
+	}
+}
+
+func pathVenafiCertCAChain(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cert/ca_chain/" + framework.GenericNameRegex("role"),
+		Fields:  caChainFields,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCertCAChainRead,
+		},
+
+		HelpSynopsis:    pathVenafiCAChainHelpSyn,
+		HelpDescription: pathVenafiCAChainHelpDesc,
+	}
+}
+
+func pathVenafiCAChain(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "ca_chain/" + framework.GenericNameRegex("role"),
+		Fields:  caChainFields,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCAChainRead,
+		},
+
+		HelpSynopsis:    pathVenafiCAChainHelpSyn,
+		HelpDescription: pathVenafiCAChainHelpDesc,
+	}
+}
+
+var caChainFields = map[string]*framework.FieldSchema{
+	"role": {
+		Type:        framework.TypeString,
+		Description: `The role which CA chain should be returned`,
+	},
+	"exclude_root": {
+		Type:        framework.TypeBool,
+		Description: `If set, the self-signed root certificate is not included in the chain`,
+	},
+}
+
+// getIssuingCA returns PEM of the role issuing CA certificate, only the stored one if cachedOnly is set
+func (b *backend) getIssuingCA(ctx context.Context, req *logical.Request, roleName string, cachedOnly bool) (string, error) {
+	var chain []string
+	var err error
+	if cachedOnly {
+		chain, err = b.getCachedCAChain(ctx, req.Storage, roleName)
+	} else {
+		chain, err = b.getCAChain(ctx, req, roleName)
+	}
+	if err != nil {
+		return "", err
+	}
+	return chain[0], nil
+}
+
+func (b *backend) pathVenafiCertCARead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ca, err := b.getIssuingCA(ctx, req, data.Get("role").(string), false)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate": ca,
+		},
+	}, nil
+}
+
+func (b *backend) pathVenafiCARead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	format := data.Get("format").(string)
+	if format != "" && format != "pem" {
+		return logical.ErrorResponse(fmt.Sprintf("unknown certificate format %s", format)), nil
+	}
+	//the path is unauthenticated, so it doesn't make requests to Venafi
+	ca, err := b.getIssuingCA(ctx, req, data.Get("role").(string), true)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	contentType := "application/x-pem-file"
+	body := []byte(ca)
+	if format == "" {
+		pemBlock, _ := pem.Decode(body)
+		if pemBlock == nil {
+			return nil, fmt.Errorf("stored CA certificate is not PEM encoded")
+		}
+		contentType = "application/pkix-cert"
+		body = pemBlock.Bytes
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     body,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// getRoleCAChain returns PEM of the role CA chain ordered according to the role chain_option, only the stored one
+// if cachedOnly is set
+func (b *backend) getRoleCAChain(ctx context.Context, req *logical.Request, roleName string, excludeRoot bool, cachedOnly bool) (string, error) {
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return "", err
+	}
+	if role == nil {
+		return "", fmt.Errorf("unknown role: %s", roleName)
+	}
+	var chain []string
+	if cachedOnly {
+		chain, err = b.getCachedCAChain(ctx, req.Storage, roleName)
+	} else {
+		chain, err = b.getCAChain(ctx, req, roleName)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var certs []string
+	for _, c := range chain {
+		if excludeRoot {
+			parsed, err := parseCertificatePEM(c)
+			if err != nil {
+				return "", err
+			}
+			if isSelfSigned(parsed) {
+				continue
+			}
+		}
+		certs = append(certs, c)
+	}
+	//chain is stored from the issuing CA, chain_option "first" reverses it to start from the root
+	return strings.Join(issuerFirstChain(certs, role.ChainOption), "\n"), nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func (b *backend) pathVenafiCertCAChainRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain, err := b.getRoleCAChain(ctx, req, data.Get("role").(string), data.Get("exclude_root").(bool), false)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"ca_chain": chain,
+		},
+	}, nil
+}
+
+func (b *backend) pathVenafiCAChainRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	//the path is unauthenticated, so it doesn't make requests to Venafi
+	chain, err := b.getRoleCAChain(ctx, req, data.Get("role").(string), data.Get("exclude_root").(bool), true)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/x-pem-file",
+			logical.HTTPRawBody:     []byte(chain),
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+const (
+	pathVenafiCAChainHelpSyn = `
+Fetch the role CA chain
+`
+	pathVenafiCAChainHelpDesc = `
+This path returns the PEM CA chain of the role, from the issuing CA to the root
+or in reverse order if chain_option of the role is "first". Set exclude_root to
+return only the intermediate CAs. "cert/ca_chain/<role>" returns it in the JSON
+response and "ca_chain/<role>" returns it as is without authentication. The
+unauthenticated path returns only the stored chain, it doesn't retrieve the chain
+from Venafi.
+`
+	pathVenafiCAHelpSyn = `
+Fetch the role issuing CA certificate
+`
+	pathVenafiCAHelpDesc = `
+This path returns the certificate of the CA which issues certificates of the role.
+"cert/ca/<role>" returns it in the JSON response, "ca/<role>" returns DER and
+"ca/<role>/pem" returns PEM without authentication. The CA is taken from the chain
+of the last certificate issued for the role. If nothing was issued yet, the chain
+of a certificate from the role zone is retrieved from Venafi and stored by
+"cert/ca/<role>" or by the periodic function. The unauthenticated paths return
+only the stored chain. A failed retrieval is retried after 5 minutes.
+`
+)
+
+func pathRoleInvalidateCAChain(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex("name") + "/invalidate-ca-chain",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRoleInvalidateCAChain,
+		},
+
+		HelpSynopsis:    pathRoleInvalidateCAChainHelpSyn,
+		HelpDescription: pathRoleInvalidateCAChainHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleInvalidateCAChain(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+	return nil, b.invalidateCAChain(ctx, req.Storage, data.Get("name").(string))
+}
+
+const (
+	pathRoleInvalidateCAChainHelpSyn  = `Invalidate the stored CA chain of the role.`
+	pathRoleInvalidateCAChainHelpDesc = `
+This path removes the CA chain stored for the role, so it is retrieved from Venafi
+again on the next read of the CA paths, e.g. after the CA was renewed.
+`
+)
@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/helper/strutil"
 	"net"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -37,13 +42,105 @@ func pathVenafiCertEnroll(b *backend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: "The requested IP SANs, if any, in a comma-delimited list",
 			},
+			"email_sans": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The requested email SANs, if any, in a comma-delimited list",
+			},
+			"uri_sans": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "The requested URI SANs, if any, in a comma-delimited list. They must match allowed_uri_sans of the role",
+			},
+			"other_sans": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `The requested other SANs in oid;UTF8:value format, e.g. UPN "1.3.6.1.4.1.311.20.2.3;UTF8:user@example.com".
+They must match allowed_other_sans of the role`,
+			},
+			"key_usage": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Key usages requested in the CSR, e.g. "DigitalSignature,KeyEncipherment". Defaults to key_usage
+of the role and must be allowed by it if the role sets it. A warning is returned if the CA doesn't include them`,
+			},
+			"ext_key_usage": {
+				Type: framework.TypeCommaStringSlice,
+				Description: `Extended key usages requested in the CSR: ServerAuth, ClientAuth, CodeSigning, EmailProtection,
+TimeStamping or OCSPSigning. Defaults to ext_key_usage of the role and must be allowed by it if the role sets it.
+A warning is returned if the CA doesn't include them`,
+			},
+			"organization": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Organization (O) of the certificate subject instead of the role default",
+			},
+			"organizational_unit": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Organizational unit (OU) of the certificate subject instead of the role default",
+			},
+			"country": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Country (C) of the certificate subject instead of the role default",
+			},
+			"locality": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Locality (L) of the certificate subject instead of the role default",
+			},
+			"province": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Province (ST) of the certificate subject instead of the role default",
+			},
+			"friendly_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the certificate object in the Venafi Platform policy folder. Defaults to the common name",
+			},
+			"custom_fields": {
+				Type: framework.TypeKVPairs,
+				Description: `Venafi Platform custom fields of the certificate object as name=value pairs or a map,
+e.g. custom_fields="Cost Center=1234,Ticket=CHG-42"`,
+			},
+			"ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `Requested validity of the certificate, defaults to ttl of the role and can't exceed max_ttl of the role.
+It is sent to Venafi Platform as the expiration date and to Venafi Cloud as the validity period, a warning is returned
+if the issued certificate expires earlier, e.g. when the zone or the CA limits the validity`,
+			},
+			"not_after": {
+				Type: framework.TypeString,
+				Description: `Requested expiration time of the certificate in RFC3339 format, e.g. "2030-01-01T00:00:00Z".
+It can't be used with ttl. It is sent to Venafi like ttl, a warning is returned if the issued certificate expires at
+a different time`,
+			},
+			"format": {
+				Type:    framework.TypeString,
+				Default: "pem",
+				Description: `Format of the returned certificate, "pem" or "pem_bundle". With "pem_bundle" the private key,
+the certificate and the chain ordered per chain_option of the role are also returned concatenated in pem_bundle`,
+			},
+			"issuing_template": {
+				Type:        framework.TypeString,
+				Description: "Venafi Cloud issuing template to request the certificate with. It must be one of cloud_issuing_templates of the role",
+			},
+			"exclude_cn_from_sans": {
+				Type:    framework.TypeBool,
+				Default: false,
+				Description: `If true, the common name will not be included in DNS or email SANs, as is done by default.
+The common name is still in the SANs if it is requested in alt_names too.`,
+			},
 			"key_password": {
+				Type: framework.TypeString,
+				Description: `Password for encrypting private key. The key is returned as PKCS#8 encrypted with
+PBES2 using PBKDF2 with HMAC-SHA256 and AES-256-CBC`,
+			},
+			"private_key_format": {
+				Type:    framework.TypeString,
+				Default: "",
+				Description: `Encoding of the returned private key. Defaults to PKCS#1 for RSA and SEC1 for EC keys,
+"pkcs8" returns the key as PKCS#8. The key encrypted with key_password is always PKCS#8`,
+			},
+			"zone": {
 				Type:        framework.TypeString,
-				Description: "Password for encrypting private key",
+				Description: "Venafi zone to request the certificate from instead of the role zone. Requires allow_zone_override in the role",
 			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathVenafiIssue,
+			logical.UpdateOperation: instrumented("issue", b.pathVenafiIssue),
 		},
 
 		HelpSynopsis:    pathVenafiCertEnrollHelp,
@@ -63,9 +160,40 @@ func pathVenafiCertSign(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `The desired role with configuration for this request`,
 			},
+			"zone": {
+				Type:        framework.TypeString,
+				Description: "Venafi zone to request the certificate from instead of the role zone. Requires allow_zone_override in the role",
+			},
+			"friendly_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the certificate object in the Venafi Platform policy folder. Defaults to the common name",
+			},
+			"custom_fields": {
+				Type: framework.TypeKVPairs,
+				Description: `Venafi Platform custom fields of the certificate object as name=value pairs or a map,
+e.g. custom_fields="Cost Center=1234,Ticket=CHG-42"`,
+			},
+			"ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `Requested validity of the certificate, defaults to ttl of the role and can't exceed max_ttl of the role.
+It is sent to Venafi Platform as the expiration date and to Venafi Cloud as the validity period, a warning is returned
+if the issued certificate expires earlier, e.g. when the zone or the CA limits the validity`,
+			},
+			"not_after": {
+				Type: framework.TypeString,
+				Description: `Requested expiration time of the certificate in RFC3339 format, e.g. "2030-01-01T00:00:00Z".
+It can't be used with ttl. It is sent to Venafi like ttl, a warning is returned if the issued certificate expires at
+a different time`,
+			},
+			"format": {
+				Type:    framework.TypeString,
+				Default: "pem",
+				Description: `Format of the returned certificate, "pem" or "pem_bundle". With "pem_bundle" the private key,
+the certificate and the chain ordered per chain_option of the role are also returned concatenated in pem_bundle`,
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.pathVenafiSign,
+			logical.UpdateOperation: instrumented("sign", b.pathVenafiSign),
 		},
 
 		HelpSynopsis:    pathVenafiCertSignHelp,
@@ -73,6 +201,59 @@ func pathVenafiCertSign(b *backend) *framework.Path {
 	}
 }
 
+func pathVenafiCertSignVerbatim(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "sign-verbatim/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"csr": {
+				Type:        framework.TypeString,
+				Description: `PEM-format CSR to be signed. Its subject and SANs are requested exactly as provided.`,
+			},
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The desired role with configuration for this request`,
+			},
+			"zone": {
+				Type:        framework.TypeString,
+				Description: "Venafi zone to request the certificate from instead of the role zone. Requires allow_zone_override in the role",
+			},
+			"friendly_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the certificate object in the Venafi Platform policy folder. Defaults to the common name",
+			},
+			"custom_fields": {
+				Type: framework.TypeKVPairs,
+				Description: `Venafi Platform custom fields of the certificate object as name=value pairs or a map,
+e.g. custom_fields="Cost Center=1234,Ticket=CHG-42"`,
+			},
+			"ttl": {
+				Type: framework.TypeDurationSecond,
+				Description: `Requested validity of the certificate, defaults to ttl of the role and can't exceed max_ttl of the role.
+It is sent to Venafi Platform as the expiration date and to Venafi Cloud as the validity period, a warning is returned
+if the issued certificate expires earlier, e.g. when the zone or the CA limits the validity`,
+			},
+			"not_after": {
+				Type: framework.TypeString,
+				Description: `Requested expiration time of the certificate in RFC3339 format, e.g. "2030-01-01T00:00:00Z".
+It can't be used with ttl. It is sent to Venafi like ttl, a warning is returned if the issued certificate expires at
+a different time`,
+			},
+			"format": {
+				Type:    framework.TypeString,
+				Default: "pem",
+				Description: `Format of the returned certificate, "pem" or "pem_bundle". With "pem_bundle" the private key,
+the certificate and the chain ordered per chain_option of the role are also returned concatenated in pem_bundle`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: instrumented("sign-verbatim", b.pathVenafiSignVerbatim),
+		},
+
+		HelpSynopsis:    pathVenafiCertSignVerbatimHelp,
+		HelpDescription: pathVenafiCertSignVerbatimDesc,
+	}
+}
+
 func (b *backend) pathVenafiIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
 
@@ -89,7 +270,7 @@ func (b *backend) pathVenafiIssue(ctx context.Context, req *logical.Request, dat
 		return logical.ErrorResponse("role key type \"any\" not allowed for issuing certificates, only signing"), nil
 	}
 
-	return b.pathVenafiCertObtain(ctx, req, data, role, false)
+	return b.pathVenafiCertObtain(ctx, req, data, role, false, false)
 }
 
 // pathSign issues a certificate from a submitted CSR, subject to role
@@ -106,10 +287,26 @@ func (b *backend) pathVenafiSign(ctx context.Context, req *logical.Request, data
 		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
 	}
 
-	return b.pathVenafiCertObtain(ctx, req, data, role, true)
+	return b.pathVenafiCertObtain(ctx, req, data, role, true, false)
+}
+
+// pathVenafiSignVerbatim issues a certificate from a submitted CSR without
+// applying the role subject and SAN rules, only the zone policy applies
+func (b *backend) pathVenafiSignVerbatim(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	return b.pathVenafiCertObtain(ctx, req, data, role, true, true)
 }
 
-func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request, data *framework.FieldData, role *roleEntry, signCSR bool) (
+func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request, data *framework.FieldData, role *roleEntry, signCSR, verbatim bool) (
 	*logical.Response, error) {
 
 	// When utilizing performance standbys in Vault Enterprise, this forces the call to be redirected to the primary since
@@ -119,17 +316,12 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 		return nil, logical.ErrReadOnly
 	}
 
-	b.Logger().Debug("Getting the role\n")
+	b.Logger().Debug("Getting the role")
 	roleName := data.Get("role").(string)
 
-	b.Logger().Debug("Creating Venafi client:")
-	cl, timeout, err := b.ClientVenafi(ctx, req.Storage, data, req, roleName)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
-	}
-
-	var certReq *certificate.Request
 	var reqData requestData
+	reqData.verbatim = verbatim
+	reqData.origin = role.originTag(req.MountPoint)
 
 	if data == nil {
 		return logical.ErrorResponse("data can't be nil"), nil
@@ -149,44 +341,328 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 	if ok {
 		reqData.ipSANs = ipSANsRaw.([]string)
 	}
+	for _, ip := range reqData.ipSANs {
+		if net.ParseIP(ip) == nil {
+			return logical.ErrorResponse(fmt.Sprintf("ip_sans value %q is not a valid IP address", ip)), nil
+		}
+	}
+	if !role.AllowIPSANs {
+		//the IP address common name is added to the IP SANs of the certificate
+		requested := len(reqData.ipSANs) > 0 || net.ParseIP(reqData.commonName) != nil
+		for _, name := range reqData.altNames {
+			requested = requested || net.ParseIP(name) != nil
+		}
+		if requested {
+			return logical.ErrorResponse(errorTextIPSANsNotAllowed), nil
+		}
+	}
+
+	allowedDomains := b.allowedDomains(req, role)
+	if err := validateRequestedNames(role, allowedDomains, requestedDomainNames(reqData.commonName, reqData.altNames)); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	emailSANsRaw, ok := data.GetOk("email_sans")
+	if ok {
+		reqData.emailSANs = emailSANsRaw.([]string)
+	}
+	for _, email := range reqData.emailSANs {
+		if !isEmailAddress(email) {
+			return logical.ErrorResponse(fmt.Sprintf("email_sans value %q is not a valid email address", email)), nil
+		}
+	}
+	if !role.AllowEmailSANs {
+		requested := len(reqData.emailSANs) > 0
+		for _, name := range reqData.altNames {
+			requested = requested || strings.Contains(name, "@")
+		}
+		if requested {
+			return logical.ErrorResponse(errorTextEmailSANsNotAllowed), nil
+		}
+	}
+
+	uriSANsRaw, ok := data.GetOk("uri_sans")
+	if ok {
+		reqData.uriSANs = uriSANsRaw.([]string)
+	}
+	for _, uri := range reqData.uriSANs {
+		if _, err := url.Parse(uri); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("uri_sans value %q is not a valid URI: %s", uri, err)), nil
+		}
+		if !strutil.StrListContainsGlob(role.AllowedURISANs, uri) {
+			return logical.ErrorResponse(fmt.Sprintf(errorTextURISANNotAllowed, uri)), nil
+		}
+	}
+
+	otherSANsRaw, ok := data.GetOk("other_sans")
+	if ok {
+		otherSANs, err := parseOtherSANs(otherSANsRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		for _, o := range otherSANs {
+			if !strutil.StrListContainsGlob(role.AllowedOtherSANs, o.String()) {
+				return logical.ErrorResponse(fmt.Sprintf(errorTextOtherSANNotAllowed, o)), nil
+			}
+		}
+		reqData.otherSANs = otherSANs
+	}
+
+	if !signCSR {
+		var keyUsage, extKeyUsage []string
+		if keyUsageRaw, ok := data.GetOk("key_usage"); ok {
+			keyUsage = keyUsageRaw.([]string)
+		}
+		if extKeyUsageRaw, ok := data.GetOk("ext_key_usage"); ok {
+			extKeyUsage = extKeyUsageRaw.([]string)
+		}
+		var err error
+		if reqData.keyUsage, err = requestKeyUsage(role.KeyUsage, keyUsage); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if reqData.extKeyUsage, err = requestExtKeyUsage(role.ExtKeyUsage, extKeyUsage); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if role.ClientAuthOnly {
+			if reqData.extKeyUsage, err = clientAuthExtKeyUsage(reqData.extKeyUsage); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+
+	//subject fields of the request override the role defaults
+	reqData.subject = pkix.Name{
+		Organization:       role.Organization,
+		OrganizationalUnit: role.OrganizationalUnit,
+		Country:            role.Country,
+		Locality:           role.Locality,
+		Province:           role.Province,
+	}
+	for field, value := range map[string]*[]string{
+		"organization":        &reqData.subject.Organization,
+		"organizational_unit": &reqData.subject.OrganizationalUnit,
+		"country":             &reqData.subject.Country,
+		"locality":            &reqData.subject.Locality,
+		"province":            &reqData.subject.Province,
+	} {
+		if raw, ok := data.GetOk(field); ok && len(raw.([]string)) > 0 {
+			*value = raw.([]string)
+		}
+	}
+
+	friendlyNameRaw, ok := data.GetOk("friendly_name")
+	if ok {
+		reqData.friendlyName = friendlyNameRaw.(string)
+	}
+
+	customFieldsRaw, ok := data.GetOk("custom_fields")
+	if ok {
+		reqData.customFields = customFieldsRaw.(map[string]string)
+	}
+
+	//the requested validity is sent to Venafi, the zone and the CA can still issue a shorter one
+	reqData.ttl = role.TTL
+	ttlRaw, ttlOk := data.GetOk("ttl")
+	if ttlOk {
+		reqData.ttl = time.Duration(ttlRaw.(int)) * time.Second
+		reqData.ttlRequested = true
+	}
+	if notAfterRaw, ok := data.GetOk("not_after"); ok && notAfterRaw.(string) != "" {
+		if ttlOk {
+			return logical.ErrorResponse("ttl and not_after can't be specified together"), nil
+		}
+		notAfter, err := time.Parse(time.RFC3339, notAfterRaw.(string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("not_after must be in RFC3339 format: %s", err)), nil
+		}
+		if !notAfter.After(time.Now()) {
+			return logical.ErrorResponse("not_after must be in the future"), nil
+		}
+		reqData.notAfter = notAfter
+		reqData.ttl = time.Until(notAfter)
+	}
+	if role.MaxTTL > 0 && reqData.ttl > role.MaxTTL {
+		return logical.ErrorResponse(fmt.Sprintf("ttl %s is larger than max_ttl %s of the role", reqData.ttl, role.MaxTTL)), nil
+	}
+
+	if !signCSR {
+		if keyFormat := data.Get("private_key_format").(string); keyFormat != "" && keyFormat != privateKeyFormatPKCS8 {
+			return logical.ErrorResponse(fmt.Sprintf("unsupported private_key_format %s", keyFormat)), nil
+		}
+	}
+
+	format := data.Get("format").(string)
+	if format != "pem" && format != "pem_bundle" {
+		return logical.ErrorResponse(fmt.Sprintf("unsupported format %s, it must be pem or pem_bundle", format)), nil
+	}
+
+	excludeCNRaw, ok := data.GetOk("exclude_cn_from_sans")
+	if ok {
+		reqData.excludeCNFromSANs = excludeCNRaw.(bool)
+	}
 
 	keyPasswordRaw, ok := data.GetOk("key_password")
 	if ok {
 		reqData.keyPassword = keyPasswordRaw.(string)
 	}
 
+	config, err := b.getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config.FIPSMode && !signCSR {
+		if err := validateFIPSRole(role); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
 	csrStringRaw, ok := data.GetOk("csr")
 	if ok {
 		reqData.csrString = csrStringRaw.(string)
 	}
 
-	certReq, err = formRequest(reqData, role, signCSR, b.Logger())
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	//invalid CSRs are rejected before connecting to Venafi
+	var csr *x509.CertificateRequest
+	if signCSR && reqData.csrString != "" {
+		csr, err = parseCSRPEM(reqData.csrString)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("can't parse provided CSR %v", err)), nil
+		}
+		if err := validateCSR(csr, role, verbatim); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if config.FIPSMode {
+			if err := validateFIPSCSR(csr); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+		reqData.commonName = csr.Subject.CommonName
+		//verbatim CSRs keep their names, but the denied, wildcard and hostname rules and the SAN types still apply
+		validateNames := validateNameConstraints
+		if !verbatim {
+			validateNames = func(role *roleEntry, names []string) error {
+				return validateRequestedNames(role, allowedDomains, names)
+			}
+		}
+		if err := validateNames(role, requestedDomainNames(csr.Subject.CommonName, csr.DNSNames)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if !role.AllowIPSANs && (len(csr.IPAddresses) > 0 || net.ParseIP(csr.Subject.CommonName) != nil) {
+			return logical.ErrorResponse(errorTextIPSANsNotAllowed), nil
+		}
+		if !role.AllowEmailSANs && len(csr.EmailAddresses) > 0 {
+			return logical.ErrorResponse(errorTextEmailSANsNotAllowed), nil
+		}
+		for _, uri := range csr.URIs {
+			if !strutil.StrListContainsGlob(role.AllowedURISANs, uri.String()) {
+				return logical.ErrorResponse(fmt.Sprintf(errorTextURISANNotAllowed, uri)), nil
+			}
+		}
 	}
 
-	b.Logger().Debug("Making certificate request")
-	err = cl.GenerateRequest(nil, certReq)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	//empty zone is the zone of the role
+	zoneRaw, ok := data.GetOk("zone")
+	if ok && zoneRaw.(string) != "" && zoneRaw.(string) != role.Zone {
+		if !role.AllowZoneOverride {
+			return logical.ErrorResponse(errorTextZoneOverrideNotAllowed), nil
+		}
+		if len(role.AllowedZones) > 0 && !strutil.StrListContainsGlob(role.AllowedZones, zoneRaw.(string)) {
+			return logical.ErrorResponse(fmt.Sprintf(errorTextZoneNotAllowed, zoneRaw.(string))), nil
+		}
+		reqData.zone = zoneRaw.(string)
 	}
 
-	b.Logger().Debug("Running enroll request")
+	//the issuing template is selected by the Cloud zone which uses it
+	if template, ok := data.GetOk("issuing_template"); ok && template.(string) != "" {
+		templateZone, ok := role.CloudIssuingTemplates[template.(string)]
+		if !ok {
+			return logical.ErrorResponse(fmt.Sprintf(errorTextIssuingTemplateNotAllowed, template.(string))), nil
+		}
+		if reqData.zone != "" && reqData.zone != templateZone {
+			return logical.ErrorResponse("zone and issuing_template can't be specified together"), nil
+		}
+		reqData.zone = templateZone
+	}
 
-	requestID, err := cl.RequestCertificate(certReq)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+	//the zone policy the role is bound to is checked locally, unless the request is sent to another zone
+	var policyWarning string
+	if role.VenafiPolicy != "" && (!signCSR || csr != nil) {
+		policy, err := b.getVenafiPolicy(ctx, req.Storage, role.VenafiPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if policy == nil {
+			return logical.ErrorResponse(fmt.Sprintf(errorTextUnknownVenafiPolicy, role.VenafiPolicy, role.VenafiPolicy)), nil
+		}
+		policyWarning = policy.staleWarning(role.VenafiPolicy)
+		if reqData.zone == "" || reqData.zone == policy.Zone {
+			policyReq, err := newPolicyRequest(reqData, role, csr, b.Logger())
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			if err := validatePolicyRequest(role.VenafiPolicy, policy, policyReq); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
 	}
 
-	pickupReq := &certificate.Request{
-		PickupID: requestID,
-		Timeout:  timeout,
+	//only the requests which passed the local checks are counted, as they are sent to Venafi
+	if err := b.checkIssuanceRateLimit(roleName, role); err != nil {
+		return nil, logical.CodedError(http.StatusTooManyRequests, err.Error())
 	}
-	pcc, err := cl.RetrieveCertificate(pickupReq)
-	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+
+	//with Platform failover the request is repeated on the next URL if the previous one is unavailable
+	tppURLs := []string{""}
+	if !role.Fakemode && role.TPPURL != "" {
+		tppURLs = b.tppHealth.order(role.tppURLs(), role.TPPLoadBalancing)
 	}
 
+	var certReq *certificate.Request
+	var pcc *certificate.PEMCollection
+	var servedBy string
+	var reissueIndex string
+	var reused *reusableCertificate
+	if role.PreventReissue && (!signCSR || csr != nil) {
+		names, err := requestNames(reqData, role, csr, b.Logger())
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		reissueIndex = reissueKey(roleName, reqData.zone, names)
+		reused, err = b.findReusableCertificate(ctx, req.Storage, reissueIndex, role, names, csr)
+		if err != nil {
+			return nil, err
+		}
+		//the certificate can be issued earlier by another mount or the storage can be wiped
+		if reused == nil && csr != nil {
+			cl, _, err := b.clientVenafi(ctx, req, roleName, tppURLs[0], reqData.zone)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			reused, err = searchVenafiCertificate(cl, role, names, csr)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("failed to search Venafi for the certificate: %s", err)), nil
+			}
+		}
+	}
+	if reused != nil {
+		certReq = &certificate.Request{PrivateKey: reused.privateKey}
+		pcc = reused.pcc
+	} else {
+		for i, tppURL := range tppURLs {
+			servedBy = tppURL
+			certReq, pcc, err = b.enrollCertificateWithRetries(ctx, req, roleName, tppURL, reqData, role, signCSR)
+			if _, requested := err.(*pendingCertificateError); requested || err == nil || i == len(tppURLs)-1 || !isFailoverError(err) {
+				break
+			}
+			b.Logger().Warn("Venafi Platform is unavailable, trying the next one", "tpp_url", tppURL, "next_tpp_url", tppURLs[i+1], "error", err)
+		}
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	//the key is encoded into the response and the storage entry, the generated key isn't needed afterwards
+	defer zeroizePrivateKey(certReq.PrivateKey)
+
 	pemBlock, _ := pem.Decode([]byte(pcc.Certificate))
 	parsedCertificate, err := x509.ParseCertificate(pemBlock.Bytes)
 	if err != nil {
@@ -196,29 +672,49 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 	if err != nil {
 		return nil, err
 	}
+	//the common name of a signed CSR is known only from the CSR, it is taken from the issued certificate
+	if reqData.commonName == "" {
+		reqData.commonName = parsedCertificate.Subject.CommonName
+	}
+	if role.PreferredChain != "" {
+		pcc.Chain = b.selectPreferredChain(pcc.Chain, role.PreferredChain, role.ChainOption)
+	}
+	if err := b.updateIssuerInfo(ctx, req.Storage, roleName, parsedCertificate, issuerFirstChain(pcc.Chain, role.ChainOption)); err != nil {
+		return nil, err
+	}
 
 	var entry *logical.StorageEntry
 	chain := strings.Join(append([]string{pcc.Certificate}, pcc.Chain...), "\n")
 
 	if !signCSR {
-		err = pcc.AddPrivateKey(certReq.PrivateKey, []byte(data.Get("key_password").(string)))
+		//the legacy PEM encryption of vcert is weak and not FIPS approved, the encrypted key is always PKCS#8
+		if reqData.keyPassword == "" && data.Get("private_key_format").(string) != privateKeyFormatPKCS8 {
+			err = pcc.AddPrivateKey(certReq.PrivateKey, nil)
+		} else {
+			pcc.PrivateKey, err = encodePKCS8PrivateKey(certReq.PrivateKey, []byte(reqData.keyPassword))
+		}
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if role.StorePrivateKey && !signCSR {
-		entry, err = logical.StorageEntryJSON("", VenafiCert{
+		entry, err = b.certificateEntry(ctx, req.Storage, "", VenafiCert{
 			Certificate:      pcc.Certificate,
 			CertificateChain: chain,
 			PrivateKey:       pcc.PrivateKey,
 			SerialNumber:     serialNumber,
+			PurgeKeyOnRead:   role.PurgePrivateKeyOnRead,
+			Role:             roleName,
+			Requester:        requesterOf(req),
 		})
 	} else {
-		entry, err = logical.StorageEntryJSON("", VenafiCert{
+		entry, err = b.certificateEntry(ctx, req.Storage, "", VenafiCert{
 			Certificate:      pcc.Certificate,
 			CertificateChain: chain,
 			SerialNumber:     serialNumber,
+			Role:             roleName,
+			Requester:        requesterOf(req),
 		})
 	}
 	if err != nil {
@@ -226,31 +722,48 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 	}
 
 	//if no_store is not specified
-	if !role.NoStore {
-		if role.StoreBy == storeByCNString {
-			//Writing certificate to the storage with CN
-			b.Logger().Debug("Putting certificate to the certs/" + reqData.commonName)
-			entry.Key = "certs/" + reqData.commonName
-
-			if err := req.Storage.Put(ctx, entry); err != nil {
-				b.Logger().Error("Error putting entry to storage: " + err.Error())
+	if !role.NoStore && (reused == nil || reused.storagePath == "") {
+		//Writing certificate to the storage with CN, Serial Number or fingerprint
+		entry.Key = certStoragePath(role, reqData.commonName, serialNumber, parsedCertificate.Raw)
+		b.Logger().Debug("Putting certificate to the storage", "path", entry.Key)
+
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			b.Logger().Error("Error putting entry to storage", "path", entry.Key, "error", err)
+			return nil, err
+		}
+
+		if err := indexCertificate(ctx, req.Storage, entry.Key, pcc.Certificate, roleName); err != nil {
+			return nil, err
+		}
+
+		if reissueIndex != "" {
+			indexEntry, err := logical.StorageEntryJSON(reissueIndex, reissueEntry{StoragePath: entry.Key})
+			if err != nil {
 				return nil, err
 			}
-		} else {
-			//Writing certificate to the storage with Serial Number
-			b.Logger().Debug("Putting certificate to the certs/", normalizeSerial(serialNumber))
-			entry.Key = "certs/" + normalizeSerial(serialNumber)
-
-			if err := req.Storage.Put(ctx, entry); err != nil {
-				b.Logger().Error("Error putting entry to storage: " + err.Error())
+			if err := req.Storage.Put(ctx, indexEntry); err != nil {
 				return nil, err
 			}
 		}
+	}
 
+	//the private key is delivered by another path, so the certificate and the key can have different policies
+	if role.SeparatePrivateKey && !signCSR {
+		keyEntry, err := logical.StorageEntryJSON("keys/"+normalizeSerial(serialNumber), privateKeyEntry{
+			PrivateKey:   pcc.PrivateKey,
+			SerialNumber: serialNumber,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Put(ctx, keyEntry); err != nil {
+			return nil, err
+		}
+		pcc.PrivateKey = ""
 	}
 
 	var respData map[string]interface{}
-	if !signCSR {
+	if !signCSR && !role.SeparatePrivateKey {
 		respData = map[string]interface{}{
 			"common_name":       reqData.commonName,
 			"serial_number":     serialNumber,
@@ -267,6 +780,29 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 		}
 	}
 
+	if format == "pem_bundle" {
+		respData["pem_bundle"] = pemBundle(pcc.PrivateKey, chain)
+	}
+	if role.SeparatePrivateKey && !signCSR {
+		respData["private_key_path"] = "key/" + normalizeSerial(serialNumber)
+	}
+	if servedBy != "" {
+		respData["tpp_url"] = servedBy
+	}
+
+	if reused != nil && reused.storagePath != "" {
+		entry.Key = reused.storagePath
+	}
+	if reused == nil {
+		b.notifyWebhooks(ctx, req.Storage, webhookPayload{
+			Event:        webhookEventIssued,
+			Role:         roleName,
+			CommonName:   reqData.commonName,
+			SerialNumber: serialNumber,
+			NotAfter:     parsedCertificate.NotAfter.UTC().Format(time.RFC3339),
+		})
+	}
+
 	var logResp *logical.Response
 	switch {
 	case !role.GenerateLease:
@@ -280,10 +816,53 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 			respData,
 			map[string]interface{}{
 				"serial_number": serialNumber,
+				//used to revoke the certificate when the lease is revoked
+				"role":         roleName,
+				"certificate":  pcc.Certificate,
+				"storage_path": entry.Key,
 			})
-		TTL := time.Until(parsedCertificate.NotAfter)
-		b.Logger().Debug("Setting up secret lease duration to: ", TTL.String())
+		TTL := role.leaseTTL(parsedCertificate.NotAfter)
+		b.Logger().Debug("Setting up secret lease duration", "ttl", TTL)
 		logResp.Secret.TTL = TTL
+		if role.RenewBefore > 0 && time.Until(parsedCertificate.NotAfter) <= role.RenewBefore {
+			logResp.AddWarning(fmt.Sprintf("Certificate is valid for less than renew_before %s, the lease expires with the certificate", role.RenewBefore))
+		}
+	}
+
+	//the CA can issue the certificate with shorter validity than requested, e.g. limited by the zone
+	if !reqData.notAfter.IsZero() {
+		if diff := parsedCertificate.NotAfter.Sub(reqData.notAfter); diff > validityTolerance || diff < -validityTolerance {
+			logResp.AddWarning(fmt.Sprintf("Certificate expires at %s instead of the requested not_after %s",
+				parsedCertificate.NotAfter.UTC().Format(time.RFC3339), reqData.notAfter.UTC().Format(time.RFC3339)))
+		}
+	} else if reqData.ttl > 0 && parsedCertificate.NotAfter.Before(time.Now().Add(reqData.ttl-validityTolerance)) {
+		logResp.AddWarning(fmt.Sprintf("Certificate expires at %s, earlier than the requested ttl %s",
+			parsedCertificate.NotAfter.UTC().Format(time.RFC3339), reqData.ttl))
+	}
+
+	if policyWarning != "" {
+		logResp.AddWarning(policyWarning)
+	}
+	if reused != nil {
+		logResp.AddWarning(fmt.Sprintf("Certificate %s issued earlier for the same names is returned instead of a new one", serialNumber))
+	}
+	for _, warning := range keyUsageWarnings(parsedCertificate, reqData.keyUsage, reqData.extKeyUsage) {
+		logResp.AddWarning(warning)
+	}
+	//the zone template may still override the requested usage
+	if role.ClientAuthOnly && isServerAuthCertificate(parsedCertificate) {
+		logResp.AddWarning("Certificate issued by the client_auth_only role can be used for server authentication, " +
+			"the zone template should restrict it to client authentication")
+	}
+
+	if signCSR && role.PreserveCSRExtensions {
+		csr, err := parseCSRPEM(reqData.csrString)
+		if err != nil {
+			return nil, err
+		}
+		for _, ext := range rejectedCSRExtensions(csr, parsedCertificate) {
+			logResp.AddWarning(fmt.Sprintf("Extension %s requested in the CSR was not included in the certificate by the CA", ext))
+		}
 	}
 
 	if !signCSR {
@@ -292,33 +871,249 @@ func (b *backend) pathVenafiCertObtain(ctx context.Context, req *logical.Request
 	return logResp, nil
 }
 
+// leaseTTL returns the lease duration of the certificate, which expires renew_before the certificate if it is valid
+// long enough
+func (r *roleEntry) leaseTTL(notAfter time.Time) time.Duration {
+	ttl := time.Until(notAfter)
+	if r.RenewBefore > 0 && ttl > r.RenewBefore {
+		return ttl - r.RenewBefore
+	}
+	return ttl
+}
+
+// enrollCertificate requests and retrieves the certificate from the Venafi endpoint of the role
+// pendingCertificate is the certificate request created at Venafi, which certificate is retrieved by its pickup ID
+type pendingCertificate struct {
+	client    endpoint.Connector
+	certReq   *certificate.Request
+	requestID string
+	timeout   time.Duration
+}
+
+// pendingCertificateError is returned when the certificate was requested but couldn't be retrieved, the same
+// request must not be submitted again, e.g. to the other Venafi Platform node
+type pendingCertificateError struct {
+	requestID string
+	err       error
+}
+
+func (e *pendingCertificateError) Error() string {
+	return fmt.Sprintf("certificate was requested with ID %s but retrieving it failed: %s", e.requestID, e.err)
+}
+
+// requestCertificate generates the CSR, if needed, and submits it to Venafi
+func (b *backend) requestCertificate(ctx context.Context, req *logical.Request, roleName string, tppURL string,
+	reqData requestData, role *roleEntry, signCSR bool) (pending *pendingCertificate, err error) {
+
+	b.Logger().Debug("Creating Venafi client", "role", roleName)
+	cl, timeout, err := b.clientVenafi(ctx, req, roleName, tppURL, reqData.zone)
+	if err != nil {
+		return nil, err
+	}
+
+	certReq, err := formRequest(reqData, role, signCSR, b.Logger())
+	if err != nil {
+		return nil, err
+	}
+	if certReq.CsrOrigin == certificate.ServiceGeneratedCSR {
+		if len(reqData.otherSANs) > 0 || reqData.keyUsage != 0 || len(reqData.extKeyUsage) > 0 {
+			return nil, fmt.Errorf("other_sans and key usages can't be requested with service_generated_cert, the CSR is generated by Venafi Platform")
+		}
+		if certReq.KeyPassword == "" {
+			certReq.KeyPassword, err = retrievalPassword()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if certReq.FriendlyName == "" && role.ObjectNameTemplate != "" {
+		commonName := certReq.Subject.CommonName
+		if commonName == "" {
+			commonName = reqData.commonName
+		}
+		certReq.FriendlyName, err = role.renderObjectName(roleName, commonName, reqData.zone, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to render object_name_template: %s", err)
+		}
+	}
+
+	//the cached client may be no longer valid, e.g. if its API key has expired
+	defer func() {
+		if err != nil {
+			b.clientCache.purge(roleName)
+		}
+	}()
+
+	b.Logger().Debug("Making certificate request")
+	err = cl.GenerateRequest(nil, certReq)
+	if err != nil {
+		return nil, err
+	}
+	//the key size is silently replaced by vcert if the zone policy doesn't allow the requested one
+	if !signCSR && role.KeyType == "rsa" && role.KeyBits > 0 && certReq.KeyLength != role.KeyBits {
+		return nil, zoneKeySizeError(cl, role.KeyBits)
+	}
+	if len(reqData.otherSANs) > 0 || reqData.keyUsage != 0 || len(reqData.extKeyUsage) > 0 {
+		extensions, err := keyUsageExtensions(reqData.keyUsage, reqData.extKeyUsage)
+		if err != nil {
+			return nil, err
+		}
+		err = rebuildCSR(certReq, reqData.otherSANs, extensions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.Logger().Debug("Running enroll request")
+
+	var requestID string
+	if notAfter := reqData.requestedValidity(time.Now()); !notAfter.IsZero() && !role.Fakemode {
+		//the role may have a refreshed access token already
+		current, roleErr := b.getRole(ctx, req.Storage, roleName)
+		if roleErr == nil && current != nil {
+			role = current
+		}
+		requestID, err = b.requestCertificateWithValidity(ctx, role, tppURL, reqData.zone, certReq, notAfter)
+		certReq.PickupID = requestID
+	} else {
+		requestID, err = cl.RequestCertificate(certReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pendingCertificate{client: cl, certReq: certReq, requestID: requestID, timeout: timeout}, nil
+}
+
+// retrieveCertificate picks up the requested certificate from Venafi
+func (b *backend) retrieveCertificate(ctx context.Context, req *logical.Request, roleName string, tppURL string,
+	role *roleEntry, pending *pendingCertificate) (pcc *certificate.PEMCollection, err error) {
+
+	//the cached client may be no longer valid, e.g. if its API key has expired
+	defer func() {
+		if err != nil {
+			b.clientCache.purge(roleName)
+		}
+	}()
+
+	requestID := pending.requestID
+	pcc, err = pending.client.RetrieveCertificate(&certificate.Request{
+		PickupID:    requestID,
+		Timeout:     pending.timeout,
+		CsrOrigin:   pending.certReq.CsrOrigin,
+		KeyPassword: pending.certReq.KeyPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+	//the key is encoded afterwards like the locally generated one
+	if pending.certReq.CsrOrigin == certificate.ServiceGeneratedCSR {
+		pending.certReq.PrivateKey, err = decryptServiceGeneratedKey(pcc.PrivateKey, pending.certReq.KeyPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the private key generated by Venafi Platform: %s", err)
+		}
+		pcc.PrivateKey = ""
+	}
+
+	if len(role.Contacts) > 0 && !role.Fakemode && role.TPPURL != "" {
+		if tppURL == "" {
+			tppURL = role.TPPURL
+		}
+		//the role may have a refreshed access token already
+		current, roleErr := b.getRole(ctx, req.Storage, roleName)
+		if roleErr == nil && current != nil {
+			role = current
+		}
+		//the certificate is issued already, so failing to set contacts doesn't fail the request
+		if contactsErr := b.setTPPContacts(ctx, role, tppURL, requestID); contactsErr != nil {
+			b.Logger().Warn("Failed to set contacts of certificate", "request_id", requestID, "error", contactsErr)
+		}
+	}
+	return pcc, nil
+}
+
 type requestData struct {
 	commonName  string
 	altNames    []string
 	ipSANs      []string
+	uriSANs     []string
+	emailSANs   []string
+	otherSANs   []otherSAN
 	keyPassword string
 	csrString   string
+	zone        string
+	//the CSR is submitted as provided, without the role subject and SAN rules
+	verbatim bool
+	//the CN isn't added to the SANs
+	excludeCNFromSANs bool
+	//subject fields other than CN
+	subject pkix.Name
+	//certificate object name at the Venafi Platform
+	friendlyName string
+	//value of the Origin custom field
+	origin string
+	//Venafi Platform custom fields by name
+	customFields map[string]string
+	//requested validity of the certificate
+	ttl time.Duration
+	//ttl was requested, not the default one of the role
+	ttlRequested bool
+	//requested expiration time of the certificate, ttl is set from it too
+	notAfter time.Time
+	//key usage extensions added to the generated CSR
+	keyUsage    x509.KeyUsage
+	extKeyUsage []x509.ExtKeyUsage
+}
+
+// zoneKeySizeError describes the RSA key sizes allowed by the zone policy when key_bits of the role is not one of them
+func zoneKeySizeError(cl endpoint.Connector, keyBits int) error {
+	zoneConfig, err := cl.ReadZoneConfiguration()
+	if err != nil {
+		return fmt.Errorf("key_bits %d is not allowed by the zone policy", keyBits)
+	}
+	var sizes []int
+	for _, kc := range zoneConfig.AllowedKeyConfigurations {
+		if kc.KeyType == certificate.KeyTypeRSA {
+			sizes = append(sizes, kc.KeySizes...)
+		}
+	}
+	return fmt.Errorf("key_bits %d is not allowed by the zone policy, allowed RSA key sizes: %v", keyBits, sizes)
+}
+
+// pemBundle concatenates the private key, if any, and the certificate chain for the callers writing them to one file
+func pemBundle(privateKey string, chain string) string {
+	if privateKey == "" {
+		return chain
+	}
+	return strings.TrimRight(privateKey, "\n") + "\n" + chain
 }
 
 func formRequest(reqData requestData, role *roleEntry, signCSR bool, logger hclog.Logger) (certReq *certificate.Request, err error) {
 	if !signCSR {
-		if len(reqData.commonName) == 0 && len(reqData.altNames) == 0 {
+		if len(reqData.commonName) == 0 && len(reqData.altNames) == 0 && len(reqData.emailSANs) == 0 {
 			return certReq, fmt.Errorf("no domains specified on certificate")
 		}
 		if len(reqData.commonName) == 0 && len(reqData.altNames) > 0 {
 			reqData.commonName = reqData.altNames[0]
 		}
-		if !sliceContains(reqData.altNames, reqData.commonName) {
-			logger.Debug(fmt.Sprintf("Adding CN %s to SAN %s because it wasn't included.", reqData.commonName, reqData.altNames))
+		//S/MIME certificates can be requested with the email address only
+		if len(reqData.commonName) == 0 && len(reqData.emailSANs) > 0 {
+			reqData.commonName = reqData.emailSANs[0]
+		}
+		if !reqData.excludeCNFromSANs && !sliceContains(reqData.altNames, reqData.commonName) {
+			logger.Debug("Adding CN to SAN because it wasn't included", "common_name", reqData.commonName, "alt_names", reqData.altNames)
 			reqData.altNames = append(reqData.altNames, reqData.commonName)
 		}
+		subject := reqData.subject
+		subject.CommonName = reqData.commonName
 		certReq = &certificate.Request{
-			Subject: pkix.Name{
-				CommonName: reqData.commonName,
-			},
+			Subject:     subject,
 			CsrOrigin:   certificate.LocalGeneratedCSR,
 			KeyPassword: reqData.keyPassword,
 		}
+		if role.ServiceGenerated {
+			//the Platform generates the key and the CSR from the CN and SANs, the other subject fields are taken from the zone
+			certReq.CsrOrigin = certificate.ServiceGeneratedCSR
+		}
 		ipSet := make(map[string]struct{})
 		nameSet := make(map[string]struct{})
 		for _, v := range reqData.altNames {
@@ -331,6 +1126,11 @@ func formRequest(reqData requestData, role *roleEntry, signCSR bool, logger hclo
 				nameSet[v] = struct{}{}
 			}
 		}
+		for _, v := range reqData.emailSANs {
+			if !sliceContains(certReq.EmailAddresses, v) {
+				certReq.EmailAddresses = append(certReq.EmailAddresses, v)
+			}
+		}
 		for _, v := range reqData.ipSANs {
 			if net.ParseIP(v) != nil {
 				ipSet[v] = struct{}{}
@@ -342,6 +1142,13 @@ func formRequest(reqData requestData, role *roleEntry, signCSR bool, logger hclo
 		for k := range nameSet {
 			certReq.DNSNames = append(certReq.DNSNames, k)
 		}
+		for _, v := range reqData.uriSANs {
+			uri, err := url.Parse(v)
+			if err != nil {
+				return certReq, err
+			}
+			certReq.URIs = append(certReq.URIs, uri)
+		}
 
 	} else {
 		logger.Debug("Signing user provided CSR")
@@ -350,15 +1157,10 @@ func formRequest(reqData requestData, role *roleEntry, signCSR bool, logger hclo
 			return certReq, fmt.Errorf("\"csr\" is empty")
 		}
 		pemBytes := []byte(reqData.csrString)
-		pemBlock, _ := pem.Decode(pemBytes)
-		if pemBlock == nil {
-			return certReq, fmt.Errorf("csr contains no data")
-		}
-		csr, err := x509.ParseCertificateRequest(pemBlock.Bytes)
-		if err != nil {
+		if _, err := parseCSRPEM(reqData.csrString); err != nil {
 			return certReq, fmt.Errorf("can't parse provided CSR %v", err)
 		}
-		reqData.commonName = csr.Subject.CommonName
+		//the CSR is submitted unchanged with all requested extensions, the CA decides which of them are issued
 		certReq = &certificate.Request{
 			CsrOrigin: certificate.UserProvidedCSR,
 		}
@@ -397,20 +1199,79 @@ func formRequest(reqData requestData, role *roleEntry, signCSR bool, logger hclo
 		return certReq, fmt.Errorf("Invalid chain option %s", role.ChainOption)
 	}
 
-	//Adding origin custom field with utility name to certificate metadata
-	certReq.CustomFields = []certificate.CustomField{{Type: certificate.CustomFieldOrigin, Value: utilityName}}
+	certReq.FriendlyName = reqData.friendlyName
+
+	//Adding origin custom field to certificate metadata
+	origin := reqData.origin
+	if origin == "" {
+		origin = utilityName
+	}
+	certReq.CustomFields = []certificate.CustomField{{Type: certificate.CustomFieldOrigin, Value: origin}}
+	names := make([]string, 0, len(reqData.customFields))
+	for name := range reqData.customFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		certReq.CustomFields = append(certReq.CustomFields, certificate.CustomField{Name: name, Value: reqData.customFields[name]})
+	}
 
 	return certReq, nil
 }
 
 type VenafiCert struct {
-	Certificate      string `json:"certificate"`
-	CertificateChain string `json:"certificate_chain"`
-	PrivateKey       string `json:"private_key"`
-	SerialNumber     string `json:"serial_number"`
+	Certificate      string         `json:"certificate"`
+	CertificateChain string         `json:"certificate_chain"`
+	PrivateKey       string         `json:"private_key"`
+	SerialNumber     string         `json:"serial_number"`
+	RevocationTime   int64          `json:"revocation_time,omitempty"`
+	PurgeKeyOnRead   bool           `json:"purge_key_on_read,omitempty"`
+	Role             string         `json:"role,omitempty"`
+	RenewedFrom      string         `json:"renewed_from,omitempty"`
+	RenewedTo        string         `json:"renewed_to,omitempty"`
+	Requester        *certRequester `json:"requester,omitempty"`
+}
+
+// certRequester identifies the client which requested the certificate
+type certRequester struct {
+	EntityID      string `json:"entity_id,omitempty"`
+	DisplayName   string `json:"display_name,omitempty"`
+	TokenAccessor string `json:"token_accessor,omitempty"`
+	RequestID     string `json:"request_id,omitempty"`
+	ClientIP      string `json:"client_ip,omitempty"`
+}
+
+// requesterOf returns the requester of the certificate, nil for the requests made by the backend itself
+func requesterOf(req *logical.Request) *certRequester {
+	requester := certRequester{
+		EntityID:      req.EntityID,
+		DisplayName:   req.DisplayName,
+		TokenAccessor: req.ClientTokenAccessor,
+		RequestID:     req.ID,
+	}
+	if req.Connection != nil {
+		requester.ClientIP = req.Connection.RemoteAddr
+	}
+	if requester == (certRequester{}) {
+		return nil
+	}
+	return &requester
+}
+
+func (r *certRequester) toResponseData() map[string]interface{} {
+	return map[string]interface{}{
+		"entity_id":      r.EntityID,
+		"display_name":   r.DisplayName,
+		"token_accessor": r.TokenAccessor,
+		"request_id":     r.RequestID,
+		"client_ip":      r.ClientIP,
+	}
 }
 
 const (
+	//differences of the certificate validity smaller than this are not reported
+	validityTolerance = 5 * time.Minute
+
 	pathConfigRootHelpSyn = `
 Configure the Venafi TPP credentials that are used to manage certificates,
 `
@@ -424,9 +1285,20 @@ Enroll Venafi certificate
 Enroll Venafi certificate
 `
 	pathVenafiCertSignHelp = `
-Sign Venafi certificate
+Sign Venafi certificate from the provided CSR
+`
+	pathVenafiCertSignVerbatimHelp = `
+Sign Venafi certificate from the provided CSR as is
+`
+	pathVenafiCertSignVerbatimDesc = `
+Submit the PEM-format CSR to the zone of the role keeping its subject and SANs
+exactly as provided. Role rules which rewrite the subject, key and allowed_domains
+are not applied, but denied_domains, allow_wildcard_certificates, enforce_hostnames
+and the allowed SAN types of the role are still checked.
 `
 	pathVenafiCertSignDesc = `
-Sign Venafi certificate
+Submit the PEM-format CSR to the zone of the role and return the issued certificate
+with its chain. The private key stays with the caller, e.g. in an HSM, and is never
+sent to Vault or stored by it.
 `
 )
@@ -1,7 +1,22 @@
 package pki
 
 import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/logical"
 )
 
 func TestOriginInRequest(t *testing.T) {
@@ -23,3 +38,710 @@ func TestOriginInRequest(t *testing.T) {
 		t.Fatalf("Expected %s in request custom fields origin", utilityName)
 	}
 }
+
+func TestOriginTag(t *testing.T) {
+	var role roleEntry
+	expected := utilityName + " venafi-pki " + pluginVersion
+	if origin := role.originTag("venafi-pki/"); origin != expected {
+		t.Fatalf("Expected default origin %s, got %s", expected, origin)
+	}
+
+	role.Origin = "Vault PKI team"
+	if origin := role.originTag("venafi-pki/"); origin != role.Origin {
+		t.Fatalf("Expected origin %s from the role, got %s", role.Origin, origin)
+	}
+
+	data := requestData{commonName: "tpp.example.com", origin: role.originTag("venafi-pki/")}
+	role.KeyType = "rsa"
+	role.ChainOption = "first"
+	certReq, err := formRequest(data, &role, false, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certReq.CustomFields[0].Value != role.Origin {
+		t.Fatalf("Expected %s in request custom fields origin", role.Origin)
+	}
+}
+
+func TestCustomFieldsInRequest(t *testing.T) {
+	role := roleEntry{KeyType: "rsa", ChainOption: "first"}
+	data := requestData{commonName: "tpp.example.com", customFields: map[string]string{"Ticket": "CHG-42", "Cost Center": "1234"}}
+
+	certReq, err := formRequest(data, &role, false, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certReq.CustomFields) != 3 {
+		t.Fatalf("Expected origin and 2 custom fields in request, got %v", certReq.CustomFields)
+	}
+	if f := certReq.CustomFields[1]; f.Type != certificate.CustomFieldPlain || f.Name != "Cost Center" || f.Value != "1234" {
+		t.Fatalf("Unexpected custom field %v", f)
+	}
+	if f := certReq.CustomFields[2]; f.Name != "Ticket" || f.Value != "CHG-42" {
+		t.Fatalf("Unexpected custom field %v", f)
+	}
+}
+
+func TestServiceGeneratedCert(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	write := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := write("roles/service", map[string]interface{}{"fakemode": true, "service_generated_cert": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	for _, password := range []string{"", "password"} {
+		resp := write("issue/service", map[string]interface{}{"common_name": "service.venafi.example.com", "key_password": password})
+		if resp == nil || resp.IsError() {
+			t.Fatalf("bad: resp: %#v", resp)
+		}
+		cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		//the fake Platform adds the SAN to the CSR it generates
+		if !sliceContains(cert.DNSNames, "fake-service-generated.service.venafi.example.com") {
+			t.Fatalf("Expecting CSR generated by the Platform, got SANs %v", cert.DNSNames)
+		}
+
+		block, _ := pem.Decode([]byte(resp.Data["private_key"].(string)))
+		der := block.Bytes
+		if password != "" {
+			der = decryptPKCS8PrivateKey(t, block, []byte(password))
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+		key, err := parsePrivateKeyBlock(block)
+		if err != nil || key == nil {
+			t.Fatalf("Expecting private key generated by the Platform, err: %v", err)
+		}
+		if !reflect.DeepEqual(key.Public(), cert.PublicKey) {
+			t.Fatalf("Private key doesn't match the certificate")
+		}
+	}
+
+	resp := write("issue/service", map[string]interface{}{"common_name": "service.venafi.example.com", "key_usage": "DigitalSignature"})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting key usages to be rejected with service_generated_cert, got %#v", resp)
+	}
+	if !strings.Contains(resp.Data["error"].(string), "service_generated_cert") {
+		t.Fatalf("Unexpected error %s", resp.Data["error"])
+	}
+}
+
+func TestRoleSubjectFields(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/subject",
+		Storage:   storage,
+		Data: map[string]interface{}{"fakemode": true, "organization": "Venafi", "organizational_unit": "DevOps,Web",
+			"country": "US", "locality": "Salt Lake City", "province": "Utah"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/subject",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "subject.venafi.example.com", "organizational_unit": "Security"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cert.Subject
+	if len(s.Organization) != 1 || s.Organization[0] != "Venafi" || len(s.Country) != 1 || s.Country[0] != "US" ||
+		len(s.Locality) != 1 || s.Locality[0] != "Salt Lake City" || len(s.Province) != 1 || s.Province[0] != "Utah" {
+		t.Fatalf("Expecting role subject defaults in certificate, got %s", s)
+	}
+	if len(s.OrganizationalUnit) != 1 || s.OrganizationalUnit[0] != "Security" {
+		t.Fatalf("Expecting organizational unit from the request, got %v", s.OrganizationalUnit)
+	}
+}
+
+func TestRequestTTL(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/ttl",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "max_ttl": "9000h"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	//fake CA issues certificates valid for 89 days
+	for ttl, clamped := range map[string]bool{"720h": false, "8760h": true} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/ttl",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": "ttl.venafi.example.com", "ttl": ttl},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		warned := false
+		for _, w := range resp.Warnings {
+			warned = warned || strings.Contains(w, "earlier than the requested ttl")
+		}
+		if warned != clamped {
+			t.Fatalf("ttl %s: expecting validity warning %v, got %v", ttl, clamped, resp.Warnings)
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/ttl",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "ttl.venafi.example.com", "ttl": "10000h"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting ttl larger than max_ttl to be rejected")
+	}
+}
+
+func TestRequestNotAfter(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/not-after",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	for _, c := range []struct {
+		data  map[string]interface{}
+		valid bool
+	}{
+		{map[string]interface{}{"not_after": time.Now().Add(24 * time.Hour).Format(time.RFC3339)}, true},
+		{map[string]interface{}{"not_after": "2020-01-01"}, false},
+		{map[string]interface{}{"not_after": time.Now().Add(-time.Hour).Format(time.RFC3339)}, false},
+		{map[string]interface{}{"not_after": time.Now().Add(time.Hour).Format(time.RFC3339), "ttl": "1h"}, false},
+	} {
+		c.data["common_name"] = "not-after.venafi.example.com"
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/not-after",
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.valid == (resp == nil || resp.IsError()) {
+			t.Fatalf("%v: expecting valid %v but got %#v", c.data, c.valid, resp)
+		}
+		//fake CA doesn't honor the requested expiration
+		if c.valid && !strings.Contains(strings.Join(resp.Warnings, " "), "instead of the requested not_after") {
+			t.Fatalf("Expecting warning about not_after, got %v", resp.Warnings)
+		}
+	}
+}
+
+func TestIssuingTemplate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/issuing-template",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"fakemode":                true,
+			"cloud_issuing_templates": []string{"Default=Default", "Internal=Internal Zone"},
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	for template, valid := range map[string]bool{"Internal": true, "Unknown": false} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/issuing-template",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"common_name":      "template.venafi.example.com",
+				"issuing_template": template,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid && (resp == nil || resp.IsError()) {
+			t.Fatalf("%s: expecting certificate but got %#v", template, resp)
+		}
+		if !valid && (resp == nil || resp.Data["error"] != fmt.Sprintf(errorTextIssuingTemplateNotAllowed, template)) {
+			t.Fatalf("%s: expecting error but got %#v", template, resp)
+		}
+	}
+
+	err = validateEntry(&roleEntry{TPPURL: "https://tpp.example.com", TPPUser: "admin", TPPPassword: "secret",
+		CloudIssuingTemplates: templateZones{"Default": "Default"}})
+	if err == nil || err.Error() != errorTextIssuingTemplatesWithTPP {
+		t.Fatalf("Expecting error %s, got %v", errorTextIssuingTemplatesWithTPP, err)
+	}
+}
+
+func TestPEMBundleFormat(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/pem-bundle",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/pem-bundle",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "bundle.venafi.example.com", "format": "pem_bundle"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	rest := []byte(resp.Data["pem_bundle"].(string))
+	var types []string
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		types = append(types, block.Type)
+	}
+	if len(types) < 3 || !strings.Contains(types[0], "PRIVATE KEY") || types[1] != "CERTIFICATE" || types[2] != "CERTIFICATE" {
+		t.Fatalf("Expecting private key, certificate and chain in pem_bundle, got %v", types)
+	}
+	if !strings.Contains(resp.Data["pem_bundle"].(string), resp.Data["certificate_chain"].(string)) {
+		t.Fatalf("Expecting certificate_chain in pem_bundle")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/pem-bundle",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "bundle.venafi.example.com", "format": "der"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error for unsupported format, got err: %v resp: %#v", err, resp)
+	}
+}
+
+func TestPKCS8PrivateKeyFormat(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	for _, keyType := range []string{"rsa", "ec"} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/pkcs8-" + keyType,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "key_type": keyType},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/pkcs8-" + keyType,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": "pkcs8.venafi.example.com", "private_key_format": "pkcs8"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		block, _ := pem.Decode([]byte(resp.Data["private_key"].(string)))
+		if block == nil || block.Type != "PRIVATE KEY" {
+			t.Fatalf("Expecting PKCS#8 private key, got %s", resp.Data["private_key"])
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(key.(crypto.Signer).Public(), cert.PublicKey) {
+			t.Fatalf("Private key doesn't match the %s certificate", keyType)
+		}
+	}
+}
+
+func TestSeparatePrivateKey(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/separate-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "separate_private_key": true, "store_pkey": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextSeparateKeyAndStorePrivateKey {
+		t.Fatalf("Expecting error %s, got %#v", errorTextSeparateKeyAndStorePrivateKey, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/separate-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "separate_private_key": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/separate-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "separate-key.venafi.example.com", "format": "pem_bundle"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if _, ok := resp.Data["private_key"]; ok || strings.Contains(resp.Data["pem_bundle"].(string), "PRIVATE KEY") {
+		t.Fatalf("Expecting no private key in the issue response, got %v", resp.Data)
+	}
+	cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := resp.Data["private_key_path"].(string)
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      keyPath,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["private_key"].(string)))
+	if block == nil {
+		t.Fatalf("Expecting private key, got %v", resp.Data)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Public(), cert.PublicKey) {
+		t.Fatal("Private key doesn't match the certificate")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      keyPath,
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error on the second read, got err: %v resp: %#v", err, resp)
+	}
+}
+
+func TestPurgePrivateKeyOnRead(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/purge-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "purge_pkey_on_read": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextPurgeOnReadWithoutStorePrivateKey {
+		t.Fatalf("Expecting error %s, got %#v", errorTextPurgeOnReadWithoutStorePrivateKey, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/purge-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "store_pkey": true, "purge_pkey_on_read": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/purge-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "purge-key.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	certPath := "cert/" + normalizeSerial(resp.Data["serial_number"].(string))
+
+	for i, withKey := range []bool{true, false} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      certPath,
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		if withKey != (resp.Data["private_key"] != "") {
+			t.Fatalf("Read %d: expecting private key %v, got %q", i+1, withKey, resp.Data["private_key"])
+		}
+		if resp.Data["certificate"] == "" {
+			t.Fatalf("Read %d: expecting certificate", i+1)
+		}
+	}
+}
+
+func TestPrivateKeyNotInLease(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/lease-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "generate_lease": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/lease-key",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "lease-key.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Secret == nil {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	//the lease internal data is persisted by Vault, so it must not contain the key
+	for name, value := range resp.Secret.InternalData {
+		if s, ok := value.(string); ok && strings.Contains(s, "PRIVATE KEY") {
+			t.Fatalf("Lease internal data %s contains the private key", name)
+		}
+	}
+	if !strings.Contains(resp.Data["private_key"].(string), "PRIVATE KEY") {
+		t.Fatalf("Expected private key in the response")
+	}
+}
+
+func TestCertificateRequester(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/requester",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation:           logical.UpdateOperation,
+		Path:                "issue/requester",
+		Storage:             storage,
+		Data:                map[string]interface{}{"common_name": "requester.venafi.example.com"},
+		ID:                  "request-id",
+		EntityID:            "entity-id",
+		DisplayName:         "token-app",
+		ClientTokenAccessor: "token-accessor",
+		Connection:          &logical.Connection{RemoteAddr: "192.0.2.10"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cert/" + normalizeSerial(resp.Data["serial_number"].(string)),
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	expected := map[string]interface{}{
+		"entity_id":      "entity-id",
+		"display_name":   "token-app",
+		"token_accessor": "token-accessor",
+		"request_id":     "request-id",
+		"client_ip":      "192.0.2.10",
+	}
+	if !reflect.DeepEqual(resp.Data["requester"], expected) {
+		t.Fatalf("Expecting requester %v, got %v", expected, resp.Data["requester"])
+	}
+}
+
+func TestStoreByHash(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for _, storeBy := range []string{storeByHashString, storeBySerialString} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + storeBy,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "store_by": storeBy},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + storeBy,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": storeBy + ".venafi.example.com"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		serial := resp.Data["serial_number"].(string)
+		cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fingerprint := sha256.Sum256(cert.Raw)
+		uid := hex.EncodeToString(fingerprint[:])
+
+		stored := "certs/" + normalizeSerial(serial)
+		if storeBy == storeByHashString {
+			stored = "certs/" + uid
+		}
+		if entry, err := storage.Get(ctx, stored); err != nil || entry == nil {
+			t.Fatalf("Expecting certificate of role %s stored in %s, err: %v", storeBy, stored, err)
+		}
+
+		//the certificates are read by the fingerprint and the serial number regardless of store_by
+		for _, uid := range []string{strings.ToUpper(uid), normalizeSerial(serial)} {
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "cert/" + uid,
+				Storage:   storage,
+			})
+			if err != nil || resp == nil || resp.IsError() || resp.Data["serial_number"] != serial {
+				t.Fatalf("Expecting certificate %s read by %s, err: %v resp: %#v", serial, uid, err, resp)
+			}
+		}
+	}
+}
+
+func TestStoragePrefix(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for _, prefix := range []string{"../prod", "prod/", "prod web"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/invalid-prefix",
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "storage_prefix": prefix},
+		})
+		if err != nil || resp == nil || !resp.IsError() {
+			t.Fatalf("Expecting error for storage_prefix %q, got err: %v resp: %#v", prefix, err, resp)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/prod-web",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "storage_prefix": "prod/web"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/prod-web",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "prod-web.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	serial := resp.Data["serial_number"].(string)
+	uid := "prod/web/" + normalizeSerial(serial)
+	if entry, err := storage.Get(ctx, "certs/"+uid); err != nil || entry == nil {
+		t.Fatalf("Expecting certificate stored in certs/%s, err: %v", uid, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cert/" + normalizeSerial(serial),
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() || resp.Data["serial_number"] != serial {
+		t.Fatalf("Expecting certificate read by serial number, err: %v resp: %#v", err, resp)
+	}
+
+	for path, op := range map[string]logical.Operation{"roles/prod-web/certs/": logical.ListOperation, "certs/expiring": logical.ReadOperation} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      map[string]interface{}{"within": "2400h"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != uid {
+			t.Fatalf("Expecting %s listed by %s, got %v", uid, path, keys)
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tidy",
+		Storage:   storage,
+		Data:      map[string]interface{}{"tidy_cert_store": true, "storage_prefix": "prod/web"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	for i := 0; atomic.LoadInt32(&b.tidyRunning) != 0; i++ {
+		if i > 100 {
+			t.Fatal("Tidy is not finished")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if b.tidyStatus.scanned != 1 {
+		t.Fatalf("Expecting 1 certificate scanned under storage_prefix, got %d", b.tidyStatus.scanned)
+	}
+}
@@ -0,0 +1,125 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	exportFormatPEM  = "pem"
+	exportFormatJSON = "json"
+)
+
+func pathVenafiCertExport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/export",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Export only the certificates issued by the role",
+			},
+			"within": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Export only the certificates expiring within this duration, including the expired ones",
+			},
+			"format": {
+				Type: framework.TypeString,
+				Description: `"pem" (default) returns the concatenated PEM certificates as application/x-pem-file,
+"json" returns the manifest of the certificates with their chains and metadata`,
+				Default: exportFormatPEM,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCertExport,
+		},
+
+		HelpSynopsis:    pathVenafiCertExportHelpSyn,
+		HelpDescription: pathVenafiCertExportHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiCertExport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+	within := time.Duration(data.Get("within").(int)) * time.Second
+	format := data.Get("format").(string)
+	if format != exportFormatPEM && format != exportFormatJSON {
+		return logical.ErrorResponse(fmt.Sprintf("invalid format %s, it must be %s or %s", format, exportFormatPEM, exportFormatJSON)), nil
+	}
+
+	uids, err := listStoredCertificates(ctx, req.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+	var bundle []string
+	manifest := make([]map[string]interface{}, 0)
+	for _, uid := range uids {
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		if roleName != "" && cert.Role != roleName {
+			continue
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			b.Logger().Warn("Failed to parse stored certificate", "path", "certs/"+uid, "error", err)
+			continue
+		}
+		if within > 0 && time.Until(parsed.NotAfter) > within {
+			continue
+		}
+
+		//the private keys are never exported
+		if format == exportFormatPEM {
+			bundle = append(bundle, strings.TrimSpace(cert.Certificate))
+			continue
+		}
+		item := storedCertificateInfo(cert, parsed)
+		item["certificate_uid"] = uid
+		item["certificate"] = cert.Certificate
+		item["certificate_chain"] = cert.CertificateChain
+		manifest = append(manifest, item)
+	}
+
+	if format == exportFormatJSON {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"certificates": manifest,
+			},
+		}, nil
+	}
+	body := strings.Join(bundle, "\n")
+	if body != "" {
+		body += "\n"
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/x-pem-file",
+			logical.HTTPRawBody:     []byte(body),
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+const pathVenafiCertExportHelpSyn = `
+Export the stored certificates.
+`
+
+const pathVenafiCertExportHelpDesc = `
+Returns the stored certificates, optionally only the ones of the role or expiring within the duration,
+as a PEM bundle or a JSON manifest with the chains, e.g. for backups, audits and building trust stores.
+The private keys are not exported.
+`
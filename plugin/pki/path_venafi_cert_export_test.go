@@ -0,0 +1,67 @@
+package pki
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestExportCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	serials := make(map[string]string)
+	for _, role := range []string{"export-a", "export-b"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "store_private_key": true},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": role + ".venafi.example.com"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		serials[role] = resp.Data["serial_number"].(string)
+	}
+
+	export := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "certs/export",
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp
+	}
+
+	body := string(export(map[string]interface{}{}).Data[logical.HTTPRawBody].([]byte))
+	if strings.Count(body, "BEGIN CERTIFICATE") != 2 || strings.Contains(body, "PRIVATE KEY") {
+		t.Fatalf("Expecting 2 certificates without private keys in the bundle, got %s", body)
+	}
+	//the fake connector issues certificates valid for 90 days
+	if body := export(map[string]interface{}{"within": "720h"}).Data[logical.HTTPRawBody].([]byte); len(body) != 0 {
+		t.Fatalf("Expecting no certificates expiring within 720h, got %s", body)
+	}
+
+	manifest := export(map[string]interface{}{"role": "export-b", "format": "json"}).Data["certificates"].([]map[string]interface{})
+	if len(manifest) != 1 || manifest[0]["serial_number"] != serials["export-b"] || manifest[0]["certificate_chain"] == "" {
+		t.Fatalf("Expecting certificate %s of role export-b in the manifest, got %v", serials["export-b"], manifest)
+	}
+	if _, ok := manifest[0]["private_key"]; ok {
+		t.Fatal("Private key must not be exported")
+	}
+}
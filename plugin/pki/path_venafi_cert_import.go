@@ -0,0 +1,277 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// maxBulkImportItems limits the duration of the bulk import request, larger inventories are imported in batches
+const maxBulkImportItems = 1000
+
+func pathVenafiCertImport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/import",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Name of the role which zone issued the certificate",
+			},
+			"certificate": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded certificate",
+			},
+			"certificate_chain": {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA chain of the certificate starting from the issuing CA. The CA chain of the role is stored if not set",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathVenafiCertImport,
+		},
+
+		HelpSynopsis:    pathVenafiCertImportHelpSyn,
+		HelpDescription: pathVenafiCertImportHelpDesc,
+	}
+}
+
+func pathVenafiCertImportBulk(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/import/bulk",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Name of the role of the certificates which don't specify the role",
+			},
+			"certificates": {
+				Type:        framework.TypeString,
+				Description: "Concatenated PEM encoded certificates, stored with the CA chain of the role",
+			},
+			"items": {
+				Type:        framework.TypeSlice,
+				Description: "JSON array of objects with certificate, optional certificate_chain and role",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathVenafiCertImportBulk,
+		},
+
+		HelpSynopsis:    pathVenafiCertImportBulkHelpSyn,
+		HelpDescription: pathVenafiCertImportBulkHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiCertImport(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+	return b.importCertificate(ctx, req, data.Get("role").(string), data.Get("certificate").(string), data.Get("certificate_chain").(string))
+}
+
+// importItem is a certificate of the bulk import
+type importItem struct {
+	role        string
+	certificate string
+	chain       string
+}
+
+func (b *backend) pathVenafiCertImportBulk(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+	defaultRole := data.Get("role").(string)
+
+	var items []importItem
+	rest := []byte(data.Get("certificates").(string))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			items = append(items, importItem{role: defaultRole, certificate: string(pem.EncodeToMemory(block))})
+		}
+	}
+	for i, raw := range data.Get("items").([]interface{}) {
+		fields, ok := raw.(map[string]interface{})
+		if !ok {
+			return logical.ErrorResponse(fmt.Sprintf("item %d is not an object", i)), nil
+		}
+		item := importItem{role: defaultRole}
+		item.certificate, _ = fields["certificate"].(string)
+		item.chain, _ = fields["certificate_chain"].(string)
+		if role, _ := fields["role"].(string); role != "" {
+			item.role = role
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return logical.ErrorResponse("certificates or items must be specified"), nil
+	}
+	if len(items) > maxBulkImportItems {
+		return logical.ErrorResponse(fmt.Sprintf("at most %d certificates can be imported at once, got %d", maxBulkImportItems, len(items))), nil
+	}
+
+	imported := 0
+	results := make([]map[string]interface{}, 0, len(items))
+	for i, item := range items {
+		result := map[string]interface{}{"index": i, "role": item.role}
+		resp, err := b.importCertificate(ctx, req, item.role, item.certificate, item.chain)
+		switch {
+		case err != nil:
+			result["error"] = err.Error()
+		case resp.IsError():
+			result["error"] = resp.Data["error"]
+		default:
+			imported++
+			result["certificate_uid"] = resp.Data["certificate_uid"]
+			result["serial_number"] = resp.Data["serial_number"]
+		}
+		results = append(results, result)
+	}
+	b.Logger().Info("Imported certificates", "imported", imported, "total", len(items))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"imported": imported,
+			"failed":   len(items) - imported,
+			"results":  results,
+		},
+	}, nil
+}
+
+// importCertificate stores the certificate issued by the CA of the role zone out of band, so it is renewed, tidied
+// and reported like the certificates issued by the role
+func (b *backend) importCertificate(ctx context.Context, req *logical.Request, roleName string, certPEM string,
+	chainPEM string) (*logical.Response, error) {
+
+	if roleName == "" {
+		return logical.ErrorResponse("role must be specified"), nil
+	}
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if role.NoStore {
+		return logical.ErrorResponse(fmt.Sprintf("role %s doesn't store certificates", roleName)), nil
+	}
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid certificate: %s", err)), nil
+	}
+	if time.Now().After(cert.NotAfter) {
+		return logical.ErrorResponse(fmt.Sprintf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))), nil
+	}
+
+	//the provided chain can't be trusted, the certificate must be issued by the CA of the role zone
+	caChain, err := b.getCAChain(ctx, req, roleName)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("failed to get the CA chain of role %s to validate the certificate: %s", roleName, err)), nil
+	}
+	issued := false
+	for _, caPEM := range caChain {
+		ca, err := parseCertificatePEM(caPEM)
+		if err == nil && bytes.Equal(ca.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(ca) == nil {
+			issued = true
+			break
+		}
+	}
+	if !issued {
+		return logical.ErrorResponse(fmt.Sprintf("certificate is not issued by the CA of role %s", roleName)), nil
+	}
+
+	var chain []string
+	if chainPEM != "" {
+		rest := []byte(chainPEM)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			chain = append(chain, strings.TrimSpace(string(pem.EncodeToMemory(block))))
+		}
+		if len(chain) == 0 {
+			return logical.ErrorResponse("certificate_chain contains no PEM data"), nil
+		}
+	} else {
+		chain = issuerFirstChain(caChain, role.ChainOption)
+	}
+
+	serialNumber, err := getHexFormatted(cert.SerialNumber.Bytes(), ":")
+	if err != nil {
+		return nil, err
+	}
+	certPEM = strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	storagePath := certStoragePath(role, cert.Subject.CommonName, serialNumber, cert.Raw)
+	existing, err := req.Storage.Get(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		var stored VenafiCert
+		if err := existing.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+		if stored.SerialNumber == serialNumber {
+			return logical.ErrorResponse(fmt.Sprintf("certificate %s is already stored", serialNumber)), nil
+		}
+	}
+
+	entry, err := b.certificateEntry(ctx, req.Storage, storagePath, VenafiCert{
+		Certificate:      certPEM,
+		CertificateChain: strings.Join(append([]string{certPEM}, chain...), "\n"),
+		SerialNumber:     serialNumber,
+		Role:             roleName,
+		Requester:        requesterOf(req),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	if err := indexCertificate(ctx, req.Storage, storagePath, certPEM, roleName); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"certificate_uid": strings.TrimPrefix(storagePath, "certs/"),
+			"common_name":     cert.Subject.CommonName,
+			"serial_number":   serialNumber,
+			"expiration":      cert.NotAfter.UTC().Format(time.RFC3339),
+		},
+	}, nil
+}
+
+const pathVenafiCertImportHelpSyn = `
+Import a certificate issued out of band into the certificate store.
+`
+
+const pathVenafiCertImportHelpDesc = `
+Stores the certificate issued by the CA of the role zone outside of Vault, e.g. requested in TPP directly,
+under the storage path of the role, so it can be read, renewed, tidied and reported like the certificates
+issued by the role. The certificate is validated against the CA chain of the role. The private key is not imported.
+`
+
+const pathVenafiCertImportBulkHelpSyn = `
+Import many certificates issued out of band into the certificate store.
+`
+
+const pathVenafiCertImportBulkHelpDesc = `
+Imports the concatenated PEM certificates and the items of the JSON array, e.g. to migrate the inventory
+of TPP or the PKI secrets engine into this mount. Each certificate is validated and stored like by
+certs/import, the result of each one is returned with its index, the failed ones don't stop the import.
+`
@@ -0,0 +1,166 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestImportCertificate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for name, data := range map[string]map[string]interface{}{
+		"import": {"fakemode": true},
+		"source": {"fakemode": true, "no_store": true},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	issue := func(role string, cn string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": cn},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp
+	}
+	//the CA chain of the role is learned from the issued certificates
+	issue("import", "issued.venafi.example.com")
+	external := issue("source", "external.venafi.example.com")
+	serial := external.Data["serial_number"].(string)
+
+	importCert := func(certPEM string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "certs/import",
+			Storage:   storage,
+			Data:      map[string]interface{}{"role": "import", "certificate": certPEM},
+		})
+		if err != nil || resp == nil {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp
+	}
+	resp := importCert(external.Data["certificate"].(string))
+	if resp.IsError() || resp.Data["serial_number"] != serial || resp.Data["certificate_uid"] != normalizeSerial(serial) {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cert/" + normalizeSerial(serial),
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if strings.Count(resp.Data["certificate_chain"].(string), "BEGIN CERTIFICATE") < 2 {
+		t.Fatalf("Expecting the CA chain of the role stored with the certificate, got %s", resp.Data["certificate_chain"])
+	}
+
+	if resp := importCert(external.Data["certificate"].(string)); !resp.IsError() || !strings.Contains(resp.Error().Error(), "already stored") {
+		t.Fatalf("Expecting error for the certificate imported twice, got %#v", resp)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "self-signed.venafi.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := importCert(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))); !resp.IsError() {
+		t.Fatalf("Expecting error for the certificate issued by another CA, got %#v", resp)
+	}
+}
+
+func TestImportCertificatesBulk(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	for name, data := range map[string]map[string]interface{}{
+		"bulk":   {"fakemode": true},
+		"source": {"fakemode": true, "no_store": true},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	var certs []string
+	for i, role := range []string{"bulk", "source", "source"} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/" + role,
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": fmt.Sprintf("bulk-%d.venafi.example.com", i)},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		certs = append(certs, resp.Data["certificate"].(string))
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "certs/import/bulk",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"role": "bulk",
+			//the certificate issued by the role is already stored
+			"certificates": certs[0] + "\n" + certs[1],
+			"items":        []interface{}{map[string]interface{}{"certificate": certs[2]}},
+		},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if resp.Data["imported"] != 2 || resp.Data["failed"] != 1 {
+		t.Fatalf("Expecting 2 certificates imported and 1 failed, got %v", resp.Data)
+	}
+	results := resp.Data["results"].([]map[string]interface{})
+	if results[0]["error"] == nil || results[1]["serial_number"] == nil || results[2]["serial_number"] == nil {
+		t.Fatalf("Unexpected import results %v", results)
+	}
+	listed, err := storage.List(ctx, "certs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 {
+		t.Fatalf("Expecting 3 stored certificates, got %v", listed)
+	}
+}
@@ -2,7 +2,11 @@ package pki
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -26,6 +30,22 @@ func pathVenafiCertRead(b *backend) *framework.Path {
 	}
 }
 
+// purgePrivateKey stores the certificate without its private key, which is returned only by the current read
+func (b *backend) purgePrivateKey(ctx context.Context, req *logical.Request, path string, cert VenafiCert) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return logical.ErrReadOnly
+	}
+	cert.PrivateKey = ""
+	entry, err := b.certificateEntry(ctx, req.Storage, path, cert)
+	if err != nil {
+		return err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return fmt.Errorf("failed to purge private key: %s", err)
+	}
+	return nil
+}
+
 func (b *backend) pathVenafiCertRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	b.Logger().Debug("Trying to read certificate")
 	certUID := data.Get("certificate_uid").(string)
@@ -33,8 +53,13 @@ func (b *backend) pathVenafiCertRead(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse("no common name specified on certificate"), nil
 	}
 
-	path := "certs/" + certUID
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
 
+	path, err := resolveCertificatePath(ctx, req.Storage, certUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Venafi certificate: %s", err)
+	}
 	entry, err := req.Storage.Get(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read Venafi certificate: %s", err)
@@ -48,11 +73,17 @@ func (b *backend) pathVenafiCertRead(ctx context.Context, req *logical.Request,
 	b.Logger().Debug("Getting venafi certificate")
 
 	if err := entry.DecodeJSON(&cert); err != nil {
-		b.Logger().Error("error reading venafi configuration: %s", err)
+		b.Logger().Error("Error decoding stored certificate", "path", entry.Key, "error", err)
 		return nil, err
 	}
-	b.Logger().Debug("certificate is:", cert.Certificate)
-	b.Logger().Debug("chain is:", cert.CertificateChain)
+	b.Logger().Trace("Read certificate", "certificate", cert.Certificate, "certificate_chain", cert.CertificateChain)
+
+	//the private key is removed from the entry when it is read for the first time
+	if cert.PurgeKeyOnRead && cert.PrivateKey != "" {
+		if err := b.purgePrivateKey(ctx, req, path, cert); err != nil {
+			return nil, err
+		}
+	}
 
 	respData := map[string]interface{}{
 		"certificate_uid":   certUID,
@@ -60,10 +91,52 @@ func (b *backend) pathVenafiCertRead(ctx context.Context, req *logical.Request,
 		"certificate_chain": cert.CertificateChain,
 		"certificate":       cert.Certificate,
 		"private_key":       cert.PrivateKey,
+		"revocation_time":   cert.RevocationTime,
+	}
+	if cert.RenewedFrom != "" {
+		respData["renewed_from"] = cert.RenewedFrom
+	}
+	if cert.RenewedTo != "" {
+		respData["renewed_to"] = cert.RenewedTo
+	}
+	if cert.Requester != nil {
+		respData["requester"] = cert.Requester.toResponseData()
 	}
 
-	return &logical.Response{
+	resp := &logical.Response{
 		//Data: structs.New(cert).Map(),
 		Data: respData,
-	}, nil
+	}
+	if parsed, err := parseCertificatePEM(cert.Certificate); err != nil {
+		resp.AddWarning(fmt.Sprintf("Failed to parse stored certificate: %s", err))
+	} else {
+		for k, v := range certificateMetadata(parsed) {
+			respData[k] = v
+		}
+	}
+	return resp, nil
+}
+
+// certificateMetadata is the parsed content of the certificate returned with the PEM, so the clients don't need to parse it
+func certificateMetadata(cert *x509.Certificate) map[string]interface{} {
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	uris := make([]string, 0, len(cert.URIs))
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	return map[string]interface{}{
+		"subject":             cert.Subject.String(),
+		"common_name":         cert.Subject.CommonName,
+		"dns_names":           append([]string{}, cert.DNSNames...),
+		"ip_addresses":        ips,
+		"email_addresses":     append([]string{}, cert.EmailAddresses...),
+		"uri_sans":            uris,
+		"not_before":          cert.NotBefore.UTC().Format(time.RFC3339),
+		"not_after":           cert.NotAfter.UTC().Format(time.RFC3339),
+		"signature_algorithm": cert.SignatureAlgorithm.String(),
+		"issuer":              cert.Issuer.String(),
+	}
 }
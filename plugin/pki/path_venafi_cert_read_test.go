@@ -0,0 +1,56 @@
+package pki
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestCertificateReadMetadata(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/metadata",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/metadata",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "metadata.venafi.example.com", "alt_names": "alt.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	issued, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "cert/" + normalizeSerial(resp.Data["serial_number"].(string)),
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if resp.Data["common_name"] != "metadata.venafi.example.com" || resp.Data["subject"] != issued.Subject.String() ||
+		resp.Data["issuer"] != issued.Issuer.String() || resp.Data["signature_algorithm"] != issued.SignatureAlgorithm.String() ||
+		resp.Data["not_after"] != issued.NotAfter.UTC().Format(time.RFC3339) ||
+		resp.Data["not_before"] != issued.NotBefore.UTC().Format(time.RFC3339) {
+		t.Fatalf("Unexpected certificate metadata %v", resp.Data)
+	}
+	if dnsNames := resp.Data["dns_names"].([]string); !reflect.DeepEqual(dnsNames, issued.DNSNames) || !sliceContains(dnsNames, "alt.venafi.example.com") {
+		t.Fatalf("Expecting dns_names %v, got %v", issued.DNSNames, dnsNames)
+	}
+}
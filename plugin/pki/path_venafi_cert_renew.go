@@ -0,0 +1,284 @@
+package pki
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathVenafiCertRenew(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "renew/" + framework.GenericNameRegex("serial"),
+		Fields: map[string]*framework.FieldSchema{
+			"serial": {
+				Type:        framework.TypeString,
+				Description: "Serial number of the stored certificate in hyphen separated hex, or its common name if stored by CN",
+			},
+			"role": {
+				Type: framework.TypeString,
+				Description: `Role used for the renewal. Defaults to the role which issued the certificate, it is required
+for the certificates stored by the older versions`,
+			},
+			"reuse_key": {
+				Type: framework.TypeBool,
+				Description: `If set, the stored private key of the certificate is used for the renewal, otherwise a new key
+is generated by the role key settings. Defaults to "false"`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathVenafiCertRenew,
+		},
+
+		HelpSynopsis:    pathVenafiCertRenewHelpSyn,
+		HelpDescription: pathVenafiCertRenewHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiCertRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	storagePath, err := resolveCertificatePath(ctx, req.Storage, normalizeSerial(data.Get("serial").(string)))
+	if err != nil {
+		return nil, err
+	}
+	return b.renewStoredCertificate(ctx, req, storagePath, data.Get("role").(string), data.Get("reuse_key").(bool))
+}
+
+// renewStoredCertificate renews the certificate stored at storagePath by the role, which defaults to the issuing role
+func (b *backend) renewStoredCertificate(ctx context.Context, req *logical.Request, storagePath string, roleName string,
+	reuseKey bool) (*logical.Response, error) {
+
+	entry, err := req.Storage.Get(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no entry found in path %s", storagePath)), nil
+	}
+	var cert VenafiCert
+	if err := entry.DecodeJSON(&cert); err != nil {
+		return nil, err
+	}
+	if cert.RevocationTime != 0 {
+		return logical.ErrorResponse("revoked certificate can't be renewed"), nil
+	}
+
+	if roleName == "" {
+		roleName = cert.Role
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("role must be specified, the certificate was stored without it"), nil
+	}
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	parsed, err := parseCertificatePEM(cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	var privateKey crypto.Signer
+	if reuseKey {
+		privateKey, err = parseStoredPrivateKey(cert.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if privateKey == nil {
+			return logical.ErrorResponse("reuse_key requires the unencrypted private key stored with the certificate"), nil
+		}
+	}
+
+	cl, timeout, err := b.clientVenafi(ctx, req, roleName, "", "")
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	certReq, pcc, err := renewCertificate(cl, timeout, parsed, role, privateKey)
+	if err != nil {
+		b.clientCache.purge(roleName)
+		return logical.ErrorResponse(fmt.Sprintf("failed to renew certificate: %s", err)), nil
+	}
+	defer zeroizePrivateKey(certReq.PrivateKey)
+
+	renewed, err := parseCertificatePEM(pcc.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := getHexFormatted(renewed.SerialNumber.Bytes(), ":")
+	if err != nil {
+		return nil, err
+	}
+	if role.PreferredChain != "" {
+		pcc.Chain = b.selectPreferredChain(pcc.Chain, role.PreferredChain, role.ChainOption)
+	}
+	chain := strings.Join(append([]string{pcc.Certificate}, pcc.Chain...), "\n")
+	if err := pcc.AddPrivateKey(certReq.PrivateKey, nil); err != nil {
+		return nil, err
+	}
+
+	renewedCert := VenafiCert{
+		Certificate:      pcc.Certificate,
+		CertificateChain: chain,
+		SerialNumber:     serialNumber,
+		Role:             roleName,
+		RenewedFrom:      cert.SerialNumber,
+		Requester:        requesterOf(req),
+	}
+	if role.StorePrivateKey {
+		renewedCert.PrivateKey = pcc.PrivateKey
+		renewedCert.PurgeKeyOnRead = role.PurgePrivateKeyOnRead
+	}
+	renewedPath := certStoragePath(role, renewed.Subject.CommonName, serialNumber, renewed.Raw)
+	if !role.NoStore {
+		renewedEntry, err := b.certificateEntry(ctx, req.Storage, renewedPath, renewedCert)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Put(ctx, renewedEntry); err != nil {
+			return nil, err
+		}
+		if err := indexCertificate(ctx, req.Storage, renewedPath, pcc.Certificate, roleName); err != nil {
+			return nil, err
+		}
+	}
+	//the certificate stored by CN is replaced by the renewed one
+	if role.NoStore || renewedPath != storagePath {
+		cert.RenewedTo = serialNumber
+		entry, err = b.certificateEntry(ctx, req.Storage, storagePath, cert)
+		if err != nil {
+			return nil, err
+		}
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	b.notifyWebhooks(ctx, req.Storage, webhookPayload{
+		Event:        webhookEventRenewed,
+		Role:         roleName,
+		CommonName:   renewed.Subject.CommonName,
+		SerialNumber: serialNumber,
+		NotAfter:     renewed.NotAfter.UTC().Format(time.RFC3339),
+	})
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"common_name":       renewed.Subject.CommonName,
+			"serial_number":     serialNumber,
+			"certificate_chain": chain,
+			"certificate":       pcc.Certificate,
+			"private_key":       pcc.PrivateKey,
+			"renewed_from":      cert.SerialNumber,
+		},
+	}
+	resp.AddWarning("Read access to this endpoint should be controlled via ACLs as it will return the connection private key as it is.")
+	return resp, nil
+}
+
+// certStoragePath returns the storage path of the certificate by the role store_by setting
+func certStoragePath(role *roleEntry, commonName string, serialNumber string, raw []byte) string {
+	prefix := "certs/"
+	if role.StoragePrefix != "" {
+		prefix += role.StoragePrefix + "/"
+	}
+	switch role.StoreBy {
+	case storeByCNString:
+		return prefix + commonName
+	case storeByHashString:
+		return prefix + certFingerprint(raw)
+	}
+	return prefix + normalizeSerial(serialNumber)
+}
+
+// certFingerprint returns the lower case hex SHA-256 fingerprint of the DER encoded certificate
+func certFingerprint(raw []byte) string {
+	fingerprint := sha256.Sum256(raw)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// renewCertificate renews the certificate at Venafi with a CSR for the same subject and SANs. The CSR is signed by
+// privateKey if it is set, otherwise a new key is generated by the role key settings.
+func renewCertificate(cl endpoint.Connector, timeout time.Duration, cert *x509.Certificate, role *roleEntry,
+	privateKey crypto.Signer) (*certificate.Request, *certificate.PEMCollection, error) {
+
+	certReq := &certificate.Request{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		IPAddresses:    cert.IPAddresses,
+		EmailAddresses: cert.EmailAddresses,
+		URIs:           cert.URIs,
+		CsrOrigin:      certificate.LocalGeneratedCSR,
+		PrivateKey:     privateKey,
+		ChainOption:    certificate.ChainOptionRootLast,
+	}
+	if role.ChainOption == "first" {
+		certReq.ChainOption = certificate.ChainOptionRootFirst
+	}
+	if privateKey == nil {
+		keyType, ok := role.roleKeyType()
+		if !ok {
+			return nil, nil, fmt.Errorf("can't determine key algorithm for %s", role.KeyType)
+		}
+		certReq.KeyType = keyType
+		certReq.KeyLength = role.KeyBits
+		if keyType == certificate.KeyTypeECDSA {
+			if err := certReq.KeyCurve.Set(role.KeyCurve); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err := cl.GenerateRequest(nil, certReq); err != nil {
+		return nil, nil, err
+	}
+	//the key size is silently replaced by vcert if the zone policy doesn't allow the requested one
+	if privateKey == nil && role.KeyType == "rsa" && role.KeyBits > 0 && certReq.KeyLength != role.KeyBits {
+		return nil, nil, zoneKeySizeError(cl, role.KeyBits)
+	}
+
+	thumbprint := sha1.Sum(cert.Raw)
+	requestID, err := cl.RenewCertificate(&certificate.RenewalRequest{
+		Thumbprint:         strings.ToUpper(hex.EncodeToString(thumbprint[:])),
+		CertificateRequest: certReq,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	pcc, err := cl.RetrieveCertificate(&certificate.Request{
+		PickupID:    requestID,
+		Timeout:     timeout,
+		ChainOption: certReq.ChainOption,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return certReq, pcc, nil
+}
+
+const (
+	pathVenafiCertRenewHelpSyn = `
+Renew Venafi certificate
+`
+	pathVenafiCertRenewHelpDesc = `
+Renew the stored certificate at Venafi Platform using the connection of the role which
+issued it. The renewed certificate has the same subject and SANs. It is stored by the role
+settings and linked to the previous certificate by renewed_from and renewed_to.
+`
+)
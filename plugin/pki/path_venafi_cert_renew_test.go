@@ -0,0 +1,105 @@
+package pki
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestRenewStoredCertificate(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/renew",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/renew",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "renew.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	serial := normalizeSerial(resp.Data["serial_number"].(string))
+
+	entry, err := storage.Get(ctx, "certs/"+serial)
+	if err != nil || entry == nil {
+		t.Fatalf("Expecting stored certificate, got err: %v", err)
+	}
+	var cert VenafiCert
+	if err := entry.DecodeJSON(&cert); err != nil {
+		t.Fatal(err)
+	}
+	if cert.Role != "renew" {
+		t.Fatalf("Expecting the role to be stored with the certificate, got %q", cert.Role)
+	}
+
+	for _, c := range []struct {
+		data  map[string]interface{}
+		error string
+	}{
+		{map[string]interface{}{"serial": "01-02-03"}, "no entry found"},
+		{map[string]interface{}{"serial": serial, "reuse_key": true}, "reuse_key requires"},
+		//renewal isn't supported by the fake connector
+		{map[string]interface{}{"serial": serial}, "not supported in -test-mode"},
+	} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "renew/" + c.data["serial"].(string),
+			Storage:   storage,
+			Data:      c.data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Data["error"].(string), c.error) {
+			t.Fatalf("Expecting error %q for %v, got %#v", c.error, c.data, resp)
+		}
+	}
+}
+
+func TestRenewBefore(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	//the fake connector issues certificates valid for 90 days, longer renew_before is ignored
+	for renewBefore, margin := range map[string]time.Duration{"240h": 240 * time.Hour, "2400h": 0} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/renew-before",
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "generate_lease": true, "renew_before": renewBefore},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/renew-before",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": "renew-before.venafi.example.com"},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		cert, err := parseCertificatePEM(resp.Data["certificate"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := time.Until(cert.NotAfter) - margin
+		if diff := resp.Secret.TTL - expected; diff > time.Minute || diff < -time.Minute {
+			t.Fatalf("Expecting lease TTL %s with renew_before %s, got %s", expected, renewBefore, resp.Secret.TTL)
+		}
+	}
+}
@@ -2,6 +2,16 @@ package pki
 
 import (
 	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/hashicorp/vault/helper/consts"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
@@ -16,18 +26,163 @@ func pathVenafiCertRevoke(b *backend) *framework.Path {
 			},
 			"certificate_uid": {
 				Type:        framework.TypeString,
-				Description: "Common name for created certificate",
+				Description: "Common name or serial number of the stored certificate",
+			},
+			"serial_number": {
+				Type:        framework.TypeString,
+				Description: "Serial number of the stored certificate, in hyphen or colon separated hex",
+			},
+			"certificate": {
+				Type:        framework.TypeString,
+				Description: "PEM-format certificate to revoke. It doesn't have to be stored in Vault",
+			},
+			"reason": {
+				Type: framework.TypeString,
+				Description: `Revocation reason: none, key-compromise, ca-compromise, affiliation-changed, superseded
+or cessation-of-operation`,
 			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.UpdateOperation: b.venafiCertRevoke,
+			logical.UpdateOperation: instrumented("revoke", b.venafiCertRevoke),
 		},
 
-		HelpSynopsis:    pathConfigRootHelpSyn,
-		HelpDescription: pathConfigRootHelpDesc,
+		HelpSynopsis:    pathVenafiCertRevokeHelpSyn,
+		HelpDescription: pathVenafiCertRevokeHelpDesc,
 	}
 }
 
 func (b *backend) venafiCertRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	return nil, nil
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	roleName := d.Get("role").(string)
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	certUID := d.Get("certificate_uid").(string)
+	if serial := d.Get("serial_number").(string); serial != "" {
+		certUID = normalizeSerial(serial)
+	}
+	certPEM := d.Get("certificate").(string)
+
+	var cert VenafiCert
+	switch {
+	case certUID != "":
+	case certPEM != "":
+		parsed, err := parseCertificatePEM(certPEM)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		serial, err := getHexFormatted(parsed.SerialNumber.Bytes(), ":")
+		if err != nil {
+			return nil, err
+		}
+		certUID = normalizeSerial(serial)
+	default:
+		return logical.ErrorResponse("certificate_uid, serial_number or certificate must be specified"), nil
+	}
+	storagePath, err := resolveCertificatePath(ctx, req.Storage, certUID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := req.Storage.Get(ctx, storagePath)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		if certPEM == "" {
+			certPEM = cert.Certificate
+		}
+	} else if certPEM == "" {
+		return logical.ErrorResponse(fmt.Sprintf("no entry found in path %s", storagePath)), nil
+	}
+
+	if cert.RevocationTime == 0 {
+		err = b.revokeCertificate(ctx, req, roleName, certPEM, d.Get("reason").(string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cert.RevocationTime = time.Now().Unix()
+
+		if entry != nil {
+			entry, err = b.certificateEntry(ctx, req.Storage, storagePath, cert)
+			if err != nil {
+				return nil, err
+			}
+			if err := req.Storage.Put(ctx, entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"revocation_time":         cert.RevocationTime,
+			"revocation_time_rfc3339": time.Unix(cert.RevocationTime, 0).UTC().Format(time.RFC3339),
+		},
+	}, nil
 }
+
+// revokeCertificate revokes the certificate at the Venafi endpoint of the role
+func (b *backend) revokeCertificate(ctx context.Context, req *logical.Request, roleName string, certPEM string, reason string) error {
+	parsed, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	thumbprint := sha1.Sum(parsed.Raw)
+
+	cl, _, err := b.ClientVenafi(ctx, req.Storage, nil, req, roleName)
+	if err != nil {
+		return err
+	}
+	b.Logger().Debug("Revoking certificate", "serial_number", parsed.SerialNumber, "role", roleName)
+	err = cl.RevokeCertificate(&certificate.RevocationRequest{
+		Thumbprint: strings.ToUpper(hex.EncodeToString(thumbprint[:])),
+		Reason:     reason,
+		Comments:   "revoked by " + utilityName,
+	})
+	if err != nil {
+		return err
+	}
+	serialNumber, err := getHexFormatted(parsed.SerialNumber.Bytes(), ":")
+	if err != nil {
+		return err
+	}
+	b.notifyWebhooks(ctx, req.Storage, webhookPayload{
+		Event:        webhookEventRevoked,
+		Role:         roleName,
+		CommonName:   parsed.Subject.CommonName,
+		SerialNumber: serialNumber,
+		NotAfter:     parsed.NotAfter.UTC().Format(time.RFC3339),
+	})
+	return nil
+}
+
+func parseCertificatePEM(certPEM string) (*x509.Certificate, error) {
+	pemBlock, _ := pem.Decode([]byte(certPEM))
+	if pemBlock == nil {
+		return nil, fmt.Errorf("certificate contains no PEM data")
+	}
+	return x509.ParseCertificate(pemBlock.Bytes)
+}
+
+const (
+	pathVenafiCertRevokeHelpSyn = `
+Revoke Venafi certificate
+`
+	pathVenafiCertRevokeHelpDesc = `
+Revoke the certificate at Venafi Platform using the role connection. The certificate
+is found by certificate_uid or serial_number in Vault storage, or it can be passed in
+certificate. The stored certificate is marked with the revocation time.
+`
+)
@@ -0,0 +1,209 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+func pathVenafiCRL(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "crl/" + framework.GenericNameRegex("role") + framework.OptionalParamRegex("format"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The role which issuing CA CRL should be returned`,
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Description: `Set to "pem" to get the PEM encoded CRL, DER is returned otherwise`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiCRLRead,
+		},
+
+		HelpSynopsis:    pathVenafiCRLHelpSyn,
+		HelpDescription: pathVenafiCRLHelpDesc,
+	}
+}
+
+type cachedCRL struct {
+	der     []byte
+	expires time.Time
+}
+
+// crlCache keeps the fetched CRLs of the roles
+type crlCache struct {
+	sync.Mutex
+	crls map[string]cachedCRL
+}
+
+func (c *crlCache) get(roleName string) []byte {
+	c.Lock()
+	defer c.Unlock()
+	cached, ok := c.crls[roleName]
+	if !ok || time.Now().After(cached.expires) {
+		return nil
+	}
+	return cached.der
+}
+
+func (c *crlCache) put(roleName string, der []byte, expires time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if c.crls == nil {
+		c.crls = make(map[string]cachedCRL)
+	}
+	c.crls[roleName] = cachedCRL{der: der, expires: expires}
+}
+
+func (c *crlCache) purge(roleName string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.crls, roleName)
+}
+
+func (b *backend) pathVenafiCRLRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+	format := data.Get("format").(string)
+	if format != "" && format != "pem" {
+		return logical.ErrorResponse(fmt.Sprintf("unknown CRL format %s", format)), nil
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+
+	der := b.crls.get(roleName)
+	if der == nil {
+		der, err = b.fetchCRL(ctx, req.Storage, roleName, role)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	contentType := "application/pkix-crl"
+	body := der
+	if format == "pem" {
+		contentType = "application/x-pem-file"
+		body = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: contentType,
+			logical.HTTPRawBody:     body,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// fetchCRL downloads the CRL of the role issuing CA from crl_url or from the distribution point of the certificates
+// of the role zone, retrieved from Venafi with the CA chain or issued for the role
+func (b *backend) fetchCRL(ctx context.Context, s logical.Storage, roleName string, role *roleEntry) ([]byte, error) {
+	var urls []string
+	if role.CRLURL != "" {
+		urls = []string{role.CRLURL}
+	} else {
+		info, err := b.getIssuerInfo(ctx, s, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			urls = info.CRLDistributionPoints
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("CRL distribution point of role %s is not known yet, it is retrieved from Venafi periodically "+
+			"or learned from issued certificates, or set crl_url", roleName)
+	}
+	//the path doesn't require authentication, so the failed downloads aren't repeated for every request
+	if err := b.crlFailures.get(roleName); err != nil {
+		return nil, err
+	}
+
+	client, err := b.getCAHTTPClient(role)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, url := range urls {
+		der, err := downloadCRL(ctx, client, url)
+		if err != nil {
+			b.Logger().Warn("Failed to download CRL", "url", url, "error", err)
+			lastErr = err
+			continue
+		}
+		//CRL can be PEM encoded on the distribution point
+		if block, _ := pem.Decode(der); block != nil {
+			der = block.Bytes
+		}
+		crl, err := x509.ParseDERCRL(der)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to parse CRL from %s: %s", url, err)
+			continue
+		}
+
+		expires := time.Now().Add(role.crlCacheTTL())
+		if next := crl.TBSCertList.NextUpdate; !next.IsZero() && next.Before(expires) {
+			expires = next
+		}
+		b.crls.put(roleName, der, expires)
+		return der, nil
+	}
+	b.crlFailures.put(roleName, lastErr)
+	return nil, lastErr
+}
+
+func downloadCRL(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readLimited(resp.Body, maxCRLSize)
+}
+
+func (r *roleEntry) crlCacheTTL() time.Duration {
+	if r.CRLCacheTTL == 0 {
+		return defaultCRLCacheTTL
+	}
+	return r.CRLCacheTTL
+}
+
+const (
+	defaultCRLCacheTTL = time.Hour
+	//CRLs of large CAs can have tens of megabytes
+	maxCRLSize = 64 << 20
+
+	pathVenafiCRLHelpSyn = `
+Fetch the CRL of the role issuing CA
+`
+	pathVenafiCRLHelpDesc = `
+This path returns the CRL of the CA which issues certificates of the role, in DER
+or in PEM with "crl/<role>/pem". The CRL is downloaded from crl_url of the role or
+from the CRL distribution point of the role zone certificates, which is retrieved
+from Venafi with the CA chain and updated from the certificates issued for the role.
+It is cached for crl_cache_ttl or until its next update, a failed download is retried
+after 5 minutes. It doesn't require authentication.
+`
+)
@@ -2,14 +2,26 @@ package pki
 
 import (
 	"context"
+	"crypto/x509"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
+const defaultExpiringWithin = 720 * time.Hour
+
 func pathVenafiFetchListCerts(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: "certs/?$",
+		Fields: map[string]*framework.FieldSchema{
+			"include_key_info": {
+				Type:        framework.TypeBool,
+				Description: "Return the common name, serial number, expiration, revocation state and role of each certificate in key_info",
+			},
+		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ListOperation: b.pathVenafiFetchCertList,
@@ -20,13 +32,143 @@ func pathVenafiFetchListCerts(b *backend) *framework.Path {
 	}
 }
 
+func pathVenafiFetchExpiringCerts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "certs/expiring",
+		Fields: map[string]*framework.FieldSchema{
+			"within": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Certificates expiring within this duration are listed, including the expired ones",
+				Default:     int(defaultExpiringWithin / time.Second),
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiFetchExpiringCerts,
+		},
+
+		HelpSynopsis:    pathVenafiFetchExpiringHelpSyn,
+		HelpDescription: pathVenafiFetchExpiringHelpDesc,
+	}
+}
+
+// pathVenafiFetchExpiringCerts lists the stored certificates which expire within the window ordered by expiration.
+// Revoked and renewed certificates are not listed.
+func (b *backend) pathVenafiFetchExpiringCerts(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	deadline := time.Now().Add(time.Duration(data.Get("within").(int)) * time.Second)
+	entries, err := listStoredCertificates(ctx, req.Storage, "")
+	if err != nil {
+		return nil, err
+	}
+
+	expirations := make(map[string]time.Time)
+	keyInfo := make(map[string]interface{})
+	for _, uid := range entries {
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		if cert.RevocationTime != 0 || cert.RenewedTo != "" {
+			continue
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			b.Logger().Warn("Failed to parse stored certificate", "path", "certs/"+uid, "error", err)
+			continue
+		}
+		if parsed.NotAfter.After(deadline) {
+			continue
+		}
+		expirations[uid] = parsed.NotAfter
+		keyInfo[uid] = storedCertificateInfo(cert, parsed)
+	}
+
+	keys := make([]string, 0, len(expirations))
+	for uid := range expirations {
+		keys = append(keys, uid)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return expirations[keys[i]].Before(expirations[keys[j]])
+	})
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
 func (b *backend) pathVenafiFetchCertList(ctx context.Context, req *logical.Request, data *framework.FieldData) (response *logical.Response, retErr error) {
 	entries, err := req.Storage.List(ctx, "certs/")
 	if err != nil {
 		return nil, err
 	}
+	if !data.Get("include_key_info").(bool) {
+		return logical.ListResponse(entries), nil
+	}
+
+	keyInfo := make(map[string]interface{})
+	for _, uid := range entries {
+		//the certificates of the roles with storage_prefix are listed by certs/<prefix>/
+		if strings.HasSuffix(uid, "/") {
+			continue
+		}
+		entry, err := req.Storage.Get(ctx, "certs/"+uid)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		var cert VenafiCert
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		parsed, err := parseCertificatePEM(cert.Certificate)
+		if err != nil {
+			b.Logger().Warn("Failed to parse stored certificate", "path", "certs/"+uid, "error", err)
+			parsed = nil
+		}
+		keyInfo[uid] = storedCertificateInfo(cert, parsed)
+	}
+	return logical.ListResponseWithInfo(entries, keyInfo), nil
+}
+
+// listStoredCertificates returns the uids of all stored certificates including the ones under the role storage_prefix
+func listStoredCertificates(ctx context.Context, s logical.Storage, prefix string) ([]string, error) {
+	entries, err := s.List(ctx, "certs/"+prefix)
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, "/") {
+			uids = append(uids, prefix+entry)
+			continue
+		}
+		nested, err := listStoredCertificates(ctx, s, prefix+entry)
+		if err != nil {
+			return nil, err
+		}
+		uids = append(uids, nested...)
+	}
+	return uids, nil
+}
 
-	return logical.ListResponse(entries), nil
+// storedCertificateInfo is the key_info of the stored certificate in the list responses
+func storedCertificateInfo(cert VenafiCert, parsed *x509.Certificate) map[string]interface{} {
+	info := map[string]interface{}{
+		"serial_number": cert.SerialNumber,
+		"role":          cert.Role,
+		"revoked":       cert.RevocationTime != 0,
+	}
+	if parsed != nil {
+		info["common_name"] = parsed.Subject.CommonName
+		info["expiration"] = parsed.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return info
 }
 
 const pathVenafiFetchHelpSyn = `
@@ -34,5 +176,15 @@ This allows certificates to be fetched.
 `
 
 const pathVenafiFetchHelpDesc = `
-This allows certificates to be fetched.
+This allows certificates to be fetched. With include_key_info the common name, serial number,
+expiration, revocation state and role of the certificates are returned in key_info.
+`
+
+const pathVenafiFetchExpiringHelpSyn = `
+List the stored certificates which expire soon.
+`
+
+const pathVenafiFetchExpiringHelpDesc = `
+List the stored certificates which expire within the duration, default 720h, with their
+common name, serial number, role and expiration time, so they can be renewed or audited.
 `
@@ -0,0 +1,109 @@
+package pki
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestExpiringCertificates(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/expiring",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/expiring",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "expiring.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	uid := normalizeSerial(resp.Data["serial_number"].(string))
+
+	//the fake connector issues certificates valid for 90 days
+	for within, expected := range map[string]bool{"720h": false, "2400h": true} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "certs/expiring",
+			Storage:   storage,
+			Data:      map[string]interface{}{"within": within},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		keys, _ := resp.Data["keys"].([]string)
+		if (len(keys) == 1 && keys[0] == uid) != expected {
+			t.Fatalf("Unexpected expiring certificates within %s: %v", within, keys)
+		}
+		if expected {
+			info := resp.Data["key_info"].(map[string]interface{})[uid].(map[string]interface{})
+			if info["role"] != "expiring" || info["common_name"] != "expiring.venafi.example.com" {
+				t.Fatalf("Unexpected certificate info %v", info)
+			}
+		}
+	}
+}
+
+func TestListCertificatesKeyInfo(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/key-info",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/key-info",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "key-info.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	serial := resp.Data["serial_number"].(string)
+	uid := normalizeSerial(serial)
+
+	for _, includeKeyInfo := range []bool{false, true} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "certs/",
+			Storage:   storage,
+			Data:      map[string]interface{}{"include_key_info": includeKeyInfo},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		if keys := resp.Data["keys"].([]string); len(keys) != 1 || keys[0] != uid {
+			t.Fatalf("Expecting certificate %s to be listed, got %v", uid, keys)
+		}
+		keyInfo, ok := resp.Data["key_info"].(map[string]interface{})
+		if ok != includeKeyInfo {
+			t.Fatalf("Expecting key_info only with include_key_info, got %v", resp.Data)
+		}
+		if !includeKeyInfo {
+			continue
+		}
+		info := keyInfo[uid].(map[string]interface{})
+		if info["common_name"] != "key-info.venafi.example.com" || info["serial_number"] != serial ||
+			info["role"] != "key-info" || info["revoked"] != false || info["expiration"] == nil {
+			t.Fatalf("Unexpected certificate info %v", info)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// privateKeyEntry is the private key of a certificate issued by a role with separate_private_key, stored until it is read
+type privateKeyEntry struct {
+	PrivateKey   string `json:"private_key"`
+	SerialNumber string `json:"serial_number"`
+}
+
+func pathVenafiKeyRead(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/" + framework.GenericNameRegex("serial"),
+		Fields: map[string]*framework.FieldSchema{
+			"serial": {
+				Type:        framework.TypeString,
+				Description: "Serial number of the certificate, in hyphen or colon separated format",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiKeyRead,
+		},
+
+		HelpSynopsis:    pathVenafiKeyReadHelpSyn,
+		HelpDescription: pathVenafiKeyReadHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiKeyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	//the key is deleted after the read, which the performance standby can't do
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil, logical.ErrReadOnly
+	}
+
+	path := "keys/" + normalizeSerial(data.Get("serial").(string))
+	//the lock makes sure that concurrent reads don't both return the key
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+
+	entry, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %s", err)
+	}
+	if entry == nil {
+		return logical.ErrorResponse("no private key found, it was already read or the certificate wasn't issued with separate_private_key"), nil
+	}
+	var key privateKeyEntry
+	if err := entry.DecodeJSON(&key); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, path); err != nil {
+		return nil, fmt.Errorf("failed to delete private key: %s", err)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"serial_number": key.SerialNumber,
+			"private_key":   key.PrivateKey,
+		},
+	}, nil
+}
+
+const (
+	pathVenafiKeyReadHelpSyn  = `Read the private key of a certificate once.`
+	pathVenafiKeyReadHelpDesc = `
+This path returns the private key of a certificate issued by a role with
+separate_private_key and deletes it from the storage, so it can be read only once.
+Access to it can be granted by a different policy than to the issue path.
+`
+)
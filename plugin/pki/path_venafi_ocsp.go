@@ -0,0 +1,211 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+	"golang.org/x/crypto/ocsp"
+)
+
+func pathVenafiOCSP(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "ocsp/" + framework.GenericNameRegex("role"),
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The role which issued the certificate`,
+			},
+			"request": {
+				Type:        framework.TypeString,
+				Description: `Base64 encoded DER OCSP request`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathVenafiOCSP,
+			logical.UpdateOperation: b.pathVenafiOCSP,
+		},
+
+		HelpSynopsis:    pathVenafiOCSPHelpSyn,
+		HelpDescription: pathVenafiOCSPHelpDesc,
+	}
+}
+
+// pathVenafiOCSPGet is the RFC 6960 GET form of the responder, the request is base64 encoded in the last path segment
+func pathVenafiOCSPGet(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "ocsp/" + framework.GenericNameRegex("role") + "/(?P<request>.+)",
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeString,
+				Description: `The role which issued the certificate`,
+			},
+			"request": {
+				Type:        framework.TypeString,
+				Description: `Base64 encoded DER OCSP request`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathVenafiOCSPGet,
+		},
+
+		HelpSynopsis:    pathVenafiOCSPHelpSyn,
+		HelpDescription: pathVenafiOCSPHelpDesc,
+	}
+}
+
+// ocspError is an OCSP request failure which is returned to the OCSP clients as an OCSP response status
+type ocspError struct {
+	status []byte
+	msg    string
+}
+
+func (e *ocspError) Error() string {
+	return e.msg
+}
+
+func (b *backend) pathVenafiOCSP(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ocspRequest, err := base64.StdEncoding.DecodeString(data.Get("request").(string))
+	if err != nil || len(ocspRequest) == 0 {
+		return logical.ErrorResponse("request must contain base64 encoded OCSP request"), nil
+	}
+	ocspResponse, err := b.forwardOCSP(ctx, req, data.Get("role").(string), ocspRequest)
+	if _, ok := err.(*ocspError); ok {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ocspHTTPResponse(ocspResponse), nil
+}
+
+// pathVenafiOCSPGet returns the failures as OCSP responses with an error status, which the OCSP clients understand
+func (b *backend) pathVenafiOCSPGet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ocspRequest, err := decodeOCSPGetRequest(data.Get("request").(string))
+	if err != nil {
+		b.Logger().Debug("Malformed OCSP request", "error", err)
+		return ocspHTTPResponse(ocsp.MalformedRequestErrorResponse), nil
+	}
+	ocspResponse, err := b.forwardOCSP(ctx, req, data.Get("role").(string), ocspRequest)
+	if ocspErr, ok := err.(*ocspError); ok {
+		b.Logger().Debug("OCSP request failed", "role", data.Get("role").(string), "error", err)
+		return ocspHTTPResponse(ocspErr.status), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ocspHTTPResponse(ocspResponse), nil
+}
+
+// decodeOCSPGetRequest decodes the request of the GET form, which the clients encode with the standard base64 alphabet,
+// some of them without the padding or with the URL safe alphabet
+func decodeOCSPGetRequest(encoded string) ([]byte, error) {
+	encoded = strings.TrimRight(encoded, "=")
+	decode := base64.RawStdEncoding.DecodeString
+	if strings.ContainsAny(encoded, "-_") {
+		decode = base64.RawURLEncoding.DecodeString
+	}
+	ocspRequest, err := decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ocsp.ParseRequest(ocspRequest); err != nil {
+		return nil, err
+	}
+	return ocspRequest, nil
+}
+
+// forwardOCSP sends the DER OCSP request to the responders of the CA which issues the certificates of the role
+func (b *backend) forwardOCSP(ctx context.Context, req *logical.Request, roleName string, ocspRequest []byte) ([]byte, error) {
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, &ocspError{ocsp.UnauthorizedErrorResponse, fmt.Sprintf("unknown role: %s", roleName)}
+	}
+
+	info, err := b.getIssuerInfo(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || len(info.OCSPServers) == 0 {
+		return nil, &ocspError{ocsp.UnauthorizedErrorResponse,
+			fmt.Sprintf("OCSP responder of role %s is not known yet, it is discovered from issued certificates", roleName)}
+	}
+
+	//the path doesn't require authentication, so the failed responders aren't asked again for every request
+	if err := b.ocspFailures.get(roleName); err != nil {
+		return nil, &ocspError{ocsp.TryLaterErrorResponse, err.Error()}
+	}
+
+	client, err := b.getCAHTTPClient(role)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, url := range info.OCSPServers {
+		ocspResponse, err := forwardOCSPRequest(ctx, client, url, ocspRequest)
+		if err != nil {
+			b.Logger().Warn("OCSP responder failed", "url", url, "error", err)
+			lastErr = err
+			continue
+		}
+		return ocspResponse, nil
+	}
+	b.ocspFailures.put(roleName, lastErr)
+	return nil, &ocspError{ocsp.TryLaterErrorResponse, lastErr.Error()}
+}
+
+func ocspHTTPResponse(ocspResponse []byte) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "application/ocsp-response",
+			logical.HTTPRawBody:     ocspResponse,
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}
+}
+
+func forwardOCSPRequest(ctx context.Context, client *http.Client, url string, ocspRequest []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(ocspRequest))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return readLimited(resp.Body, maxOCSPResponseSize)
+}
+
+const (
+	maxOCSPResponseSize = 1 << 20
+
+	pathVenafiOCSPHelpSyn = `
+Check certificate status with the issuing CA OCSP responder
+`
+	pathVenafiOCSPHelpDesc = `
+This path forwards the base64 encoded OCSP request to the OCSP responder of the CA
+which issues certificates of the role and returns the DER OCSP response as it is.
+The responder is discovered from the Authority Information Access extension of the
+certificates issued for the role. It doesn't require authentication.
+
+OCSP clients can use the RFC 6960 GET form ocsp/<role>/<base64 DER request>, the
+failures are returned to them as OCSP responses with an error status. The request
+can be posted to ocsp/<role> in the "request" field of a JSON body. Vault parses
+all POST bodies as JSON, so the DER application/ocsp-request POST of RFC 6960 is
+rejected by Vault before it reaches this plugin.
+`
+)
@@ -0,0 +1,483 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	venafiPolicyPrefix = "venafi-policy/"
+
+	defaultPolicyRefreshInterval = time.Hour
+	//the policy is reported as stale after this number of failed refreshes in a row
+	policyStaleFailures = 3
+)
+
+// venafiPolicy is the zone policy synced from Venafi, the roles bound to it by venafi_policy are checked against it
+type venafiPolicy struct {
+	//role which connection settings are used to read the zone
+	Role string `json:"role"`
+	Zone string `json:"zone"`
+
+	SubjectCNRegexes         []string                 `json:"subject_cn_regexes"`
+	SubjectORegexes          []string                 `json:"subject_o_regexes"`
+	SubjectOURegexes         []string                 `json:"subject_ou_regexes"`
+	SubjectSTRegexes         []string                 `json:"subject_st_regexes"`
+	SubjectLRegexes          []string                 `json:"subject_l_regexes"`
+	SubjectCRegexes          []string                 `json:"subject_c_regexes"`
+	AllowedKeyConfigurations []policyKeyConfiguration `json:"allowed_key_configurations"`
+	DNSSANRegexes            []string                 `json:"dns_san_regexes"`
+	IPSANRegexes             []string                 `json:"ip_san_regexes"`
+	EmailSANRegexes          []string                 `json:"email_san_regexes"`
+	URISANRegexes            []string                 `json:"uri_san_regexes"`
+	UPNSANRegexes            []string                 `json:"upn_san_regexes"`
+	AllowWildcards           bool                     `json:"allow_wildcards"`
+	AllowKeyReuse            bool                     `json:"allow_key_reuse"`
+
+	//default subject values of the zone
+	Organization       string   `json:"organization"`
+	OrganizationalUnit []string `json:"organizational_unit"`
+	Country            string   `json:"country"`
+	Province           string   `json:"province"`
+	Locality           string   `json:"locality"`
+
+	RefreshInterval    time.Duration `json:"refresh_interval"`
+	LastRefreshed      time.Time     `json:"last_refreshed"`
+	LastRefreshAttempt time.Time     `json:"last_refresh_attempt"`
+	RefreshFailures    int           `json:"refresh_failures"`
+	LastRefreshError   string        `json:"last_refresh_error"`
+}
+
+func (p *venafiPolicy) refreshInterval() time.Duration {
+	if p.RefreshInterval == 0 {
+		return defaultPolicyRefreshInterval
+	}
+	return p.RefreshInterval
+}
+
+func (p *venafiPolicy) stale() bool {
+	return p.RefreshFailures >= policyStaleFailures
+}
+
+// staleWarning returns the warning about the policy which failed to refresh repeatedly or an empty string
+func (p *venafiPolicy) staleWarning(name string) string {
+	if !p.stale() {
+		return ""
+	}
+	return fmt.Sprintf("Policy %s failed to refresh %d times in a row and may be stale, it was synced at %s: %s", name,
+		p.RefreshFailures, formatStatusTime(p.LastRefreshed), p.LastRefreshError)
+}
+
+// policyKeyConfiguration is an allowed key type with its sizes or curves, named like the role key settings
+type policyKeyConfiguration struct {
+	KeyType   string   `json:"key_type"`
+	KeyBits   []int    `json:"key_bits,omitempty"`
+	KeyCurves []string `json:"key_curves,omitempty"`
+}
+
+func newVenafiPolicy(roleName string, zone string, zoneConfig *endpoint.ZoneConfiguration) *venafiPolicy {
+	policy := &venafiPolicy{
+		Role:               roleName,
+		Zone:               zone,
+		SubjectCNRegexes:   zoneConfig.SubjectCNRegexes,
+		SubjectORegexes:    zoneConfig.SubjectORegexes,
+		SubjectOURegexes:   zoneConfig.SubjectOURegexes,
+		SubjectSTRegexes:   zoneConfig.SubjectSTRegexes,
+		SubjectLRegexes:    zoneConfig.SubjectLRegexes,
+		SubjectCRegexes:    zoneConfig.SubjectCRegexes,
+		DNSSANRegexes:      zoneConfig.DnsSanRegExs,
+		IPSANRegexes:       zoneConfig.IpSanRegExs,
+		EmailSANRegexes:    zoneConfig.EmailSanRegExs,
+		URISANRegexes:      zoneConfig.UriSanRegExs,
+		UPNSANRegexes:      zoneConfig.UpnSanRegExs,
+		AllowWildcards:     zoneConfig.AllowWildcards,
+		AllowKeyReuse:      zoneConfig.AllowKeyReuse,
+		Organization:       zoneConfig.Organization,
+		OrganizationalUnit: zoneConfig.OrganizationalUnit,
+		Country:            zoneConfig.Country,
+		Province:           zoneConfig.Province,
+		Locality:           zoneConfig.Locality,
+		LastRefreshed:      time.Now(),
+	}
+	for _, kc := range zoneConfig.AllowedKeyConfigurations {
+		switch kc.KeyType {
+		case certificate.KeyTypeRSA:
+			policy.AllowedKeyConfigurations = append(policy.AllowedKeyConfigurations,
+				policyKeyConfiguration{KeyType: "rsa", KeyBits: kc.KeySizes})
+		case certificate.KeyTypeECDSA:
+			var curves []string
+			for _, curve := range kc.KeyCurves {
+				curves = append(curves, curve.String())
+			}
+			policy.AllowedKeyConfigurations = append(policy.AllowedKeyConfigurations,
+				policyKeyConfiguration{KeyType: "ec", KeyCurves: curves})
+		}
+	}
+	return policy
+}
+
+func (p *venafiPolicy) toResponseData() map[string]interface{} {
+	keyConfigurations := make([]map[string]interface{}, 0, len(p.AllowedKeyConfigurations))
+	for _, kc := range p.AllowedKeyConfigurations {
+		keyConfigurations = append(keyConfigurations, map[string]interface{}{
+			"key_type":   kc.KeyType,
+			"key_bits":   kc.KeyBits,
+			"key_curves": kc.KeyCurves,
+		})
+	}
+	return map[string]interface{}{
+		"role":                       p.Role,
+		"zone":                       p.Zone,
+		"subject_cn_regexes":         p.SubjectCNRegexes,
+		"subject_o_regexes":          p.SubjectORegexes,
+		"subject_ou_regexes":         p.SubjectOURegexes,
+		"subject_st_regexes":         p.SubjectSTRegexes,
+		"subject_l_regexes":          p.SubjectLRegexes,
+		"subject_c_regexes":          p.SubjectCRegexes,
+		"allowed_key_configurations": keyConfigurations,
+		"dns_san_regexes":            p.DNSSANRegexes,
+		"ip_san_regexes":             p.IPSANRegexes,
+		"email_san_regexes":          p.EmailSANRegexes,
+		"uri_san_regexes":            p.URISANRegexes,
+		"upn_san_regexes":            p.UPNSANRegexes,
+		"allow_wildcards":            p.AllowWildcards,
+		"allow_key_reuse":            p.AllowKeyReuse,
+		"organization":               p.Organization,
+		"organizational_unit":        p.OrganizationalUnit,
+		"country":                    p.Country,
+		"province":                   p.Province,
+		"locality":                   p.Locality,
+		"refresh_interval":           int64(p.refreshInterval().Seconds()),
+		"last_refreshed":             formatStatusTime(p.LastRefreshed),
+		"last_refresh_attempt":       formatStatusTime(p.LastRefreshAttempt),
+		"refresh_failures":           p.RefreshFailures,
+		"last_refresh_error":         p.LastRefreshError,
+		"stale":                      p.stale(),
+	}
+}
+
+func pathListVenafiPolicies(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "venafi-policy/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathVenafiPolicyList,
+		},
+
+		HelpSynopsis:    pathVenafiPolicyHelpSyn,
+		HelpDescription: pathVenafiPolicyHelpDesc,
+	}
+}
+
+func pathVenafiPolicy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "venafi-policy/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the policy",
+			},
+			"role": {
+				Type:        framework.TypeString,
+				Description: "Role which Venafi connection settings are used to read the zone policy",
+			},
+			"zone": {
+				Type:        framework.TypeString,
+				Description: "Zone which policy is synced. Defaults to the zone of the role",
+			},
+			"refresh_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often the policy is synced again in the background. Defaults to 1 hour",
+			},
+		},
+		ExistenceCheck: b.venafiPolicyExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathVenafiPolicyRead,
+			logical.CreateOperation: b.pathVenafiPolicyWrite,
+			logical.UpdateOperation: b.pathVenafiPolicyWrite,
+			logical.DeleteOperation: b.pathVenafiPolicyDelete,
+		},
+
+		HelpSynopsis:    pathVenafiPolicyHelpSyn,
+		HelpDescription: pathVenafiPolicyHelpDesc,
+	}
+}
+
+func (b *backend) venafiPolicyExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	policy, err := b.getVenafiPolicy(ctx, req.Storage, data.Get("name").(string))
+	return policy != nil, err
+}
+
+func (b *backend) getVenafiPolicy(ctx context.Context, s logical.Storage, name string) (*venafiPolicy, error) {
+	entry, err := s.Get(ctx, venafiPolicyPrefix+name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var policy venafiPolicy
+	if err := entry.DecodeJSON(&policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (b *backend) pathVenafiPolicyList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, venafiPolicyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathVenafiPolicyRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	policy, err := b.getVenafiPolicy(ctx, req.Storage, data.Get("name").(string))
+	if err != nil || policy == nil {
+		return nil, err
+	}
+	resp := &logical.Response{Data: policy.toResponseData()}
+	if warning := policy.staleWarning(data.Get("name").(string)); warning != "" {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+// pathVenafiPolicyWrite reads the zone policy from Venafi and stores it, writing an existing policy syncs it again
+func (b *backend) pathVenafiPolicyWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	policy, err := b.getVenafiPolicy(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy = &venafiPolicy{}
+	}
+	roleName, zone, interval := policy.Role, policy.Zone, policy.RefreshInterval
+	if i, ok := data.GetOk("refresh_interval"); ok {
+		interval = time.Duration(i.(int)) * time.Second
+	}
+	if interval < 0 {
+		return logical.ErrorResponse("refresh_interval can't be negative"), nil
+	}
+	if r, ok := data.GetOk("role"); ok {
+		roleName = r.(string)
+	}
+	if z, ok := data.GetOk("zone"); ok {
+		zone = z.(string)
+	}
+	if roleName == "" {
+		return logical.ErrorResponse("role must be specified"), nil
+	}
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown role: %s", roleName)), nil
+	}
+	if zone == "" {
+		zone = role.Zone
+	}
+
+	policy, err = b.syncVenafiPolicy(ctx, req, roleName, zone)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	policy.RefreshInterval = interval
+	if err := putVenafiPolicy(ctx, req.Storage, name, policy); err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: policy.toResponseData()}, nil
+}
+
+func putVenafiPolicy(ctx context.Context, s logical.Storage, name string, policy *venafiPolicy) error {
+	entry, err := logical.StorageEntryJSON(venafiPolicyPrefix+name, policy)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// syncVenafiPolicy reads the zone policy from Venafi with the connection settings of the role
+func (b *backend) syncVenafiPolicy(ctx context.Context, req *logical.Request, roleName string, zone string) (*venafiPolicy, error) {
+	cl, _, err := b.clientVenafi(ctx, req, roleName, "", zone)
+	if err != nil {
+		return nil, err
+	}
+	zoneConfig, err := cl.ReadZoneConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy of zone %s: %s", zone, err)
+	}
+	return newVenafiPolicy(roleName, zone, zoneConfig), nil
+}
+
+func pathVenafiPolicyRefresh(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "venafi-policy/" + framework.GenericNameRegex("name") + "/refresh",
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the policy",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathVenafiPolicyRefresh,
+		},
+
+		HelpSynopsis:    pathVenafiPolicyRefreshHelpSyn,
+		HelpDescription: pathVenafiPolicyRefreshHelpDesc,
+	}
+}
+
+func (b *backend) pathVenafiPolicyRefresh(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	policy, err := b.getVenafiPolicy(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return logical.ErrorResponse(fmt.Sprintf("unknown policy: %s", name)), nil
+	}
+	policy, err = b.refreshVenafiPolicy(ctx, req.Storage, name, policy)
+	if err != nil {
+		return nil, err
+	}
+	if policy.RefreshFailures > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("failed to refresh policy %s: %s", name, policy.LastRefreshError)), nil
+	}
+	return &logical.Response{Data: policy.toResponseData()}, nil
+}
+
+// refreshVenafiPolicy syncs the policy again and stores it. A failed refresh keeps the previous policy and is
+// recorded in it, only the storage errors are returned.
+func (b *backend) refreshVenafiPolicy(ctx context.Context, s logical.Storage, name string, policy *venafiPolicy) (*venafiPolicy, error) {
+	now := time.Now()
+	refreshed, err := b.syncVenafiPolicy(ctx, &logical.Request{Storage: s}, policy.Role, policy.Zone)
+	if err != nil {
+		b.Logger().Warn("Failed to refresh policy", "policy", name, "error", err)
+		policy.LastRefreshAttempt = now
+		policy.RefreshFailures++
+		policy.LastRefreshError = scrub(err.Error())
+	} else {
+		refreshed.RefreshInterval = policy.RefreshInterval
+		refreshed.LastRefreshAttempt = now
+		policy = refreshed
+	}
+	return policy, putVenafiPolicy(ctx, s, name, policy)
+}
+
+// refreshVenafiPolicies syncs the policies which refresh_interval passed since the last attempt
+func (b *backend) refreshVenafiPolicies(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	b.policyRefreshLock.Lock()
+	defer b.policyRefreshLock.Unlock()
+
+	names, err := s.List(ctx, venafiPolicyPrefix)
+	if err != nil {
+		return err
+	}
+	var result error
+	for _, name := range names {
+		policy, err := b.getVenafiPolicy(ctx, s, name)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if policy == nil {
+			continue
+		}
+		last := policy.LastRefreshed
+		if policy.LastRefreshAttempt.After(last) {
+			last = policy.LastRefreshAttempt
+		}
+		if time.Since(last) < policy.refreshInterval() {
+			continue
+		}
+		if _, err := b.refreshVenafiPolicy(ctx, s, name, policy); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// addPolicyWarnings warns about the stale policy the role is bound to
+func (b *backend) addPolicyWarnings(ctx context.Context, s logical.Storage, resp *logical.Response, role *roleEntry) error {
+	if role.VenafiPolicy == "" {
+		return nil
+	}
+	policy, err := b.getVenafiPolicy(ctx, s, role.VenafiPolicy)
+	if err != nil || policy == nil {
+		return err
+	}
+	if warning := policy.staleWarning(role.VenafiPolicy); warning != "" {
+		resp.AddWarning(warning)
+	}
+	return nil
+}
+
+func (b *backend) pathVenafiPolicyDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	roles, err := b.rolesBoundToPolicy(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("policy %s is used by roles %s", name, strings.Join(roles, ", "))), nil
+	}
+	return nil, req.Storage.Delete(ctx, venafiPolicyPrefix+name)
+}
+
+// rolesBoundToPolicy returns the names of the roles with venafi_policy set to the policy
+func (b *backend) rolesBoundToPolicy(ctx context.Context, s logical.Storage, name string) ([]string, error) {
+	roleNames, err := s.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+	var bound []string
+	for _, roleName := range roleNames {
+		role, err := b.getRole(ctx, s, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.VenafiPolicy == name {
+			bound = append(bound, roleName)
+		}
+	}
+	sort.Strings(bound)
+	return bound, nil
+}
+
+const pathVenafiPolicyHelpSyn = `
+Sync the zone policy from Venafi into Vault.
+`
+
+const pathVenafiPolicyHelpDesc = `
+Writing venafi-policy/<name> reads the policy of the zone, e.g. the allowed domains, key settings and subject
+constraints, from Venafi Platform or Cloud with the connection settings of the role and stores it. Writing
+an existing policy syncs it again. Roles are bound to the policy with the venafi_policy option, the requests
+of the bound roles which the policy doesn't allow are rejected without contacting Venafi. A policy can't be
+deleted while roles are bound to it.
+
+The policy is synced again in the background every refresh_interval. If the refresh fails, the previous
+policy is used and the failure is recorded. After 3 failures in a row the policy is reported as stale by its
+reads, the reads of the bound roles, their issuance responses and the status path.
+`
+
+const pathVenafiPolicyRefreshHelpSyn = `
+Sync the zone policy from Venafi again.
+`
+
+const pathVenafiPolicyRefreshHelpDesc = `
+Reads the zone policy from Venafi with the role and zone of the policy and stores it, without waiting for
+the background refresh. The failure is recorded in the policy like the failure of the background refresh.
+`
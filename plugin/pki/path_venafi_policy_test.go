@@ -0,0 +1,275 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestVenafiPolicy(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request(logical.UpdateOperation, "roles/policy-source", map[string]interface{}{"fakemode": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := request(logical.UpdateOperation, "roles/policy-bound", map[string]interface{}{"fakemode": true, "venafi_policy": "devops"})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "Unknown venafi_policy devops") {
+		t.Fatalf("Expected the role bound to an unknown policy to be rejected, got %#v", resp)
+	}
+	resp = request(logical.UpdateOperation, "venafi-policy/devops", map[string]interface{}{})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expected the policy without role to be rejected, got %#v", resp)
+	}
+
+	resp = request(logical.UpdateOperation, "venafi-policy/devops", map[string]interface{}{"role": "policy-source", "zone": "devops\\zone"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp = request(logical.ReadOperation, "venafi-policy/devops", nil)
+	if resp == nil || resp.Data["role"] != "policy-source" || resp.Data["zone"] != "devops\\zone" ||
+		resp.Data["allow_wildcards"] != true || !reflect.DeepEqual(resp.Data["subject_cn_regexes"], []string{".*"}) ||
+		resp.Data["last_refreshed"] == "" {
+		t.Fatalf("Unexpected policy %#v", resp)
+	}
+	keyConfigurations := resp.Data["allowed_key_configurations"].([]map[string]interface{})
+	if len(keyConfigurations) != 2 || keyConfigurations[0]["key_type"] != "rsa" || keyConfigurations[1]["key_type"] != "ec" ||
+		!reflect.DeepEqual(keyConfigurations[1]["key_curves"], []string{"P521", "P256", "P384"}) {
+		t.Fatalf("Unexpected key configurations %v", keyConfigurations)
+	}
+	resp = request(logical.ListOperation, "venafi-policy/", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{"devops"}) {
+		t.Fatalf("Unexpected policies %v", resp.Data)
+	}
+
+	if resp := request(logical.UpdateOperation, "roles/policy-bound", map[string]interface{}{"fakemode": true, "venafi_policy": "devops"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp = request(logical.ReadOperation, "roles/policy-bound", nil)
+	if resp.Data["venafi_policy"] != "devops" {
+		t.Fatalf("Expected the role to be bound to the policy, got %v", resp.Data)
+	}
+	resp = request(logical.DeleteOperation, "venafi-policy/devops", nil)
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "policy-bound") {
+		t.Fatalf("Expected the bound policy not to be deleted, got %#v", resp)
+	}
+	request(logical.DeleteOperation, "roles/policy-bound", nil)
+	if resp := request(logical.DeleteOperation, "venafi-policy/devops", nil); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := request(logical.ReadOperation, "venafi-policy/devops", nil); resp != nil {
+		t.Fatalf("Expected the policy to be deleted, got %#v", resp)
+	}
+}
+
+func TestVenafiPolicyEnforcement(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	request := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request("roles/enforce", map[string]interface{}{"fakemode": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := request("venafi-policy/strict", map[string]interface{}{"role": "enforce"}); resp == nil || resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	//the fake connector allows everything, so the synced policy is restricted in the storage
+	policy, err := b.getVenafiPolicy(ctx, storage, "strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.SubjectCNRegexes = []string{`^.*\.example\.com$`}
+	policy.DNSSANRegexes = []string{`^.*\.example\.com$`}
+	policy.SubjectORegexes = []string{`^Venafi$`}
+	policy.AllowWildcards = false
+	policy.AllowedKeyConfigurations = []policyKeyConfiguration{{KeyType: "rsa", KeyBits: []int{2048, 4096}}}
+	entry, err := logical.StorageEntryJSON(venafiPolicyPrefix+"strict", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	for role, data := range map[string]map[string]interface{}{
+		"enforce":      {"fakemode": true, "venafi_policy": "strict"},
+		"enforce-3072": {"fakemode": true, "venafi_policy": "strict", "key_bits": 3072},
+		"enforce-ec":   {"fakemode": true, "venafi_policy": "strict", "key_type": "ec"},
+	} {
+		if resp := request("roles/"+role, data); resp != nil && resp.IsError() {
+			t.Fatalf("bad: resp: %#v", resp)
+		}
+	}
+
+	if resp := request("issue/enforce", map[string]interface{}{"common_name": "allowed.example.com", "organization": "Venafi"}); resp == nil || resp.IsError() {
+		t.Fatalf("Expected the request compliant with the policy to be issued, got %#v", resp)
+	}
+	for _, c := range []struct {
+		role     string
+		data     map[string]interface{}
+		expected string
+	}{
+		{"enforce", map[string]interface{}{"common_name": "denied.example.org"}, `common name "denied.example.org" is not allowed by policy strict`},
+		{"enforce", map[string]interface{}{"common_name": "allowed.example.com", "alt_names": "denied.example.org"}, `DNS SAN "denied.example.org"`},
+		{"enforce", map[string]interface{}{"common_name": "*.example.com"}, `wildcard "*.example.com" is not allowed`},
+		{"enforce", map[string]interface{}{"common_name": "allowed.example.com", "organization": "Other"}, `organization "Other"`},
+		{"enforce-3072", map[string]interface{}{"common_name": "allowed.example.com"}, "RSA key size 3072 is not allowed by policy strict, allowed key sizes: [2048 4096]"},
+		{"enforce-ec", map[string]interface{}{"common_name": "allowed.example.com"}, "key type ec is not allowed by policy strict, allowed key types: [rsa]"},
+	} {
+		resp := request("issue/"+c.role, c.data)
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), c.expected) {
+			t.Fatalf("Expected %v to be rejected with %q, got %#v", c.data, c.expected, resp)
+		}
+	}
+
+	//the CSR key is checked instead of the role key settings, also by sign-verbatim which skips the role rules
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "csr.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := request("sign-verbatim/enforce", map[string]interface{}{
+		"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+	})
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "key type ec is not allowed") {
+		t.Fatalf("Expected the EC CSR to be rejected, got %#v", resp)
+	}
+}
+
+func TestVenafiPolicyRefresh(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	request := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := request(logical.UpdateOperation, "roles/refresh", map[string]interface{}{"fakemode": true}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	resp := request(logical.UpdateOperation, "venafi-policy/refresh", map[string]interface{}{"role": "refresh", "refresh_interval": "2h"})
+	if resp == nil || resp.IsError() || resp.Data["refresh_interval"] != int64(7200) {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+	if resp := request(logical.UpdateOperation, "roles/refresh", map[string]interface{}{"fakemode": true, "venafi_policy": "refresh"}); resp != nil && resp.IsError() {
+		t.Fatalf("bad: resp: %#v", resp)
+	}
+
+	//the policy is not refreshed before the interval passes
+	synced, err := b.getVenafiPolicy(ctx, storage, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.refreshVenafiPolicies(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := b.getVenafiPolicy(ctx, storage, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.LastRefreshed.Equal(synced.LastRefreshed) {
+		t.Fatalf("Expected the policy not to be refreshed before the interval passes")
+	}
+
+	//the role the policy is synced with is changed, so the refresh fails
+	policy.Role = "missing"
+	policy.LastRefreshed = time.Now().Add(-3 * time.Hour)
+	if err := putVenafiPolicy(ctx, storage, "refresh", policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.refreshVenafiPolicies(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	//the failed attempt postpones the next background refresh
+	if err := b.refreshVenafiPolicies(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(logical.UpdateOperation, "venafi-policy/refresh/refresh", nil)
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("Expected the refresh to fail, got %#v", resp)
+	}
+	resp = request(logical.ReadOperation, "venafi-policy/refresh", nil)
+	if resp.Data["refresh_failures"] != 2 || resp.Data["stale"] != false || len(resp.Warnings) != 0 {
+		t.Fatalf("Expected two failed refreshes, got %#v", resp)
+	}
+	request(logical.UpdateOperation, "venafi-policy/refresh/refresh", nil)
+
+	resp = request(logical.ReadOperation, "venafi-policy/refresh", nil)
+	if resp.Data["refresh_failures"] != 3 || resp.Data["stale"] != true || len(resp.Warnings) != 1 ||
+		resp.Data["subject_cn_regexes"] == nil {
+		t.Fatalf("Expected the previous policy to be reported as stale, got %#v", resp)
+	}
+	for _, path := range []string{"roles/refresh", "roles/refresh/full"} {
+		if resp := request(logical.ReadOperation, path, nil); len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "may be stale") {
+			t.Fatalf("Expected a stale policy warning on %s, got %v", path, resp.Warnings)
+		}
+	}
+	resp = request(logical.UpdateOperation, "issue/refresh", map[string]interface{}{"common_name": "refresh.venafi.example.com"})
+	if resp == nil || resp.IsError() || !strings.Contains(strings.Join(resp.Warnings, "\n"), "may be stale") {
+		t.Fatalf("Expected the certificate to be issued with a stale policy warning, got %#v", resp)
+	}
+	if resp := request(logical.ReadOperation, "status", nil); resp.Data["healthy"] != false ||
+		resp.Data["policies"].(map[string]interface{})["refresh"].(map[string]interface{})["stale"] != true {
+		t.Fatalf("Expected the stale policy in the status, got %v", resp.Data)
+	}
+
+	//a successful refresh resets the failures
+	policy, err = b.getVenafiPolicy(ctx, storage, "refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.Role = "refresh"
+	if err := putVenafiPolicy(ctx, storage, "refresh", policy); err != nil {
+		t.Fatal(err)
+	}
+	resp = request(logical.UpdateOperation, "venafi-policy/refresh/refresh", nil)
+	if resp == nil || resp.IsError() || resp.Data["refresh_failures"] != 0 || resp.Data["refresh_interval"] != int64(7200) {
+		t.Fatalf("Expected the refresh to succeed, got %#v", resp)
+	}
+}
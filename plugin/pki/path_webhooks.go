@@ -0,0 +1,238 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/vault/helper/strutil"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+const (
+	webhooksPrefix = "webhooks/"
+	webhookTimeout = 10 * time.Second
+
+	webhookEventIssued  = "issued"
+	webhookEventRenewed = "renewed"
+	webhookEventRevoked = "revoked"
+)
+
+var webhookEvents = []string{webhookEventIssued, webhookEventRenewed, webhookEventRevoked}
+
+// webhookEntry is the target which is notified about the certificate events
+type webhookEntry struct {
+	URL        string   `json:"url"`
+	AuthHeader string   `json:"auth_header"`
+	Events     []string `json:"events"`
+}
+
+// webhookPayload is posted to the webhook targets as JSON, it never contains the private key
+type webhookPayload struct {
+	Event        string `json:"event"`
+	Role         string `json:"role"`
+	CommonName   string `json:"common_name"`
+	SerialNumber string `json:"serial_number"`
+	NotAfter     string `json:"not_after,omitempty"`
+	Timestamp    string `json:"timestamp"`
+}
+
+func pathListWebhooks(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "webhooks/?$",
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathWebhookList,
+		},
+
+		HelpSynopsis:    pathWebhooksHelpSyn,
+		HelpDescription: pathWebhooksHelpDesc,
+	}
+}
+
+func pathWebhooks(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "webhooks/" + framework.GenericNameRegex("name"),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the webhook",
+			},
+			"url": {
+				Type:        framework.TypeString,
+				Description: "HTTP or HTTPS URL which receives the certificate events as JSON POST requests",
+			},
+			"auth_header": {
+				Type:        framework.TypeString,
+				Description: `Value of the Authorization header of the requests, e.g. "Bearer <token>". It is not returned by reads`,
+			},
+			"events": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Events sent to the webhook: issued, renewed and revoked. All events are sent if not set",
+			},
+		},
+		ExistenceCheck: b.webhookExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathWebhookRead,
+			logical.CreateOperation: b.pathWebhookWrite,
+			logical.UpdateOperation: b.pathWebhookWrite,
+			logical.DeleteOperation: b.pathWebhookDelete,
+		},
+
+		HelpSynopsis:    pathWebhooksHelpSyn,
+		HelpDescription: pathWebhooksHelpDesc,
+	}
+}
+
+func (b *backend) webhookExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	webhook, err := b.getWebhook(ctx, req.Storage, data.Get("name").(string))
+	return webhook != nil, err
+}
+
+func (b *backend) getWebhook(ctx context.Context, s logical.Storage, name string) (*webhookEntry, error) {
+	entry, err := s.Get(ctx, webhooksPrefix+name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var webhook webhookEntry
+	if err := entry.DecodeJSON(&webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (b *backend) pathWebhookList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entries, err := req.Storage.List(ctx, webhooksPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(entries), nil
+}
+
+func (b *backend) pathWebhookRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	webhook, err := b.getWebhook(ctx, req.Storage, data.Get("name").(string))
+	if err != nil || webhook == nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"url":    webhook.URL,
+			"events": webhook.Events,
+		},
+	}, nil
+}
+
+func (b *backend) pathWebhookWrite(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	webhook, err := b.getWebhook(ctx, req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		webhook = &webhookEntry{}
+	}
+	if u, ok := data.GetOk("url"); ok {
+		webhook.URL = u.(string)
+	}
+	if header, ok := data.GetOk("auth_header"); ok {
+		webhook.AuthHeader = header.(string)
+	}
+	if events, ok := data.GetOk("events"); ok {
+		webhook.Events = events.([]string)
+	}
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return logical.ErrorResponse(fmt.Sprintf("invalid webhook url %q, it must be an HTTP or HTTPS URL", webhook.URL)), nil
+	}
+	for _, event := range webhook.Events {
+		if !strutil.StrListContains(webhookEvents, event) {
+			return logical.ErrorResponse(fmt.Sprintf("unknown event %s, it must be one of %v", event, webhookEvents)), nil
+		}
+	}
+
+	entry, err := logical.StorageEntryJSON(webhooksPrefix+name, webhook)
+	if err != nil {
+		return nil, err
+	}
+	return nil, req.Storage.Put(ctx, entry)
+}
+
+func (b *backend) pathWebhookDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return nil, req.Storage.Delete(ctx, webhooksPrefix+data.Get("name").(string))
+}
+
+// notifyWebhooks posts the event to the webhooks subscribed to it. The requests are sent in the background, so
+// a slow or unavailable webhook doesn't delay the certificate requests, and failures are only logged.
+func (b *backend) notifyWebhooks(ctx context.Context, s logical.Storage, payload webhookPayload) {
+	names, err := s.List(ctx, webhooksPrefix)
+	if err != nil {
+		b.Logger().Error("Failed to list webhooks", "error", err)
+		return
+	}
+	if len(names) == 0 {
+		return
+	}
+	payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		b.Logger().Error("Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	for _, name := range names {
+		webhook, err := b.getWebhook(ctx, s, name)
+		if err != nil {
+			b.Logger().Error("Failed to read webhook", "webhook", name, "error", err)
+			continue
+		}
+		if webhook == nil || (len(webhook.Events) > 0 && !strutil.StrListContains(webhook.Events, payload.Event)) {
+			continue
+		}
+		b.webhooks.Add(1)
+		go func(name string, webhook *webhookEntry) {
+			defer b.webhooks.Done()
+			if err := postWebhook(webhook, body); err != nil {
+				b.Logger().Warn("Failed to send event to webhook", "event", payload.Event,
+					"serial_number", payload.SerialNumber, "webhook", name, "error", err)
+			}
+		}(name, webhook)
+	}
+}
+
+func postWebhook(webhook *webhookEntry, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhook.AuthHeader != "" {
+		req.Header.Set("Authorization", webhook.AuthHeader)
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+const (
+	pathWebhooksHelpSyn = `
+Manage the webhooks notified about the certificate events.
+`
+	pathWebhooksHelpDesc = `
+Webhooks receive a JSON POST request with the event, role, common name, serial number
+and expiration of the certificate when certificates are issued, renewed or revoked.
+The requests are sent in the background and failed deliveries are logged.
+`
+)
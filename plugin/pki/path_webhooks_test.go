@@ -0,0 +1,102 @@
+package pki
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestWebhooks(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	var lock sync.Mutex
+	var payloads []webhookPayload
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		payloads = append(payloads, payload)
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	for name, data := range map[string]map[string]interface{}{
+		"all":     {"url": server.URL, "auth_header": "Bearer secret"},
+		"revoked": {"url": server.URL, "events": "revoked"},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "webhooks/" + name,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "webhooks/invalid",
+		Storage:   storage,
+		Data:      map[string]interface{}{"url": server.URL, "events": "expired"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting error for unknown event, got err: %v resp: %#v", err, resp)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "webhooks/all",
+		Storage:   storage,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if _, ok := resp.Data["auth_header"]; ok {
+		t.Fatal("auth_header must not be returned")
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/webhooks",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/webhooks",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "webhooks.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	b.webhooks.Wait()
+
+	//only the webhook subscribed to all events is notified about the issued certificate
+	if len(payloads) != 1 {
+		t.Fatalf("Expecting 1 webhook request, got %d", len(payloads))
+	}
+	payload := payloads[0]
+	if payload.Event != webhookEventIssued || payload.Role != "webhooks" || payload.CommonName != "webhooks.venafi.example.com" ||
+		payload.SerialNumber != resp.Data["serial_number"] || payload.NotAfter == "" {
+		t.Fatalf("Unexpected webhook payload %#v", payload)
+	}
+	if authHeaders[0] != "Bearer secret" {
+		t.Fatalf("Expecting Authorization header Bearer secret, got %q", authHeaders[0])
+	}
+}
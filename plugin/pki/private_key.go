@@ -0,0 +1,203 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	privateKeyFormatPKCS8 = "pkcs8"
+	pbkdf2Iterations      = 100000
+	pbkdf2SaltSize        = 16
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the PKCS#8 structure of an encrypted private key, RFC 5958
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params are the PBES2 parameters, RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// encodePKCS8PrivateKey returns the private key as PEM-encoded PKCS#8 instead of PKCS#1 or SEC1 returned by vcert.
+// With a password the key is encrypted with PBES2 using PBKDF2 with HMAC-SHA256 and AES-256-CBC.
+func encodePKCS8PrivateKey(key crypto.Signer, password []byte) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode private key as PKCS#8: %s", err)
+	}
+	defer zeroize(der)
+	if len(password) == 0 {
+		return encodePEMString(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+
+	salt := make([]byte, pbkdf2SaltSize)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	aesKey := pbkdf2.Key(password, salt, pbkdf2Iterations, 32, sha256.New)
+	defer zeroize(aesKey)
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return "", err
+	}
+	//PKCS#7 padding
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	encrypted := make([]byte, len(der)+padding)
+	copy(encrypted, der)
+	for i := len(der); i < len(encrypted); i++ {
+		encrypted[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	info, err := marshalPBES2PrivateKeyInfo(salt, iv, encrypted)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode encrypted private key: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: info})), nil
+}
+
+// decryptServiceGeneratedKey parses the private key generated by Venafi Platform, which encrypts it with the password
+// of the retrieve request
+func decryptServiceGeneratedKey(keyPEM string, password string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("Venafi Platform didn't return the private key")
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		defer zeroize(der)
+		block = &pem.Block{Type: block.Type, Bytes: der}
+	}
+	key, err := parsePrivateKeyBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unsupported private key %s", block.Type)
+	}
+	return key, nil
+}
+
+// retrievalPassword returns a random password for retrieving the key generated by Venafi Platform without
+// key_password. It only protects the key on the way to the plugin, which encodes it as requested afterwards.
+func retrievalPassword() (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// encodePEMString returns the PEM encoding of the block and wipes the intermediate buffer
+func encodePEMString(block *pem.Block) string {
+	encoded := pem.EncodeToMemory(block)
+	defer zeroize(encoded)
+	return string(encoded)
+}
+
+// zeroize overwrites the buffer which contained key material
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// zeroizeInt overwrites the words of the number in place, so they don't remain in memory freed later
+func zeroizeInt(i *big.Int) {
+	if i == nil {
+		return
+	}
+	words := i.Bits()
+	for j := range words {
+		words[j] = 0
+	}
+	i.SetInt64(0)
+}
+
+// zeroizePrivateKey overwrites the private values of the key after the response with it has been built. The strings
+// of the response and the storage entries can't be wiped, only the key generated for the request.
+func zeroizePrivateKey(key crypto.Signer) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		zeroizeInt(k.D)
+		for _, p := range k.Primes {
+			zeroizeInt(p)
+		}
+		zeroizeInt(k.Precomputed.Dp)
+		zeroizeInt(k.Precomputed.Dq)
+		zeroizeInt(k.Precomputed.Qinv)
+		for _, crt := range k.Precomputed.CRTValues {
+			zeroizeInt(crt.Exp)
+			zeroizeInt(crt.Coeff)
+			zeroizeInt(crt.R)
+		}
+	case *ecdsa.PrivateKey:
+		zeroizeInt(k.D)
+	}
+}
+
+func marshalPBES2PrivateKeyInfo(salt, iv, encrypted []byte) ([]byte, error) {
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData:       encrypted,
+	})
+}
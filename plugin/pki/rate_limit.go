@@ -0,0 +1,83 @@
+package pki
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+const (
+	defaultIssuanceLimitPeriod   = time.Minute
+	errorTextIssuanceRateLimited = `issuance rate limit of role %s is exceeded, retry after %s`
+)
+
+// issuanceLimiter keeps a token bucket per role, so a runaway client can't exhaust the Venafi request quota of the
+// other roles. The buckets are kept in memory, so each node of the cluster enforces the limit separately
+type issuanceLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	limit  int
+	period time.Duration
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of the role, the bucket holds up to limit tokens and is refilled at limit
+// tokens per period. The time until the next token is returned if the bucket is empty
+func (l *issuanceLimiter) allow(roleName string, limit int, period time.Duration, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	bucket, ok := l.buckets[roleName]
+	//the bucket starts full when the role limit is changed
+	if !ok || bucket.limit != limit || bucket.period != period {
+		bucket = &tokenBucket{limit: limit, period: period, tokens: float64(limit), last: now}
+		l.buckets[roleName] = bucket
+	}
+	rate := float64(limit) / period.Seconds()
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// purge removes the bucket of the role, e.g. when the role is deleted
+func (l *issuanceLimiter) purge(roleName string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.buckets, roleName)
+}
+
+// issuanceRateLimitPeriod returns issuance_rate_limit_period of the role or its default
+func (r *roleEntry) issuanceRateLimitPeriod() time.Duration {
+	if r.IssuanceLimitPeriod > 0 {
+		return r.IssuanceLimitPeriod
+	}
+	return defaultIssuanceLimitPeriod
+}
+
+// checkIssuanceRateLimit returns the error for the issuance requests exceeding issuance_rate_limit of the role
+func (b *backend) checkIssuanceRateLimit(roleName string, role *roleEntry) error {
+	if role.IssuanceRateLimit <= 0 {
+		return nil
+	}
+	allowed, retryAfter := b.issuanceLimits.allow(roleName, role.IssuanceRateLimit, role.issuanceRateLimitPeriod(), time.Now())
+	if allowed {
+		return nil
+	}
+	metrics.IncrCounterWithLabels(metricsKey("request", "rate_limited"), 1, metricsLabels(roleName, ""))
+	b.Logger().Warn("Issuance rate limit of the role is exceeded", "role", roleName, "retry_after", retryAfter)
+	//rounded up, so the retry after the reported time succeeds
+	return fmt.Errorf(errorTextIssuanceRateLimited, roleName, (retryAfter + time.Second - 1).Truncate(time.Second))
+}
@@ -0,0 +1,79 @@
+package pki
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestIssuanceRateLimit(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/limited",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "issuance_rate_limit": -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.Data["error"] != errorTextNegativeRateLimit {
+		t.Fatalf("Expecting error %s but got %#v", errorTextNegativeRateLimit, resp)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/limited",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "issuance_rate_limit": 2, "issuance_rate_limit_period": "1h", "allow_ip_sans": false},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	issue := func(data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/limited",
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+	//the requests rejected locally are not sent to Venafi, so they are not counted
+	if resp, err := issue(map[string]interface{}{"common_name": "10.0.0.1"}); err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("Expecting the IP address common name to be rejected, err: %v resp: %#v", err, resp)
+	}
+	for i := 0; i < 2; i++ {
+		if resp, err := issue(map[string]interface{}{"common_name": "limited.venafi.example.com"}); err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+	}
+	_, err = issue(map[string]interface{}{"common_name": "limited.venafi.example.com"})
+	codedErr, ok := err.(logical.HTTPCodedError)
+	if !ok || codedErr.Code() != http.StatusTooManyRequests || !strings.Contains(err.Error(), "retry after") {
+		t.Fatalf("Expecting the request to be rate limited, got %v", err)
+	}
+
+	//other roles have their own limits
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/unlimited",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/unlimited",
+		Storage:   storage,
+		Data:      map[string]interface{}{"common_name": "unlimited.venafi.example.com"},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+}
@@ -0,0 +1,260 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/logical"
+)
+
+// reissuePrefix contains the storage paths of the certificates issued by the prevent_reissue roles by requested names
+const reissuePrefix = "reissue/"
+
+type reissueEntry struct {
+	StoragePath string `json:"storage_path"`
+}
+
+// reusableCertificate is the stored certificate which is returned instead of a new one
+type reusableCertificate struct {
+	//empty for certificates found at Venafi
+	storagePath string
+	pcc         *certificate.PEMCollection
+	//nil for sign requests
+	privateKey crypto.Signer
+}
+
+// certificateNames returns the sorted set of the common name and all SANs, so the names of a request and a certificate
+// can be compared regardless of order and whether IP addresses are also requested as DNS names
+func certificateNames(commonName string, dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []string {
+	set := map[string]bool{commonName: true}
+	for _, name := range dnsNames {
+		set[strings.ToLower(name)] = true
+	}
+	for _, ip := range ips {
+		set[ip.String()] = true
+	}
+	for _, email := range emails {
+		set[email] = true
+	}
+	for _, uri := range uris {
+		set[uri.String()] = true
+	}
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// requestNames returns the names of the certificate which would be requested
+func requestNames(reqData requestData, role *roleEntry, csr *x509.CertificateRequest, logger hclog.Logger) ([]string, error) {
+	if csr != nil {
+		return certificateNames(csr.Subject.CommonName, csr.DNSNames, csr.IPAddresses, csr.EmailAddresses, csr.URIs), nil
+	}
+	certReq, err := formRequest(reqData, role, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	return certificateNames(certReq.Subject.CommonName, certReq.DNSNames, certReq.IPAddresses, certReq.EmailAddresses, certReq.URIs), nil
+}
+
+func reissueKey(roleName string, zone string, names []string) string {
+	sum := sha256.Sum256([]byte(roleName + "\n" + zone + "\n" + strings.Join(names, "\n")))
+	return reissuePrefix + hex.EncodeToString(sum[:])
+}
+
+// findReusableCertificate returns the stored certificate which was issued for the same names by the role and which
+// can be returned instead of a new one if it is valid for at least min_cert_time_left. A reused certificate of an issue request must have a stored unencrypted
+// private key, a reused certificate of a sign request must have the CSR public key.
+func (b *backend) findReusableCertificate(ctx context.Context, s logical.Storage, key string, role *roleEntry,
+	names []string, csr *x509.CertificateRequest) (*reusableCertificate, error) {
+
+	indexEntry, err := s.Get(ctx, key)
+	if err != nil || indexEntry == nil {
+		return nil, err
+	}
+	var index reissueEntry
+	if err := indexEntry.DecodeJSON(&index); err != nil {
+		return nil, err
+	}
+	certEntry, err := s.Get(ctx, index.StoragePath)
+	if err != nil || certEntry == nil {
+		return nil, err
+	}
+	var cert VenafiCert
+	if err := certEntry.DecodeJSON(&cert); err != nil {
+		return nil, err
+	}
+	if cert.RevocationTime != 0 {
+		return nil, nil
+	}
+	parsedCertificate, err := parseCertificatePEM(cert.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	if time.Until(parsedCertificate.NotAfter) <= role.MinCertTimeLeft {
+		return nil, nil
+	}
+	//the entry can be overwritten by a certificate with different SANs if certificates are stored by CN
+	certNames := certificateNames(parsedCertificate.Subject.CommonName, parsedCertificate.DNSNames,
+		parsedCertificate.IPAddresses, parsedCertificate.EmailAddresses, parsedCertificate.URIs)
+	if strings.Join(certNames, "\n") != strings.Join(names, "\n") {
+		return nil, nil
+	}
+
+	reusable := &reusableCertificate{storagePath: index.StoragePath}
+	if csr != nil {
+		certKey, err := x509.MarshalPKIXPublicKey(parsedCertificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(certKey, csrKey) {
+			return nil, nil
+		}
+	} else {
+		privateKey, err := parseStoredPrivateKey(cert.PrivateKey)
+		if err != nil || privateKey == nil {
+			return nil, err
+		}
+		if !keyMatchesRole(privateKey, role) {
+			zeroizePrivateKey(privateKey)
+			return nil, nil
+		}
+		reusable.privateKey = privateKey
+	}
+
+	pcc := &certificate.PEMCollection{Certificate: cert.Certificate}
+	//the stored chain starts with the certificate
+	rest := []byte(cert.CertificateChain)
+	for i := 0; ; i++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if i > 0 {
+			pcc.Chain = append(pcc.Chain, encodePEMString(block))
+		}
+	}
+	reusable.pcc = pcc
+	return reusable, nil
+}
+
+// searchVenafiCertificate returns the valid certificate of the zone which was issued for the names and the CSR public key.
+// Venafi doesn't have the private keys of the locally generated CSRs, so certificates are searched for sign requests only.
+func searchVenafiCertificate(cl endpoint.Connector, role *roleEntry, names []string, csr *x509.CertificateRequest) (*reusableCertificate, error) {
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	//the pinned vcert can't filter the certificates, all valid certificates of the zone are listed
+	infos, err := cl.ListCertificates(endpoint.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Thumbprint == "" || time.Until(info.ValidTo) <= role.MinCertTimeLeft {
+			continue
+		}
+		var ips []net.IP
+		for _, ip := range info.SANS.IP {
+			if parsed := net.ParseIP(ip); parsed != nil {
+				ips = append(ips, parsed)
+			}
+		}
+		var uris []*url.URL
+		for _, uri := range info.SANS.URI {
+			if parsed, err := url.Parse(uri); err == nil {
+				uris = append(uris, parsed)
+			}
+		}
+		infoNames := certificateNames(info.CN, info.SANS.DNS, ips, info.SANS.Email, uris)
+		if strings.Join(infoNames, "\n") != strings.Join(names, "\n") {
+			continue
+		}
+
+		certReq := &certificate.Request{Thumbprint: info.Thumbprint, ChainOption: certificate.ChainOptionRootLast}
+		if role.ChainOption == "first" {
+			certReq.ChainOption = certificate.ChainOptionRootFirst
+		}
+		pcc, err := cl.RetrieveCertificate(certReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve certificate %s: %s", info.Thumbprint, err)
+		}
+		parsedCertificate, err := parseCertificatePEM(pcc.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		certKey, err := x509.MarshalPKIXPublicKey(parsedCertificate.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(certKey, csrKey) {
+			pcc.PrivateKey = ""
+			return &reusableCertificate{pcc: pcc}, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseStoredPrivateKey parses the private key of the issue response, encrypted keys can't be reused
+func parseStoredPrivateKey(privateKey string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil || x509.IsEncryptedPEMBlock(block) {
+		return nil, nil
+	}
+	return parsePrivateKeyBlock(block)
+}
+
+// parsePrivateKeyBlock parses the PKCS#1, SEC1 or PKCS#8 private key, it returns nil for other PEM blocks
+func parsePrivateKeyBlock(block *pem.Block) (crypto.Signer, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, nil
+}
+
+// keyMatchesRole checks that the key of the reused certificate has the current key settings of the role
+func keyMatchesRole(key crypto.Signer, role *roleEntry) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return role.KeyType == "rsa" && k.N.BitLen() == role.KeyBits
+	case *ecdsa.PrivateKey:
+		return role.KeyType == "ec" && strings.Replace(k.Curve.Params().Name, "-", "", 1) == role.KeyCurve
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestPreventReissue(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	write := func(path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		return resp
+	}
+
+	write("roles/reissue", map[string]interface{}{"fakemode": true, "prevent_reissue": true, "store_pkey": true})
+	first := write("issue/reissue", map[string]interface{}{"common_name": "reissue.venafi.example.com", "alt_names": "a.venafi.example.com"})
+	second := write("issue/reissue", map[string]interface{}{"common_name": "reissue.venafi.example.com",
+		"alt_names": "a.venafi.example.com,reissue.venafi.example.com", "key_password": "password"})
+	if first.Data["serial_number"] != second.Data["serial_number"] {
+		t.Fatalf("Expecting certificate %s to be reused, got %s", first.Data["serial_number"], second.Data["serial_number"])
+	}
+	if len(second.Warnings) == 0 || !strings.Contains(second.Warnings[0], "returned instead of a new one") {
+		t.Fatalf("Expecting reuse warning, got %v", second.Warnings)
+	}
+	block, _ := pem.Decode([]byte(second.Data["private_key"].(string)))
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("Expecting reused private key encrypted by key_password")
+	}
+
+	other := write("issue/reissue", map[string]interface{}{"common_name": "reissue.venafi.example.com", "alt_names": "b.venafi.example.com"})
+	if other.Data["serial_number"] == first.Data["serial_number"] {
+		t.Fatalf("Expecting new certificate for different SANs")
+	}
+
+	//revocation isn't supported by the fake connector, the certificate is marked as revoked in the storage
+	path := "certs/" + normalizeSerial(first.Data["serial_number"].(string))
+	entry, err := storage.Get(ctx, path)
+	if err != nil || entry == nil {
+		t.Fatalf("Expecting stored certificate, got err: %v", err)
+	}
+	var cert VenafiCert
+	if err := entry.DecodeJSON(&cert); err != nil {
+		t.Fatal(err)
+	}
+	cert.RevocationTime = time.Now().Unix()
+	if entry, err = logical.StorageEntryJSON(path, cert); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	third := write("issue/reissue", map[string]interface{}{"common_name": "reissue.venafi.example.com", "alt_names": "a.venafi.example.com"})
+	if third.Data["serial_number"] == first.Data["serial_number"] {
+		t.Fatalf("Expecting revoked certificate not to be reused")
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "sign.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+	first = write("sign/reissue", map[string]interface{}{"csr": csrPEM})
+	second = write("sign/reissue", map[string]interface{}{"csr": csrPEM})
+	if first.Data["serial_number"] != second.Data["serial_number"] {
+		t.Fatalf("Expecting signed certificate %s to be reused, got %s", first.Data["serial_number"], second.Data["serial_number"])
+	}
+
+	key, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "sign.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other = write("sign/reissue", map[string]interface{}{"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))})
+	if other.Data["serial_number"] == first.Data["serial_number"] {
+		t.Fatalf("Expecting new certificate for CSR with different key")
+	}
+}
+
+func TestPreventReissueNoStore(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/no-store",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "prevent_reissue": true, "no_store": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "sign.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the fake connector doesn't list any certificates, so a new one is issued
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "sign/no-store",
+		Storage:   storage,
+		Data:      map[string]interface{}{"csr": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))},
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	if len(resp.Warnings) > 0 && strings.Contains(resp.Warnings[0], "returned instead of a new one") {
+		t.Fatalf("Expecting new certificate, got %v", resp.Warnings)
+	}
+}
+
+func TestMinCertTimeLeft(t *testing.T) {
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/time-left",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true, "min_cert_time_left": "24h"},
+	})
+	if err != nil || resp == nil || resp.Data["error"] != errorTextMinCertTimeLeftWithoutReissue {
+		t.Fatalf("Expecting error %s, got err: %v resp: %#v", errorTextMinCertTimeLeftWithoutReissue, err, resp)
+	}
+
+	serials := make(map[string]string)
+	for _, timeLeft := range []string{"24h", "87600h"} {
+		resp, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roles/time-left",
+			Storage:   storage,
+			Data:      map[string]interface{}{"fakemode": true, "prevent_reissue": true, "store_pkey": true, "min_cert_time_left": timeLeft},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("bad: err: %v resp: %#v", err, resp)
+		}
+		var serial string
+		for i := 0; i < 2; i++ {
+			resp, err = b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "issue/time-left",
+				Storage:   storage,
+				Data:      map[string]interface{}{"common_name": timeLeft + ".venafi.example.com"},
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Fatalf("bad: err: %v resp: %#v", err, resp)
+			}
+			if i == 0 {
+				serial = resp.Data["serial_number"].(string)
+			} else {
+				serials[timeLeft] = resp.Data["serial_number"].(string)
+			}
+		}
+		if timeLeft == "24h" && serials[timeLeft] != serial {
+			t.Fatalf("Expecting certificate %s to be reused, got %s", serial, serials[timeLeft])
+		}
+		if timeLeft == "87600h" && serials[timeLeft] == serial {
+			t.Fatalf("Expecting new certificate if the matching one expires before min_cert_time_left")
+		}
+	}
+}
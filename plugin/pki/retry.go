@@ -0,0 +1,100 @@
+package pki
+
+import (
+	"context"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/hashicorp/vault/logical"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 30 * time.Second
+)
+
+var tooManyRequestsStatusRegex = regexp.MustCompile(`(?i)status[^0-9]{0,8}429\b`)
+
+// isTransientError returns true if the request can succeed when it is repeated later
+func isTransientError(err error) bool {
+	return isFailoverError(err) || (err != nil && tooManyRequestsStatusRegex.MatchString(err.Error()))
+}
+
+func (r *roleEntry) retryMaxAttempts() int {
+	if r.RetryMaxAttempts == 0 {
+		return defaultRetryMaxAttempts
+	}
+	return r.RetryMaxAttempts
+}
+
+// retryDelay returns the exponential backoff delay before the next attempt, attempt starts from 0
+func (r *roleEntry) retryDelay(attempt int) time.Duration {
+	base, max := r.RetryBaseDelay, r.RetryMaxDelay
+	if base == 0 {
+		base = defaultRetryBaseDelay
+	}
+	if max == 0 {
+		max = defaultRetryMaxDelay
+	}
+	delay := max
+	if attempt < 30 && base<<uint(attempt) < max {
+		delay = base << uint(attempt)
+	}
+	if !r.RetryDisableJitter {
+		//full jitter spreads the retries of concurrent requests
+		delay = time.Duration(rand.Int63n(int64(delay))) + 1
+	}
+	return delay
+}
+
+// enrollCertificateWithRetries requests the certificate and retrieves it, repeating the failed step with backoff while
+// the Venafi endpoint returns transient errors. Once the request is created only the retrieval is repeated, so no
+// duplicate certificate is requested.
+func (b *backend) enrollCertificateWithRetries(ctx context.Context, req *logical.Request, roleName string, tppURL string,
+	reqData requestData, role *roleEntry, signCSR bool) (certReq *certificate.Request, pcc *certificate.PEMCollection, err error) {
+
+	var pending *pendingCertificate
+	failed := func(err error) error {
+		if pending != nil {
+			return &pendingCertificateError{requestID: pending.requestID, err: err}
+		}
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		err = nil
+		if pending == nil {
+			pending, err = b.requestCertificate(ctx, req, roleName, tppURL, reqData, role, signCSR)
+		}
+		if err == nil {
+			pcc, err = b.retrieveCertificate(ctx, req, roleName, tppURL, role, pending)
+		}
+		if err == nil {
+			if tppURL != "" {
+				b.tppHealth.reportSuccess(tppURL, time.Since(started))
+			}
+			return pending.certReq, pcc, nil
+		}
+		if !isTransientError(err) {
+			return nil, nil, failed(err)
+		}
+		if attempt+1 >= role.retryMaxAttempts() {
+			if tppURL != "" && isFailoverError(err) {
+				b.tppHealth.reportFailure(tppURL)
+			}
+			return nil, nil, failed(err)
+		}
+
+		delay := role.retryDelay(attempt)
+		countRetry(roleName)
+		b.Logger().Warn("Venafi request failed with transient error, retrying", "role", roleName, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, failed(err)
+		case <-time.After(delay):
+		}
+	}
+}
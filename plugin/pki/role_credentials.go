@@ -0,0 +1,121 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+)
+
+// credentialsPrefix is the storage prefix of the role secrets. It is seal wrapped, so the credentials get an extra
+// layer of encryption on Vault Enterprise.
+const credentialsPrefix = "credentials/"
+
+// roleCredentials are the secrets of the role, stored separately from the other role settings
+type roleCredentials struct {
+	TPPPassword  string `json:"tpp_password"`
+	Apikey       string `json:"apikey"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *roleEntry) credentials() roleCredentials {
+	return roleCredentials{
+		TPPPassword:  r.TPPPassword,
+		Apikey:       r.Apikey,
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+	}
+}
+
+func (r *roleEntry) setCredentials(c roleCredentials) {
+	r.TPPPassword = c.TPPPassword
+	r.Apikey = c.Apikey
+	r.AccessToken = c.AccessToken
+	r.RefreshToken = c.RefreshToken
+}
+
+func putRoleCredentials(ctx context.Context, s logical.Storage, name string, c roleCredentials) error {
+	entry, err := logical.StorageEntryJSON(credentialsPrefix+name, c)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// getRoleCredentials returns nil if the credentials are not stored separately, e.g. for the roles written by the
+// older versions which keep them in the role entry
+func getRoleCredentials(ctx context.Context, s logical.Storage, name string) (*roleCredentials, error) {
+	entry, err := s.Get(ctx, credentialsPrefix+name)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var c roleCredentials
+	if err := entry.DecodeJSON(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// migrateRoleCredentials moves the credentials kept in the role entries by the older versions to credentials/.
+// This version of the Vault SDK doesn't have an initialize callback, so it is done on the first periodic tick
+// after the plugin is upgraded.
+func (b *backend) migrateRoleCredentials(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	if !atomic.CompareAndSwapInt32(&b.credentialsMigrated, 0, 1) {
+		return nil
+	}
+	unlock := b.lockCredentials()
+	defer unlock()
+
+	roles, err := s.List(ctx, "role/")
+	if err != nil {
+		atomic.StoreInt32(&b.credentialsMigrated, 0)
+		return err
+	}
+
+	var migrated []string
+	var result error
+	for _, roleName := range roles {
+		entry, err := s.Get(ctx, "role/"+roleName)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if entry == nil {
+			continue
+		}
+		var embedded roleEntry
+		if err := entry.DecodeJSON(&embedded); err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if embedded.credentials() == (roleCredentials{}) {
+			continue
+		}
+		role, err := b.getRole(ctx, s, roleName)
+		if err == nil {
+			err = b.putRole(ctx, s, roleName, role)
+		}
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("failed to migrate credentials of role %s: %s", roleName, err))
+			continue
+		}
+		migrated = append(migrated, roleName)
+	}
+	if result != nil {
+		//the failed roles are retried on the next tick
+		atomic.StoreInt32(&b.credentialsMigrated, 0)
+	}
+	if len(migrated) > 0 {
+		b.Logger().Info("Moved credentials of the roles out of the role entries", "path", credentialsPrefix,
+			"roles", strings.Join(migrated, ", "))
+	}
+	return result
+}
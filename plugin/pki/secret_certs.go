@@ -1,6 +1,11 @@
 package pki
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 )
 
@@ -27,6 +32,106 @@ reference`,
 			},
 		},
 
-		Revoke: b.venafiCertRevoke,
+		Renew:  b.secretCertsRenew,
+		Revoke: b.secretCertsRevoke,
+	}
+}
+
+// secretCertsRenew renews the certificate at Venafi when its lease is renewed, because the lease can't outlive the
+// certificate. The lease is updated to the renewed certificate.
+func (b *backend) secretCertsRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName, _ := req.Secret.InternalData["role"].(string)
+	storagePath, _ := req.Secret.InternalData["storage_path"].(string)
+	if roleName == "" {
+		return logical.ErrorResponse("lease issued by the previous version can't be renewed"), nil
+	}
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role %s doesn't exist", roleName)), nil
+	}
+	if !role.RenewOnLeaseRenew {
+		return logical.ErrorResponse(fmt.Sprintf("lease renewal is not enabled by role %s, set renew_on_lease_renew", roleName)), nil
+	}
+	if storagePath == "" {
+		return logical.ErrorResponse("certificate wasn't stored, so it can't be renewed"), nil
+	}
+
+	resp, err := b.renewStoredCertificate(ctx, req, storagePath, roleName, false)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	renewed, err := parseCertificatePEM(resp.Data["certificate"].(string))
+	if err != nil {
+		return nil, err
+	}
+	req.Secret.InternalData["serial_number"] = resp.Data["serial_number"]
+	req.Secret.InternalData["certificate"] = resp.Data["certificate"]
+	if !role.NoStore {
+		req.Secret.InternalData["storage_path"] = certStoragePath(role, renewed.Subject.CommonName, resp.Data["serial_number"].(string), renewed.Raw)
+	}
+	resp.Secret = req.Secret
+	resp.Secret.TTL = role.leaseTTL(renewed.NotAfter)
+	return resp, nil
+}
+
+// secretCertsRevoke revokes the certificate at Venafi when its lease is revoked
+func (b *backend) secretCertsRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleName, _ := req.Secret.InternalData["role"].(string)
+	certPEM, _ := req.Secret.InternalData["certificate"].(string)
+	storagePath, _ := req.Secret.InternalData["storage_path"].(string)
+	if roleName == "" || certPEM == "" {
+		//leases issued by the previous versions don't have the certificate
+		return nil, nil
+	}
+
+	role, err := b.getRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		b.Logger().Warn("Role doesn't exist, can't revoke certificate at Venafi", "role", roleName)
+		return nil, nil
+	}
+	//neither fake mode nor vcert Venafi Cloud connector supports revocation, so the lease must not get stuck on it
+	if role.Fakemode || role.TPPURL == "" {
+		b.Logger().Warn("Revocation is not supported by the endpoint of the role, certificate is not revoked at Venafi", "role", roleName)
+		return nil, nil
+	}
+
+	var entry *logical.StorageEntry
+	var cert VenafiCert
+	if storagePath != "" {
+		entry, err = req.Storage.Get(ctx, storagePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if entry != nil {
+		if err := entry.DecodeJSON(&cert); err != nil {
+			return nil, err
+		}
+		//certificates stored by CN are overwritten when the same CN is issued again
+		if cert.Certificate != certPEM {
+			entry = nil
+		} else if cert.RevocationTime != 0 {
+			return nil, nil
+		}
+	}
+
+	if err := b.revokeCertificate(ctx, req, roleName, certPEM, ""); err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	cert.RevocationTime = time.Now().Unix()
+	entry, err = b.certificateEntry(ctx, req.Storage, storagePath, cert)
+	if err != nil {
+		return nil, err
 	}
+	return nil, req.Storage.Put(ctx, entry)
 }
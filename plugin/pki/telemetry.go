@@ -0,0 +1,48 @@
+package pki
+
+import (
+	"context"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+	"github.com/hashicorp/vault/logical/framework"
+)
+
+// metrics are emitted under the venafi prefix of the global Vault telemetry sink
+var metricsPrefix = []string{"venafi"}
+
+func metricsKey(name ...string) []string {
+	return append(append([]string{}, metricsPrefix...), name...)
+}
+
+func metricsLabels(roleName, endpoint string) []metrics.Label {
+	labels := []metrics.Label{{Name: "role", Value: roleName}}
+	if endpoint != "" {
+		labels = append(labels, metrics.Label{Name: "endpoint", Value: endpoint})
+	}
+	return labels
+}
+
+// instrumented wraps the callback of the issue, sign and revoke paths to measure the latency and count the successful
+// and failed requests per role and endpoint type
+func instrumented(endpoint string, f framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		started := time.Now()
+		resp, err := f(ctx, req, data)
+
+		labels := metricsLabels(data.Get("role").(string), endpoint)
+		metrics.MeasureSinceWithLabels(metricsKey("request", "duration"), started, labels)
+		if err != nil || (resp != nil && resp.IsError()) {
+			metrics.IncrCounterWithLabels(metricsKey("request", "error"), 1, labels)
+		} else {
+			metrics.IncrCounterWithLabels(metricsKey("request", "success"), 1, labels)
+		}
+		return resp, err
+	}
+}
+
+// countRetry counts the Venafi requests repeated after a transient error
+func countRetry(roleName string) {
+	metrics.IncrCounterWithLabels(metricsKey("request", "retry"), 1, metricsLabels(roleName, ""))
+}
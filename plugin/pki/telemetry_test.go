@@ -0,0 +1,56 @@
+package pki
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/logical"
+)
+
+func TestTelemetry(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	conf := metrics.DefaultConfig("vault")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.NewGlobal(conf, &metrics.BlackholeSink{})
+
+	b, storage := createBackendWithStorage(t)
+	ctx := context.Background()
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roles/telemetry",
+		Storage:   storage,
+		Data:      map[string]interface{}{"fakemode": true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("bad: err: %v resp: %#v", err, resp)
+	}
+	for _, cn := range []string{"telemetry.venafi.example.com", ""} {
+		_, err = b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/telemetry",
+			Storage:   storage,
+			Data:      map[string]interface{}{"common_name": cn},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := sink.Data()
+	labels := ";role=telemetry;endpoint=issue"
+	if c, ok := data[0].Counters["vault.venafi.request.success"+labels]; !ok || c.Count != 1 {
+		t.Fatalf("Expected one successful request, got counters %v", data[0].Counters)
+	}
+	if c, ok := data[0].Counters["vault.venafi.request.error"+labels]; !ok || c.Count != 1 {
+		t.Fatalf("Expected one failed request, got counters %v", data[0].Counters)
+	}
+	if s, ok := data[0].Samples["vault.venafi.request.duration"+labels]; !ok || s.Count != 2 {
+		t.Fatalf("Expected two latency samples, got samples %v", data[0].Samples)
+	}
+}
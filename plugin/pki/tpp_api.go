@@ -0,0 +1,134 @@
+package pki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// tppAPIClient is a minimal Venafi Platform WebSDK client for the calls which are not covered by vcert
+type tppAPIClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+type tppIdentity struct {
+	PrefixedName      string `json:",omitempty"`
+	PrefixedUniversal string `json:",omitempty"`
+}
+
+type tppAuthorizeRequest struct {
+	Username string
+	Password string
+}
+
+type tppAuthorizeResponse struct {
+	APIKey     string
+	ValidUntil string
+}
+
+type tppIdentitiesResponse struct {
+	Identities []tppIdentity
+}
+
+type tppSetPasswordRequest struct {
+	ID          tppIdentity
+	Password    string
+	OldPassword string
+}
+
+type tppErrorResponse struct {
+	Error string
+}
+
+func (b *backend) newTPPAPIClient(role *roleEntry) (*tppAPIClient, error) {
+	client, err := b.getHTTPClient(role)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := strings.TrimSuffix(role.TPPURL, "/")
+	if !strings.HasSuffix(strings.ToLower(baseURL), "/vedsdk") {
+		baseURL = baseURL + "/vedsdk"
+	}
+	return &tppAPIClient{
+		baseURL: baseURL,
+		client:  client,
+	}, nil
+}
+
+func (c *tppAPIClient) request(method, resource string, in interface{}, out interface{}) error {
+	var payload []byte
+	var err error
+	if in != nil {
+		payload, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/"+resource, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-Venafi-Api-Key", c.apiKey)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var tppErr tppErrorResponse
+		if json.Unmarshal(body, &tppErr) == nil && tppErr.Error != "" {
+			return fmt.Errorf("%s %s failed: %s: %s", method, resource, resp.Status, tppErr.Error)
+		}
+		return fmt.Errorf("%s %s failed: %s", method, resource, resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
+
+// authorize obtains an API key with user name and password
+func (c *tppAPIClient) authorize(user, password string) error {
+	var resp tppAuthorizeResponse
+	err := c.request(http.MethodPost, "authorize/", tppAuthorizeRequest{Username: user, Password: password}, &resp)
+	if err != nil {
+		return err
+	}
+	c.apiKey = resp.APIKey
+	return nil
+}
+
+// self returns the identity of the authorized user
+func (c *tppAPIClient) self() (*tppIdentity, error) {
+	var resp tppIdentitiesResponse
+	if err := c.request(http.MethodGet, "Identity/Self", nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Identities) == 0 {
+		return nil, fmt.Errorf("Identity/Self returned no identities")
+	}
+	return &resp.Identities[0], nil
+}
+
+// setPassword changes the password of the given local identity
+func (c *tppAPIClient) setPassword(id *tppIdentity, oldPassword, newPassword string) error {
+	return c.request(http.MethodPost, "Identity/SetPassword", tppSetPasswordRequest{
+		ID:          tppIdentity{PrefixedUniversal: id.PrefixedUniversal},
+		Password:    newPassword,
+		OldPassword: oldPassword,
+	}, nil)
+}
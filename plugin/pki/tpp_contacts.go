@@ -0,0 +1,111 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// vcert doesn't set contacts of the certificate object, so they are written with the Platform config API
+const tppContactAttribute = "Contact"
+
+type tppAttribute struct {
+	Name  string   `json:"Name"`
+	Value []string `json:"Value"`
+}
+
+type tppConfigWriteRequest struct {
+	ObjectDN      string         `json:"ObjectDN"`
+	AttributeData []tppAttribute `json:"AttributeData"`
+}
+
+type tppConfigWriteResponse struct {
+	Result int    `json:"Result"`
+	Error  string `json:"Error"`
+}
+
+// tppAPIURL returns the URL of the Platform API method, tpp_url can be specified with or without the vedsdk path
+func tppAPIURL(tppURL string, method string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(tppURL, "/"), "/vedsdk")
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "https://" + base
+	}
+	return base + "/vedsdk/" + method
+}
+
+// setTPPContacts sets contacts of the role as contacts of the certificate object, so TPP notifications reach them
+func (b *backend) setTPPContacts(ctx context.Context, role *roleEntry, tppURL string, certDN string) error {
+	client, err := b.getHTTPClient(role)
+	if err != nil {
+		return err
+	}
+
+	headers, err := tppAuthHeaders(ctx, client, role, tppURL)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(tppConfigWriteRequest{
+		ObjectDN:      certDN,
+		AttributeData: []tppAttribute{{Name: tppContactAttribute, Value: role.Contacts}},
+	})
+	if err != nil {
+		return err
+	}
+	var result tppConfigWriteResponse
+	if err := tppPost(ctx, client, tppAPIURL(tppURL, "Config/Write"), headers, body, &result); err != nil {
+		return err
+	}
+	//1 is AttributeValueWritten
+	if result.Result != 1 {
+		return fmt.Errorf("failed to set contacts of %s, result %d: %s", certDN, result.Result, result.Error)
+	}
+	return nil
+}
+
+// tppAuthHeaders returns the headers authenticating the Platform API requests with the role credentials
+func tppAuthHeaders(ctx context.Context, client *http.Client, role *roleEntry, tppURL string) (map[string]string, error) {
+	if role.AccessToken != "" {
+		return map[string]string{"Authorization": "Bearer " + role.AccessToken}, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"Username": role.TPPUser, "Password": role.TPPPassword})
+	if err != nil {
+		return nil, err
+	}
+	var auth struct {
+		APIKey string `json:"APIKey"`
+	}
+	if err := tppPost(ctx, client, tppAPIURL(tppURL, "authorize/"), nil, body, &auth); err != nil {
+		return nil, err
+	}
+	return map[string]string{"X-Venafi-Api-Key": auth.APIKey}, nil
+}
+
+func tppPost(ctx context.Context, client *http.Client, url string, headers map[string]string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status %s from %s: %s", resp.Status, url, respBody)
+	}
+	return json.Unmarshal(respBody, result)
+}
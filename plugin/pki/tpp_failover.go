@@ -0,0 +1,137 @@
+package pki
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/verror"
+)
+
+// vcert reports most of the HTTP failures only as text, so server errors are recognised by the status code in the message
+var serverErrorStatusRegex = regexp.MustCompile(`(?i)status[^0-9]{0,8}5\d\d\b`)
+
+// messages of network errors which vcert formats as text without wrapping
+var networkErrorMessages = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"i/o timeout",
+	"Client.Timeout exceeded",
+	"EOF",
+}
+
+// tppURLs returns tpp_url followed by tpp_failover_urls in the order they should be tried
+func (r *roleEntry) tppURLs() []string {
+	return append([]string{r.TPPURL}, r.TPPFailoverURLs...)
+}
+
+// isFailoverError returns true if the error means the Venafi Platform is unreachable or failed on the server side,
+// so the request can be retried against another endpoint
+func isFailoverError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, verror.ServerUnavailableError) {
+		return true
+	}
+	msg := err.Error()
+	for _, m := range networkErrorMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return serverErrorStatusRegex.MatchString(msg)
+}
+
+const (
+	tppLoadBalancingFailover     = "failover"
+	tppLoadBalancingRoundRobin   = "round_robin"
+	tppLoadBalancingLeastLatency = "least_latency"
+
+	//how long a Platform node is skipped after a failure
+	tppEndpointQuarantine = 30 * time.Second
+)
+
+// tppEndpointHealth tracks failures and latency of the Platform nodes across requests
+type tppEndpointHealth struct {
+	sync.Mutex
+	next      uint64
+	endpoints map[string]*tppEndpointState
+}
+
+type tppEndpointState struct {
+	unhealthyUntil time.Time
+	//moving average of successful requests duration
+	latency time.Duration
+}
+
+func (h *tppEndpointHealth) state(url string) *tppEndpointState {
+	if h.endpoints == nil {
+		h.endpoints = make(map[string]*tppEndpointState)
+	}
+	s, ok := h.endpoints[url]
+	if !ok {
+		s = &tppEndpointState{}
+		h.endpoints[url] = s
+	}
+	return s
+}
+
+// order returns urls in the order they should be tried according to the load balancing mode.
+// Nodes which failed recently are moved to the end, so they are used only if all other nodes fail too.
+func (h *tppEndpointHealth) order(urls []string, mode string) []string {
+	h.Lock()
+	defer h.Unlock()
+
+	ordered := make([]string, 0, len(urls))
+	switch mode {
+	case tppLoadBalancingRoundRobin:
+		start := int(h.next % uint64(len(urls)))
+		h.next++
+		ordered = append(append(ordered, urls[start:]...), urls[:start]...)
+	case tppLoadBalancingLeastLatency:
+		ordered = append(ordered, urls...)
+		//nodes without measurements have zero latency, so they are tried first and get measured
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return h.state(ordered[i]).latency < h.state(ordered[j]).latency
+		})
+	default:
+		ordered = append(ordered, urls...)
+	}
+
+	now := time.Now()
+	healthy := make([]string, 0, len(ordered))
+	var unhealthy []string
+	for _, url := range ordered {
+		if h.state(url).unhealthyUntil.After(now) {
+			unhealthy = append(unhealthy, url)
+		} else {
+			healthy = append(healthy, url)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (h *tppEndpointHealth) reportSuccess(url string, duration time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	s := h.state(url)
+	s.unhealthyUntil = time.Time{}
+	if s.latency == 0 {
+		s.latency = duration
+	} else {
+		s.latency = (s.latency*4 + duration) / 5
+	}
+}
+
+func (h *tppEndpointHealth) reportFailure(url string) {
+	h.Lock()
+	defer h.Unlock()
+	h.state(url).unhealthyUntil = time.Now().Add(tppEndpointQuarantine)
+}
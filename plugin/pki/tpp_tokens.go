@@ -0,0 +1,115 @@
+package pki
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/Venafi/vcert/pkg/venafi/tpp"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/helper/consts"
+	"github.com/hashicorp/vault/logical"
+)
+
+// accessTokenRefreshWindow is how long before expiration an access token is refreshed
+const accessTokenRefreshWindow = 10 * time.Minute
+
+// accessTokenNeedsRefresh returns true if the role has a refresh token and the access token
+// is expired, about to expire or has never been refreshed (so its expiration is unknown)
+func (r *roleEntry) accessTokenNeedsRefresh() bool {
+	if r.RefreshToken == "" {
+		return false
+	}
+	return r.AccessTokenExpiry.IsZero() || time.Until(r.AccessTokenExpiry) < accessTokenRefreshWindow
+}
+
+// refreshTPPAccessToken obtains a new access and refresh token pair from Venafi Platform
+// and persists it in the role. TPP invalidates the old refresh token, so refreshes are serialized.
+func (b *backend) refreshTPPAccessToken(ctx context.Context, s logical.Storage, roleName string) (*roleEntry, error) {
+	b.tokenRefreshLock.Lock()
+	defer b.tokenRefreshLock.Unlock()
+
+	role, err := b.getRole(ctx, s, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if role == nil {
+		return nil, fmt.Errorf("Unknown role %s", roleName)
+	}
+	//token could be already refreshed while we were waiting for the lock
+	if !role.accessTokenNeedsRefresh() {
+		return role, nil
+	}
+
+	//the new token pair must be stored, so the refresh can only be done on the active node
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil, logical.ErrReadOnly
+	}
+
+	b.Logger().Debug("Refreshing access token", "role", roleName)
+	trustBundle, err := b.getTrustPool(role)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := b.getHTTPClient(role)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := tpp.NewConnector(role.TPPURL, role.Zone, false, trustBundle)
+	if err != nil {
+		return nil, err
+	}
+	connector.SetHTTPClient(httpClient)
+	resp, err := connector.RefreshAccessToken(&endpoint.Authentication{
+		RefreshToken: role.RefreshToken,
+	})
+	if err != nil {
+		b.authStatus.reportFailure(role.TPPURL, err)
+		return nil, fmt.Errorf("failed to refresh access token for role %s: %s", roleName, err)
+	}
+	b.authStatus.reportSuccess(role.TPPURL)
+
+	role.AccessToken = resp.Access_token
+	if resp.Refresh_token != "" {
+		role.RefreshToken = resp.Refresh_token
+	}
+	role.AccessTokenExpiry = time.Unix(int64(resp.Expires), 0)
+
+	if err := b.putRole(ctx, s, roleName, role); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("Access token refreshed", "role", roleName, "expires", role.AccessTokenExpiry)
+
+	return role, nil
+}
+
+// refreshExpiringTPPTokens refreshes access tokens of all roles that are about to expire
+func (b *backend) refreshExpiringTPPTokens(ctx context.Context, s logical.Storage) error {
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	roles, err := s.List(ctx, "role/")
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, roleName := range roles {
+		role, err := b.getRole(ctx, s, roleName)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if role == nil || !role.accessTokenNeedsRefresh() {
+			continue
+		}
+		if _, err := b.refreshTPPAccessToken(ctx, s, roleName); err != nil {
+			b.Logger().Error("Failed to refresh access token", "role", roleName, "error", err)
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
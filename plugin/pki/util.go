@@ -3,6 +3,8 @@ package pki
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"sort"
@@ -20,6 +22,15 @@ func sliceContains(slice []string, item string) bool {
 	return ok
 }
 
+func intSliceContains(slice []int, item int) bool {
+	for _, i := range slice {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
 func getHexFormatted(buf []byte, sep string) (string, error) {
 	var ret bytes.Buffer
 	for _, cur := range buf {
@@ -97,6 +108,18 @@ func SameIpSlice(x, y []net.IP) bool {
 	return true
 }
 
+// readLimited reads the body of a response from outside of Venafi, failing if it is larger than limit bytes
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response is larger than %d bytes", limit)
+	}
+	return body, nil
+}
+
 func SameStringSlice(x, y []string) bool {
 	if len(x) != len(y) {
 		return false
@@ -0,0 +1,196 @@
+package pki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+)
+
+// vcert can't request the validity of the certificate, so the requests with ttl or not_after are submitted with the
+// Platform and Cloud APIs, and only the retrieval is left to vcert
+const (
+	//CA specific attribute the Platform CA drivers take the requested expiration date from
+	tppSpecificEndDateAttribute = "Specific End Date"
+	tppSpecificEndDateFormat    = "2006-01-02 15:04:05"
+	tppPolicyRoot               = "\\VED\\Policy"
+)
+
+type tppNameValue struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+type tppSANItem struct {
+	Type int    `json:"Type"`
+	Name string `json:"Name"`
+}
+
+type tppCustomField struct {
+	Name   string   `json:"Name"`
+	Values []string `json:"Values"`
+}
+
+// tppCertificateRequest is the Certificates/Request body vcert sends, with the expiration date added
+type tppCertificateRequest struct {
+	PolicyDN                string           `json:"PolicyDN,omitempty"`
+	CADN                    string           `json:"CADN,omitempty"`
+	ObjectName              string           `json:"ObjectName,omitempty"`
+	Subject                 string           `json:"Subject,omitempty"`
+	SubjectAltNames         []tppSANItem     `json:"SubjectAltNames,omitempty"`
+	CASpecificAttributes    []tppNameValue   `json:"CASpecificAttributes,omitempty"`
+	Origin                  string           `json:"Origin,omitempty"`
+	PKCS10                  string           `json:"PKCS10,omitempty"`
+	KeyAlgorithm            string           `json:"KeyAlgorithm,omitempty"`
+	KeyBitSize              int              `json:"KeyBitSize,omitempty"`
+	EllipticCurve           string           `json:"EllipticCurve,omitempty"`
+	DisableAutomaticRenewal bool             `json:"DisableAutomaticRenewal,omitempty"`
+	CustomFields            []tppCustomField `json:"CustomFields,omitempty"`
+}
+
+type tppCertificateRequestResponse struct {
+	CertificateDN string `json:"CertificateDN"`
+}
+
+// requestedValidity returns the expiration date requested with ttl or not_after, zero if none was requested
+func (r requestData) requestedValidity(now time.Time) time.Time {
+	if !r.notAfter.IsZero() {
+		return r.notAfter
+	}
+	if r.ttlRequested && r.ttl > 0 {
+		return now.Add(r.ttl)
+	}
+	return time.Time{}
+}
+
+// requestCertificateWithValidity submits the certificate request with the requested expiration date and returns the
+// request ID the certificate is retrieved with
+func (b *backend) requestCertificateWithValidity(ctx context.Context, role *roleEntry, tppURL string, zone string,
+	certReq *certificate.Request, notAfter time.Time) (string, error) {
+
+	if zone == "" {
+		zone = role.Zone
+	}
+	if role.TPPURL != "" && role.hasTPPCredentials() {
+		if tppURL == "" {
+			tppURL = role.TPPURL
+		}
+		return b.requestTPPCertificate(ctx, role, tppURL, zone, certReq, notAfter)
+	}
+	client, err := b.newCloudAPIClient(role)
+	if err != nil {
+		return "", err
+	}
+	return client.requestCertificate(zone, string(certReq.GetCSR()), time.Until(notAfter))
+}
+
+// requestTPPCertificate submits the request like vcert does, with the expiration date as CA specific attribute
+func (b *backend) requestTPPCertificate(ctx context.Context, role *roleEntry, tppURL string, zone string,
+	certReq *certificate.Request, notAfter time.Time) (string, error) {
+
+	tppReq, err := newTPPCertificateRequest(certReq, zone, notAfter)
+	if err != nil {
+		return "", err
+	}
+	client, err := b.getHTTPClient(role)
+	if err != nil {
+		return "", err
+	}
+	headers, err := tppAuthHeaders(ctx, client, role, tppURL)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(tppReq)
+	if err != nil {
+		return "", err
+	}
+	var result tppCertificateRequestResponse
+	if err := tppPost(ctx, client, tppAPIURL(tppURL, "Certificates/Request"), headers, body, &result); err != nil {
+		return "", err
+	}
+	if result.CertificateDN == "" {
+		return "", fmt.Errorf("Platform didn't return the DN of the requested certificate")
+	}
+	return result.CertificateDN, nil
+}
+
+func newTPPCertificateRequest(certReq *certificate.Request, zone string, notAfter time.Time) (*tppCertificateRequest, error) {
+	tppReq := &tppCertificateRequest{
+		PolicyDN:                tppPolicyDN(zone),
+		CADN:                    certReq.CADN,
+		ObjectName:              certReq.FriendlyName,
+		DisableAutomaticRenewal: true,
+	}
+	switch certReq.CsrOrigin {
+	case certificate.LocalGeneratedCSR, certificate.UserProvidedCSR:
+		tppReq.PKCS10 = string(certReq.GetCSR())
+	case certificate.ServiceGeneratedCSR:
+		tppReq.Subject = certReq.Subject.CommonName
+		if !certReq.OmitSANs {
+			for _, name := range certReq.EmailAddresses {
+				tppReq.SubjectAltNames = append(tppReq.SubjectAltNames, tppSANItem{1, name})
+			}
+			for _, name := range certReq.DNSNames {
+				tppReq.SubjectAltNames = append(tppReq.SubjectAltNames, tppSANItem{2, name})
+			}
+			for _, ip := range certReq.IPAddresses {
+				tppReq.SubjectAltNames = append(tppReq.SubjectAltNames, tppSANItem{7, ip.String()})
+			}
+		}
+		switch certReq.KeyType {
+		case certificate.KeyTypeRSA:
+			tppReq.KeyAlgorithm = "RSA"
+			tppReq.KeyBitSize = certReq.KeyLength
+		case certificate.KeyTypeECDSA:
+			tppReq.KeyAlgorithm = "ECC"
+			tppReq.EllipticCurve = certReq.KeyCurve.String()
+		}
+	default:
+		return nil, fmt.Errorf("unexpected CSR origin %v", certReq.CsrOrigin)
+	}
+
+	origin := endpoint.SDKName
+	customFields := map[string][]string{}
+	var names []string
+	for _, f := range certReq.CustomFields {
+		switch f.Type {
+		case certificate.CustomFieldPlain:
+			if _, ok := customFields[f.Name]; !ok {
+				names = append(names, f.Name)
+			}
+			customFields[f.Name] = append(customFields[f.Name], f.Value)
+		case certificate.CustomFieldOrigin:
+			origin = f.Value
+		}
+	}
+	for _, name := range names {
+		tppReq.CustomFields = append(tppReq.CustomFields, tppCustomField{Name: name, Values: customFields[name]})
+	}
+	tppReq.Origin = origin
+	tppReq.CASpecificAttributes = []tppNameValue{
+		{Name: "Origin", Value: origin},
+		{Name: tppSpecificEndDateAttribute, Value: notAfter.UTC().Format(tppSpecificEndDateFormat)},
+	}
+	return tppReq, nil
+}
+
+// tppPolicyDN returns the policy folder DN of the zone, the zone can be specified without the \VED\Policy prefix
+func tppPolicyDN(zone string) string {
+	if strings.HasPrefix(zone, tppPolicyRoot) {
+		return zone
+	}
+	if !strings.HasPrefix(zone, "\\") {
+		zone = "\\" + zone
+	}
+	return tppPolicyRoot + zone
+}
+
+// cloudValidityPeriod formats the validity as ISO 8601 duration in whole hours, rounded up
+func cloudValidityPeriod(validity time.Duration) string {
+	return fmt.Sprintf("PT%dH", int64(math.Ceil(validity.Hours())))
+}
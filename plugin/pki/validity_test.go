@@ -0,0 +1,129 @@
+package pki
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+)
+
+func TestRequestTPPCertificateValidity(t *testing.T) {
+	var requested tppCertificateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vedsdk/authorize/":
+			w.Write([]byte(`{"APIKey":"test-key"}`))
+		case "/vedsdk/Certificates/Request":
+			if r.Header.Get("X-Venafi-Api-Key") != "test-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"CertificateDN":"\\VED\\Policy\\DevOps\\validity.example.com"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b, _ := createBackendWithStorage(t)
+	role := &roleEntry{TPPURL: server.URL + "/vedsdk", TPPUser: "admin", TPPPassword: "secret", Zone: "DevOps"}
+	certReq := &certificate.Request{CsrOrigin: certificate.UserProvidedCSR, FriendlyName: "validity"}
+	if err := certReq.SetCSR(createValidityCSR(t)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	reqData := requestData{ttl: 720 * time.Hour, ttlRequested: true}
+	notAfter := reqData.requestedValidity(now)
+	if !notAfter.Equal(now.Add(720 * time.Hour)) {
+		t.Fatalf("Expecting validity of the requested ttl, got %s", notAfter)
+	}
+	if (requestData{ttl: 720 * time.Hour}).requestedValidity(now) != (time.Time{}) {
+		t.Fatalf("Expecting the default ttl of the role not to be sent to Venafi")
+	}
+	expiry := now.Add(1000 * time.Hour).Truncate(time.Second)
+	if validity := (requestData{ttl: time.Until(expiry), notAfter: expiry}).requestedValidity(now); !validity.Equal(expiry) {
+		t.Fatalf("Expecting validity of the requested not_after %s, got %s", expiry, validity)
+	}
+
+	requestID, err := b.requestCertificateWithValidity(context.Background(), role, "", "", certReq, notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "\\VED\\Policy\\DevOps\\validity.example.com" {
+		t.Fatalf("Unexpected request ID %s", requestID)
+	}
+	expected := notAfter.UTC().Format(tppSpecificEndDateFormat)
+	found := false
+	for _, a := range requested.CASpecificAttributes {
+		found = found || (a.Name == tppSpecificEndDateAttribute && a.Value == expected)
+	}
+	if !found || requested.PolicyDN != "\\VED\\Policy\\DevOps" || requested.ObjectName != "validity" || requested.PKCS10 == "" {
+		t.Fatalf("Expecting request with %s %s, got %#v", tppSpecificEndDateAttribute, expected, requested)
+	}
+}
+
+func TestRequestCloudCertificateValidity(t *testing.T) {
+	var requested cloudCertificateRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("tppl-api-key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/zones/tag/Default":
+			w.Write([]byte(`{"id":"zone-id"}`))
+		case "/v1/certificaterequests":
+			if err := json.NewDecoder(r.Body).Decode(&requested); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"certificateRequests":[{"id":"request-id"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b, _ := createBackendWithStorage(t)
+	role := &roleEntry{CloudURL: server.URL, Apikey: "test-key", Zone: "Default"}
+	certReq := &certificate.Request{CsrOrigin: certificate.UserProvidedCSR}
+	if err := certReq.SetCSR(createValidityCSR(t)); err != nil {
+		t.Fatal(err)
+	}
+	requestID, err := b.requestCertificateWithValidity(context.Background(), role, "", "", certReq, time.Now().Add(720*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requestID != "request-id" || requested.ZoneID != "zone-id" || requested.ValidityPeriod != "PT720H" || requested.CSR == "" {
+		t.Fatalf("Unexpected request %s %#v", requestID, requested)
+	}
+}
+
+func createValidityCSR(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "validity.venafi.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+}
@@ -2,18 +2,41 @@ package pki
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"github.com/Venafi/vcert"
 	"github.com/Venafi/vcert/pkg/endpoint"
 	"github.com/hashicorp/vault/logical"
 	"github.com/hashicorp/vault/logical/framework"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 func (b *backend) ClientVenafi(ctx context.Context, s logical.Storage, data *framework.FieldData, req *logical.Request, roleName string) (
 	endpoint.Connector, time.Duration, error) {
-	b.Logger().Debug(fmt.Sprintf("Using role: %s", roleName))
+	return b.clientVenafi(ctx, req, roleName, "", "")
+}
+
+// clientVenafi returns the client for the role. If tppURL or zone are not empty they are used instead of the role settings.
+// Clients are cached until the role is changed, so the authentication and connections are reused between requests.
+func (b *backend) clientVenafi(ctx context.Context, req *logical.Request, roleName string, tppURL string, zone string) (
+	endpoint.Connector, time.Duration, error) {
+	//the clients of the recently used roles are kept warm by the periodic function
+	b.clientCache.markUsed(roleName)
+	return b.venafiClient(ctx, req, roleName, tppURL, zone)
+}
+
+// venafiClient returns the cached or a new client for the role without recording its use
+func (b *backend) venafiClient(ctx context.Context, req *logical.Request, roleName string, tppURL string, zone string) (
+	endpoint.Connector, time.Duration, error) {
+	b.Logger().Debug("Using role", "role", roleName)
 	if roleName == "" {
 		return nil, 0, fmt.Errorf("Missing role name")
 	}
@@ -25,7 +48,39 @@ func (b *backend) ClientVenafi(ctx context.Context, s logical.Storage, data *fra
 	if role == nil {
 		return nil, 0, fmt.Errorf("Unknown role %v", role)
 	}
+	if zone == "" {
+		zone = role.Zone
+	}
+
+	if !role.Fakemode && role.TPPURL != "" && role.accessTokenNeedsRefresh() {
+		role, err = b.refreshTPPAccessToken(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
 
+	cacheKey, err := clientCacheKey(role, tppURL, zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	if client := b.clientCache.get(roleName, cacheKey); client != nil {
+		b.Logger().Debug("Using cached Venafi client")
+		return client, role.ServerTimeout, nil
+	}
+
+	client, err := b.newVenafiClient(role, tppURL, zone)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.clientCache.put(roleName, cacheKey, client)
+
+	return client, role.ServerTimeout, nil
+
+}
+
+// newVenafiClient creates a client from the role settings, e.g. to check the role before it is stored
+func (b *backend) newVenafiClient(role *roleEntry, tppURL string, zone string) (endpoint.Connector, error) {
+	var err error
 	var cfg *vcert.Config
 	if role.Fakemode {
 		b.Logger().Debug("Using fakemode to issue certificate")
@@ -33,59 +88,218 @@ func (b *backend) ClientVenafi(ctx context.Context, s logical.Storage, data *fra
 			ConnectorType: endpoint.ConnectorTypeFake,
 			LogVerbose:    true,
 		}
-	} else if role.TPPURL != "" && role.TPPUser != "" && role.TPPPassword != "" {
-		b.Logger().Debug("Using Platform with url %s to issue certificate\n", role.TPPURL)
-		if role.TrustBundleFile != "" {
-			b.Logger().Debug("Trying to read trust bundle from file %s\n", role.TrustBundleFile)
-			trustBundle, err := ioutil.ReadFile(role.TrustBundleFile)
-			if err != nil {
-				return nil, 0, err
-			}
-			trustBundlePEM := string(trustBundle)
-			cfg = &vcert.Config{
-				ConnectorType:   endpoint.ConnectorTypeTPP,
-				BaseUrl:         role.TPPURL,
-				ConnectionTrust: trustBundlePEM,
-				Credentials: &endpoint.Authentication{
-					User:     role.TPPUser,
-					Password: role.TPPPassword,
-				},
-				Zone:       role.Zone,
-				LogVerbose: true,
+	} else if role.TPPURL != "" && role.hasTPPCredentials() {
+		if tppURL == "" {
+			tppURL = role.TPPURL
+		}
+		b.Logger().Debug("Using Platform to issue certificate", "tpp_url", tppURL)
+		trustBundlePEM, err := b.getTrustBundle(role)
+		if err != nil {
+			return nil, err
+		}
+
+		var credentials *endpoint.Authentication
+		if role.AccessToken != "" {
+			b.Logger().Debug("Using access token to authenticate to Platform")
+			credentials = &endpoint.Authentication{
+				AccessToken: role.AccessToken,
 			}
 		} else {
-			cfg = &vcert.Config{
-				ConnectorType: endpoint.ConnectorTypeTPP,
-				BaseUrl:       role.TPPURL,
-				Credentials: &endpoint.Authentication{
-					User:     role.TPPUser,
-					Password: role.TPPPassword,
-				},
-				Zone:       role.Zone,
-				LogVerbose: true,
+			credentials = &endpoint.Authentication{
+				User:     role.TPPUser,
+				Password: role.TPPPassword,
 			}
 		}
 
+		cfg = &vcert.Config{
+			ConnectorType:   endpoint.ConnectorTypeTPP,
+			BaseUrl:         tppURL,
+			ConnectionTrust: trustBundlePEM,
+			Credentials:     credentials,
+			Zone:            zone,
+			LogVerbose:      true,
+		}
+
 	} else if role.Apikey != "" {
 		b.Logger().Debug("Using Cloud to issue certificate")
+		trustBundlePEM, err := b.getTrustBundle(role)
+		if err != nil {
+			return nil, err
+		}
 		cfg = &vcert.Config{
-			ConnectorType: endpoint.ConnectorTypeCloud,
-			BaseUrl:       role.CloudURL,
+			ConnectorType:   endpoint.ConnectorTypeCloud,
+			BaseUrl:         role.CloudURL,
+			ConnectionTrust: trustBundlePEM,
 			Credentials: &endpoint.Authentication{
 				APIKey: role.Apikey,
 			},
-			Zone:       role.Zone,
+			Zone:       zone,
 			LogVerbose: true,
 		}
 	} else {
-		return nil, 0, fmt.Errorf("failed to build config for Venafi issuer")
+		return nil, fmt.Errorf("failed to build config for Venafi issuer")
 	}
 
+	if cfg.ConnectorType != endpoint.ConnectorTypeFake {
+		cfg.Client, err = b.getHTTPClient(role)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	endpointURL := cfg.BaseUrl
+	if endpointURL == "" {
+		endpointURL = defaultCloudURL
+	}
+	//the client authenticates when it is created
 	client, err := vcert.NewClient(cfg)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get Venafi issuer client: %s", err)
+		if cfg.ConnectorType != endpoint.ConnectorTypeFake {
+			b.authStatus.reportFailure(endpointURL, err)
+		}
+		return nil, fmt.Errorf("failed to get Venafi issuer client: %s", err)
+	}
+	if cfg.ConnectorType != endpoint.ConnectorTypeFake {
+		b.authStatus.reportSuccess(endpointURL)
 	}
+	return client, nil
+}
 
-	return client, role.ServerTimeout, nil
+func (b *backend) getTrustBundle(role *roleEntry) (string, error) {
+	if role.TrustBundlePEM != "" {
+		b.Logger().Debug("Using trust bundle stored in the role")
+		return role.TrustBundlePEM, nil
+	}
+	if role.TrustBundleFile == "" {
+		return "", nil
+	}
+	b.Logger().Debug("Trying to read trust bundle from file", "file", role.TrustBundleFile)
+	trustBundle, err := ioutil.ReadFile(role.TrustBundleFile)
+	if err != nil {
+		return "", err
+	}
+	return string(trustBundle), nil
+}
+
+func (b *backend) getTrustPool(role *roleEntry) (*x509.CertPool, error) {
+	trustBundlePEM, err := b.getTrustBundle(role)
+	if err != nil || trustBundlePEM == "" {
+		return nil, err
+	}
+	trustBundle := x509.NewCertPool()
+	if !trustBundle.AppendCertsFromPEM([]byte(trustBundlePEM)) {
+		return nil, fmt.Errorf("failed to parse PEM trust bundle")
+	}
+	return trustBundle, nil
+}
+
+// getHTTPClient returns an HTTP client used for all calls to the Venafi API.
+// Roles with the same connection settings share the transport, so the connections are reused.
+// The calls are logged if log_venafi_requests is configured.
+func (b *backend) getHTTPClient(role *roleEntry) (*http.Client, error) {
+	transport, err := b.transports.get(b, role)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: loggingTransport{b, transport},
+	}, nil
+}
+
+// getCAHTTPClient returns the client for the CRL and OCSP endpoints of the CA. They aren't Venafi endpoints, so the
+// trust bundle and the pinned certificates of the role don't apply to them, only its proxy and connection settings
+func (b *backend) getCAHTTPClient(role *roleEntry) (*http.Client, error) {
+	caRole := *role
+	caRole.TrustBundleFile, caRole.TrustBundlePEM, caRole.ServerCertFingerprints = "", "", nil
+	transport, err := b.transports.get(b, &caRole)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}, nil
+}
+
+func (b *backend) newTransport(role *roleEntry) (*http.Transport, error) {
+	trustBundle, err := b.getTrustPool(role)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{RootCAs: trustBundle}
+	if len(role.ServerCertFingerprints) > 0 {
+		//without trust bundle pinned fingerprints are used instead of the chain validation
+		if trustBundle == nil {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		tlsConfig.VerifyPeerCertificate = verifyPinnedCertificate(role.ServerCertFingerprints)
+	}
+	proxy := http.ProxyFromEnvironment
+	proxyURL, err := role.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		b.Logger().Debug("Using proxy", "proxy", proxyURL.Scheme+"://"+proxyURL.Host)
+		proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: role.tcpKeepAlive(),
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        role.maxIdleConns(),
+		MaxIdleConnsPerHost: role.maxIdleConns(),
+		IdleConnTimeout:     role.idleConnTimeout(),
+	}, nil
+}
 
+// proxyURL returns the proxy configured in the role or nil if environment settings should be used
+func (r *roleEntry) proxyURL() (*url.URL, error) {
+	var proxy, scheme string
+	switch {
+	case r.HTTPProxy != "":
+		proxy, scheme = r.HTTPProxy, "http"
+	case r.SOCKS5Proxy != "":
+		proxy, scheme = r.SOCKS5Proxy, "socks5"
+	default:
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = scheme + "://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %s", proxy, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %s: missing host", proxy)
+	}
+	if scheme == "socks5" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("invalid proxy %s: socks5_proxy must use socks5 scheme", proxy)
+	}
+	if scheme == "http" && u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid proxy %s: http_proxy must use http or https scheme", proxy)
+	}
+	return u, nil
+}
+
+// verifyPinnedCertificate returns a function rejecting connections unless the server certificate
+// SHA-256 fingerprint is one of the pinned fingerprints
+func verifyPinnedCertificate(fingerprints []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server didn't present a certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		fingerprint := hex.EncodeToString(sum[:])
+		if !sliceContains(fingerprints, fingerprint) {
+			return fmt.Errorf("server certificate fingerprint %s doesn't match any of server_cert_fingerprints", fingerprint)
+		}
+		return nil
+	}
 }
@@ -0,0 +1,18 @@
+package pki
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pluginVersion is set at build time with -ldflags "-X github.com/Venafi/vault-pki-backend-venafi/plugin/pki.pluginVersion=<version>"
+var pluginVersion = "dev"
+
+// originTag returns the value of the Origin custom field set on the Venafi requests, so it can be traced which mount
+// requested the certificate
+func (r *roleEntry) originTag(mountPoint string) string {
+	if r.Origin != "" {
+		return r.Origin
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", utilityName, strings.TrimSuffix(mountPoint, "/"), pluginVersion))
+}
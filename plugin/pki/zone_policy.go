@@ -0,0 +1,203 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Venafi/vcert/pkg/certificate"
+	"github.com/Venafi/vcert/pkg/endpoint"
+	"github.com/hashicorp/go-hclog"
+)
+
+// roleKeyType returns the vcert key type of the role key_type
+func (r *roleEntry) roleKeyType() (certificate.KeyType, bool) {
+	switch r.KeyType {
+	case "rsa":
+		return certificate.KeyTypeRSA, true
+	case "ec":
+		return certificate.KeyTypeECDSA, true
+	}
+	return 0, false
+}
+
+// validateZoneKeyPolicy checks that the key settings of the role are allowed by the zone, so a role which can't issue
+// certificates is rejected at write time
+func validateZoneKeyPolicy(role *roleEntry, zoneConfig *endpoint.ZoneConfiguration) error {
+	keyType, ok := role.roleKeyType()
+	if !ok || len(zoneConfig.AllowedKeyConfigurations) == 0 {
+		return nil
+	}
+
+	var allowedTypes []string
+	for _, kc := range zoneConfig.AllowedKeyConfigurations {
+		allowedTypes = append(allowedTypes, kc.KeyType.String())
+		if kc.KeyType != keyType {
+			continue
+		}
+		switch keyType {
+		case certificate.KeyTypeRSA:
+			if len(kc.KeySizes) > 0 && !intSliceContains(kc.KeySizes, role.KeyBits) {
+				return fmt.Errorf("key_bits %d is not allowed by the zone policy, allowed RSA key sizes: %v", role.KeyBits, kc.KeySizes)
+			}
+		case certificate.KeyTypeECDSA:
+			var curve certificate.EllipticCurve
+			_ = curve.Set(role.KeyCurve)
+			var allowedCurves []string
+			for _, c := range kc.KeyCurves {
+				if c == curve {
+					return nil
+				}
+				allowedCurves = append(allowedCurves, c.String())
+			}
+			if len(kc.KeyCurves) > 0 {
+				return fmt.Errorf("key_curve %s is not allowed by the zone policy, allowed curves: %v", role.KeyCurve, allowedCurves)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("key_type %s is not allowed by the zone policy, allowed key types: %v", role.KeyType, allowedTypes)
+}
+
+// policyRequest contains the values of the certificate request which are checked against the synced zone policy
+type policyRequest struct {
+	commonName     string
+	dnsNames       []string
+	ipAddresses    []net.IP
+	emailAddresses []string
+	uris           []*url.URL
+	subject        pkix.Name
+	keyType        string
+	keyBits        int
+	keyCurve       string
+}
+
+// newPolicyRequest returns the values of the request which would be sent to Venafi, from the CSR if it is signed
+func newPolicyRequest(reqData requestData, role *roleEntry, csr *x509.CertificateRequest, logger hclog.Logger) (*policyRequest, error) {
+	if csr != nil {
+		r := &policyRequest{
+			commonName:     csr.Subject.CommonName,
+			dnsNames:       csr.DNSNames,
+			ipAddresses:    csr.IPAddresses,
+			emailAddresses: csr.EmailAddresses,
+			uris:           csr.URIs,
+			subject:        csr.Subject,
+		}
+		switch key := csr.PublicKey.(type) {
+		case *rsa.PublicKey:
+			r.keyType, r.keyBits = "rsa", key.N.BitLen()
+		case *ecdsa.PublicKey:
+			r.keyType, r.keyCurve = "ec", strings.Replace(key.Curve.Params().Name, "-", "", 1)
+		default:
+			return nil, fmt.Errorf("unsupported CSR key type %T", csr.PublicKey)
+		}
+		return r, nil
+	}
+	certReq, err := formRequest(reqData, role, false, logger)
+	if err != nil {
+		return nil, err
+	}
+	return &policyRequest{
+		commonName:     certReq.Subject.CommonName,
+		dnsNames:       certReq.DNSNames,
+		ipAddresses:    certReq.IPAddresses,
+		emailAddresses: certReq.EmailAddresses,
+		uris:           certReq.URIs,
+		subject:        certReq.Subject,
+		keyType:        role.KeyType,
+		keyBits:        role.KeyBits,
+		keyCurve:       role.KeyCurve,
+	}, nil
+}
+
+// matchesPolicyRegex returns true if the value matches one of the regular expressions, the same way Venafi checks it
+func matchesPolicyRegex(value string, regexes []string) bool {
+	for _, r := range regexes {
+		if matched, err := regexp.MatchString(r, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolicyRequest checks the request against the zone policy synced to venafi-policy/<name>, so the request is
+// rejected with the name of the value which is not allowed instead of the CA error
+func validatePolicyRequest(name string, policy *venafiPolicy, r *policyRequest) error {
+	if !matchesPolicyRegex(r.commonName, policy.SubjectCNRegexes) {
+		return fmt.Errorf("common name %q is not allowed by policy %s, it must match one of %v", r.commonName, name,
+			policy.SubjectCNRegexes)
+	}
+	for _, dnsName := range append([]string{r.commonName}, r.dnsNames...) {
+		if strings.HasPrefix(dnsName, "*.") && !policy.AllowWildcards {
+			return fmt.Errorf("wildcard %q is not allowed by policy %s", dnsName, name)
+		}
+	}
+	for _, dnsName := range r.dnsNames {
+		if !matchesPolicyRegex(dnsName, policy.DNSSANRegexes) {
+			return fmt.Errorf("DNS SAN %q is not allowed by policy %s, it must match one of %v", dnsName, name,
+				policy.DNSSANRegexes)
+		}
+	}
+	for _, ip := range r.ipAddresses {
+		if !matchesPolicyRegex(ip.String(), policy.IPSANRegexes) {
+			return fmt.Errorf("IP SAN %s is not allowed by policy %s, it must match one of %v", ip, name, policy.IPSANRegexes)
+		}
+	}
+	for _, email := range r.emailAddresses {
+		if !matchesPolicyRegex(email, policy.EmailSANRegexes) {
+			return fmt.Errorf("email SAN %q is not allowed by policy %s, it must match one of %v", email, name,
+				policy.EmailSANRegexes)
+		}
+	}
+	for _, uri := range r.uris {
+		if !matchesPolicyRegex(uri.String(), policy.URISANRegexes) {
+			return fmt.Errorf("URI SAN %q is not allowed by policy %s, it must match one of %v", uri, name,
+				policy.URISANRegexes)
+		}
+	}
+
+	//empty subject fields are filled with the zone defaults by Venafi
+	for _, field := range []struct {
+		name    string
+		values  []string
+		regexes []string
+	}{
+		{"organization", r.subject.Organization, policy.SubjectORegexes},
+		{"organizational_unit", r.subject.OrganizationalUnit, policy.SubjectOURegexes},
+		{"country", r.subject.Country, policy.SubjectCRegexes},
+		{"province", r.subject.Province, policy.SubjectSTRegexes},
+		{"locality", r.subject.Locality, policy.SubjectLRegexes},
+	} {
+		for _, value := range field.values {
+			if !matchesPolicyRegex(value, field.regexes) {
+				return fmt.Errorf("%s %q is not allowed by policy %s, it must match one of %v", field.name, value, name,
+					field.regexes)
+			}
+		}
+	}
+
+	if len(policy.AllowedKeyConfigurations) == 0 {
+		return nil
+	}
+	var allowedTypes []string
+	for _, kc := range policy.AllowedKeyConfigurations {
+		allowedTypes = append(allowedTypes, kc.KeyType)
+		if kc.KeyType != r.keyType {
+			continue
+		}
+		if r.keyType == "rsa" && !intSliceContains(kc.KeyBits, r.keyBits) {
+			return fmt.Errorf("RSA key size %d is not allowed by policy %s, allowed key sizes: %v", r.keyBits, name, kc.KeyBits)
+		}
+		if r.keyType == "ec" && !sliceContains(kc.KeyCurves, r.keyCurve) {
+			return fmt.Errorf("key curve %s is not allowed by policy %s, allowed curves: %v", r.keyCurve, name, kc.KeyCurves)
+		}
+		return nil
+	}
+	return fmt.Errorf("key type %s is not allowed by policy %s, allowed key types: %v", r.keyType, name, allowedTypes)
+}